@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"rfm_cluster/pkg/artifact"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RunArtifacts 一次运行生成的所有产物
+type RunArtifacts struct {
+	RunID     string          `json:"run_id"`
+	Artifacts []artifact.Info `json:"artifacts"`
+}
+
+// artifactURL 返回产物的下载地址
+func artifactURL(runID, name string) string {
+	return fmt.Sprintf("/runs/%s/artifacts/%s", runID, name)
+}
+
+// ListArtifacts 列出一次运行的所有产物
+func ListArtifacts(c *gin.Context) {
+	runID := c.Param("id")
+
+	infos, err := artifactStore.List(runID)
+	if err != nil {
+		abortWithArtifactError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, RunArtifacts{RunID: runID, Artifacts: infos})
+}
+
+// DownloadArtifact 以附件形式流式返回一个产物
+func DownloadArtifact(c *gin.Context) {
+	runID, name := c.Param("id"), c.Param("name")
+
+	reader, info, err := artifactStore.Open(runID, name)
+	if err != nil {
+		abortWithArtifactError(c, err)
+		return
+	}
+	defer reader.Close()
+
+	c.DataFromReader(http.StatusOK, info.Size, info.ContentType, reader, map[string]string{
+		"Content-Disposition": fmt.Sprintf(`attachment; filename="%s"`, info.Name),
+	})
+}
+
+// DeleteRun 删除一次运行及其所有产物
+func DeleteRun(c *gin.Context) {
+	if err := artifactStore.Delete(c.Param("id")); err != nil {
+		abortWithArtifactError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// PinRun 固定一次运行，保留策略不会删除固定的运行，用作之后比较的基准
+func PinRun(c *gin.Context) {
+	if err := artifact.Pin(artifactStore, c.Param("id")); err != nil {
+		abortWithArtifactError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// UnpinRun 取消固定一次运行，之后按保留策略删除
+func UnpinRun(c *gin.Context) {
+	if err := artifact.Unpin(artifactStore, c.Param("id")); err != nil {
+		abortWithArtifactError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// abortWithArtifactError 把无效的运行ID或产物名称转换为400，不存在的转换为404，其余错误按500处理
+func abortWithArtifactError(c *gin.Context, err error) {
+	var invalid *artifact.InvalidError
+	if errors.As(err, &invalid) {
+		abortWithError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err)
+		return
+	}
+	if errors.Is(err, artifact.ErrNotFound) {
+		abortWithError(c, http.StatusNotFound, ErrCodeNotFound, err)
+		return
+	}
+
+	abortWithError(c, http.StatusInternalServerError, ErrCodeInternal, err)
+}
@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"rfm_cluster/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CacheInfo 缓存统计及所有缓存键
+type CacheInfo struct {
+	Stats models.CacheStats `json:"stats"`
+	Keys  []string          `json:"keys"`
+}
+
+// GetCache 返回缓存命中统计和缓存键
+func GetCache(c *gin.Context) {
+	if analysisCache == nil {
+		c.JSON(http.StatusOK, CacheInfo{Keys: []string{}})
+		return
+	}
+
+	keys, err := analysisCache.Keys()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, ErrCodeInternal, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, CacheInfo{Stats: analysisCache.Stats(), Keys: keys})
+}
+
+// PurgeCache 清空所有缓存的分析结果
+func PurgeCache(c *gin.Context) {
+	if analysisCache != nil {
+		if err := analysisCache.Purge(); err != nil {
+			abortWithError(c, http.StatusInternalServerError, ErrCodeInternal, err)
+			return
+		}
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// InvalidateCache 删除指定的缓存条目
+func InvalidateCache(c *gin.Context) {
+	key := c.Param("key")
+
+	found := false
+	if analysisCache != nil {
+		var err error
+		found, err = analysisCache.Invalidate(key)
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, ErrCodeInternal, err)
+			return
+		}
+	}
+
+	if !found {
+		abortWithError(c, http.StatusNotFound, ErrCodeNotFound, fmt.Errorf("cache entry %q not found", key),
+			ErrorDetail{In: "path", Name: "key", Reason: "not found"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
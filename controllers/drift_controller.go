@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"net/http"
+	"rfm_cluster/models"
+	"rfm_cluster/pkg/artifact"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 分组结果明细的产物名称，用于比较不同运行的分群结构
+const runAssignments = "assignments.ndjson"
+
+// WriteAssignments 把分为k组时的分组结果明细写入运行runID的产物assignments.ndjson，不计算轮廓系数
+func WriteAssignments(store artifact.Store, runID string, analysis *models.Analysis, k int) error {
+	w, err := store.Create(runID, runAssignments)
+	if err != nil {
+		return err
+	}
+
+	writer, err := models.NewAssignmentWriter(models.FormatNDJSON, w, 0)
+	if err != nil {
+		w.Close()
+		return err
+	}
+	err = models.EachAssignment(analysis, k, false, writer.Write)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+// loadRunSnapshot 从运行的产物中读取分群模型和分组结果明细
+func loadRunSnapshot(runID string) (*models.RunSnapshot, error) {
+	reader, _, err := artifactStore.Open(runID, segmentationModel)
+	if err != nil {
+		return nil, err
+	}
+	model, err := models.LoadSegmentationModel(reader)
+	reader.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	reader, _, err = artifactStore.Open(runID, runAssignments)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	assignments, err := models.ReadAssignments(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.RunSnapshot{Model: model, Assignments: assignments}, nil
+}
+
+// DriftOptions 比较两次运行的选项
+type DriftOptions struct {
+	// 基准运行
+	Baseline string `form:"baseline" binding:"required"`
+	models.DriftThresholds
+}
+
+// CompareRunDrift 比较运行与基准运行的分群结构，包括分组中心偏移、分组占比变化、
+// R、F、M分布的群体稳定性指数和共同用户的调整兰德指数
+func CompareRunDrift(c *gin.Context) {
+	options := DriftOptions{}
+	if err := c.ShouldBindQuery(&options); err != nil {
+		abortWithError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err, ErrorDetail{In: "query", Reason: err.Error()})
+		return
+	}
+
+	baseline, err := loadRunSnapshot(options.Baseline)
+	if err != nil {
+		abortWithArtifactError(c, err)
+		return
+	}
+	current, err := loadRunSnapshot(c.Param("id"))
+	if err != nil {
+		abortWithArtifactError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.CompareRuns(baseline, current, options.DriftThresholds))
+}
@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"rfm_cluster/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 错误码
+const (
+	ErrCodeInvalidRequest = "invalid_request"
+	ErrCodeNotFound       = "not_found"
+	ErrCodeUnprocessable  = "unprocessable_data"
+	ErrCodeInternal       = "internal_error"
+)
+
+// ErrorResponse 所有接口统一的错误返回结构
+type ErrorResponse struct {
+	Code    string        `json:"code"`
+	Message string        `json:"message"`
+	Details []ErrorDetail `json:"details,omitempty"`
+}
+
+// ErrorDetail 指出具体是哪个参数出了问题
+type ErrorDetail struct {
+	In     string `json:"in"`
+	Name   string `json:"name,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// abortWithError 以结构化错误结束请求
+func abortWithError(c *gin.Context, status int, code string, err error, details ...ErrorDetail) {
+	c.AbortWithStatusJSON(status, ErrorResponse{
+		Code:    code,
+		Message: err.Error(),
+		Details: details,
+	})
+}
+
+// abortWithParamError 把models.ParamError转换为400，其余错误按500处理
+func abortWithParamError(c *gin.Context, err error) {
+	var paramErr *models.ParamError
+	if errors.As(err, &paramErr) {
+		abortWithError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err,
+			ErrorDetail{In: "query", Name: paramErr.Name, Reason: paramErr.Reason})
+		return
+	}
+
+	abortWithError(c, http.StatusInternalServerError, ErrCodeInternal, err)
+}
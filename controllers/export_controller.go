@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"rfm_cluster/models"
+	"rfm_cluster/pkg/artifact"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+// ExportExcel 直接把分组结果工作簿流式返回给浏览器，不落盘
+func ExportExcel(c *gin.Context) {
+	params, ok := bindAnalysisParams(c)
+	if !ok {
+		return
+	}
+
+	options := models.ExportOptions{}
+	if err := c.ShouldBindQuery(&options); err != nil {
+		abortWithError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err, ErrorDetail{In: "query", Reason: err.Error()})
+		return
+	}
+
+	analysis, _, ok := loadAnalysis(c, params)
+	if !ok {
+		return
+	}
+	k := analysis.SelectedK(params)
+
+	excel, err := models.NewClusteredWorkbook(analysis, k, options)
+	if err != nil {
+		abortWithParamError(c, err)
+		return
+	}
+	defer excel.Close()
+
+	writeWorkbook(c, excel, fmt.Sprintf("clustered_data_%s_k%d.xlsx", analysis.Key[:8], k))
+}
+
+// ExportReport 直接流式返回包含汇总、轮廓系数、运行参数和各组成员的分析报告
+func ExportReport(c *gin.Context) {
+	params, ok := bindAnalysisParams(c)
+	if !ok {
+		return
+	}
+
+	analysis, _, ok := loadAnalysis(c, params)
+	if !ok {
+		return
+	}
+	k := analysis.SelectedK(params)
+
+	excel, err := models.NewReportWorkbook(analysis, k)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, ErrCodeInternal, err)
+		return
+	}
+	defer excel.Close()
+
+	writeWorkbook(c, excel, fmt.Sprintf("rfm_report_%s_k%d.xlsx", analysis.Key[:8], k))
+}
+
+// writeWorkbook 以附件形式把工作簿写入响应
+func writeWorkbook(c *gin.Context, excel *excelize.File, filename string) {
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Header("Content-Type", artifact.ContentType(filename))
+	c.Status(http.StatusOK)
+	if err := excel.Write(c.Writer); err != nil {
+		c.Error(err)
+	}
+}
+
+// AssignmentExportOptions 分组结果明细的导出选项
+type AssignmentExportOptions struct {
+	Format     string `form:"format,default=csv"`
+	Silhouette bool   `form:"silhouette,default=true"`
+	// 大于0时只导出软聚类中归属概率不超过该值的用户
+	MaxProbability float64 `form:"max_probability"`
+}
+
+// ExportAssignments 以CSV、NDJSON或Parquet格式流式返回每个用户的分组结果明细
+func ExportAssignments(c *gin.Context) {
+	params, ok := bindAnalysisParams(c)
+	if !ok {
+		return
+	}
+
+	options := AssignmentExportOptions{}
+	if err := c.ShouldBindQuery(&options); err != nil {
+		abortWithError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err, ErrorDetail{In: "query", Reason: err.Error()})
+		return
+	}
+
+	analysis, _, ok := loadAnalysis(c, params)
+	if !ok {
+		return
+	}
+	k := analysis.SelectedK(params)
+
+	components := 0
+	if analysis.SoftPartition(k) != nil {
+		components = k
+	}
+	writer, err := models.NewAssignmentWriter(options.Format, c.Writer, components)
+	if err != nil {
+		abortWithParamError(c, err)
+		return
+	}
+
+	filename := fmt.Sprintf("assignments_%s_k%d%s", analysis.Key[:8], k, models.AssignmentExtension(options.Format))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Header("Content-Type", artifact.ContentType(filename))
+	c.Status(http.StatusOK)
+
+	write := writer.Write
+	if options.MaxProbability > 0 {
+		write = func(a *models.Assignment) error {
+			if a.Probability == nil || *a.Probability > options.MaxProbability {
+				return nil
+			}
+			return writer.Write(a)
+		}
+	}
+
+	err = models.EachAssignment(analysis, k, options.Silhouette, write)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		// 响应头已经发出，只能记录错误并中断
+		c.Error(err)
+		c.Abort()
+	}
+}
@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"rfm_cluster/models"
+	"rfm_cluster/pkg/clusters"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// incremental 当前的增量分群状态，未初始化时为nil
+	incremental *models.IncrementalSegmentation
+	// incrementalCheckpoint 增量分群状态的保存路径，为空时只保存在内存中
+	incrementalCheckpoint string
+	// incrementalLock 保证同一时间只有一个请求修改增量分群状态
+	incrementalLock sync.Mutex
+)
+
+// SetIncrementalCheckpoint 配置增量分群状态的保存路径，文件已存在时从中恢复状态
+func SetIncrementalCheckpoint(path string) error {
+	incrementalLock.Lock()
+	defer incrementalLock.Unlock()
+
+	incrementalCheckpoint = path
+	if path == "" {
+		return nil
+	}
+
+	state, err := models.LoadCheckpoint(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	incremental = state
+	return nil
+}
+
+// checkpointIncremental 保存增量分群状态，调用方需要持有incrementalLock
+func checkpointIncremental() error {
+	if incrementalCheckpoint == "" {
+		return nil
+	}
+	return incremental.Checkpoint(incrementalCheckpoint)
+}
+
+// IncrementalOptions 初始化增量分群的选项
+type IncrementalOptions struct {
+	// 每吸收一个用户前各分组累计的权重乘以decay，1为不衰减
+	Decay float64 `form:"decay,default=0.999"`
+	// 分组中心偏移超过该值时重新完整拟合
+	DriftThreshold float64 `form:"drift_threshold,default=0.5"`
+}
+
+// IncrementalSegment 增量分群中一个分组的当前状态
+type IncrementalSegment struct {
+	Cluster int                  `json:"cluster"`
+	Name    string               `json:"name"`
+	Center  clusters.Coordinates `json:"center"`
+	// 按衰减累计的权重
+	Weight float64 `json:"weight"`
+}
+
+// IncrementalSummary 增量分群状态的概要，不包含每个用户的状态
+type IncrementalSummary struct {
+	AnalysisKey    string               `json:"analysis_key"`
+	Decay          float64              `json:"decay"`
+	DriftThreshold float64              `json:"drift_threshold"`
+	Drift          float64              `json:"drift"`
+	Updates        int                  `json:"updates"`
+	Refits         int                  `json:"refits"`
+	Customers      int                  `json:"customers"`
+	Segments       []IncrementalSegment `json:"segments"`
+	UpdatedAt      time.Time            `json:"updated_at"`
+}
+
+func summarizeIncremental(s *models.IncrementalSegmentation) IncrementalSummary {
+	summary := IncrementalSummary{
+		AnalysisKey:    s.Model.AnalysisKey,
+		Decay:          s.Online.Decay,
+		DriftThreshold: s.DriftThreshold,
+		Drift:          s.Online.Drift(),
+		Updates:        s.Online.Updates,
+		Refits:         s.Refits,
+		Customers:      len(s.Customers),
+		UpdatedAt:      s.UpdatedAt,
+	}
+	for si, segment := range s.Model.Segments {
+		summary.Segments = append(summary.Segments, IncrementalSegment{
+			Cluster: segment.Cluster,
+			Name:    segment.Name,
+			Center:  s.Online.Centers[si],
+			Weight:  s.Online.Weights[si],
+		})
+	}
+	return summary
+}
+
+// StartIncremental 以分析结果为起点（重新）开始增量分群，替换已有的状态
+func StartIncremental(c *gin.Context) {
+	params, ok := bindAnalysisParams(c)
+	if !ok {
+		return
+	}
+
+	options := IncrementalOptions{}
+	if err := c.ShouldBindQuery(&options); err != nil {
+		abortWithError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err, ErrorDetail{In: "query", Reason: err.Error()})
+		return
+	}
+
+	analysis, _, ok := loadAnalysis(c, params)
+	if !ok {
+		return
+	}
+	k := analysis.SelectedK(params)
+
+	state, err := models.NewIncrementalSegmentation(analysis, k, options.Decay, options.DriftThreshold)
+	if err != nil {
+		abortWithParamError(c, err)
+		return
+	}
+
+	incrementalLock.Lock()
+	defer incrementalLock.Unlock()
+
+	incremental = state
+	if err := checkpointIncremental(); err != nil {
+		abortWithError(c, http.StatusInternalServerError, ErrCodeInternal, err)
+		return
+	}
+	c.JSON(http.StatusOK, summarizeIncremental(incremental))
+}
+
+// GetIncremental 返回增量分群状态的概要
+func GetIncremental(c *gin.Context) {
+	incrementalLock.Lock()
+	defer incrementalLock.Unlock()
+
+	if !requireIncremental(c) {
+		return
+	}
+	c.JSON(http.StatusOK, summarizeIncremental(incremental))
+}
+
+// UpdateIncremental 按顺序吸收一批新增或变化的用户，返回它们的分组
+func UpdateIncremental(c *gin.Context) {
+	request := PredictRequest{}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		abortWithError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err, ErrorDetail{In: "body", Reason: err.Error()})
+		return
+	}
+
+	incrementalLock.Lock()
+	defer incrementalLock.Unlock()
+
+	if !requireIncremental(c) {
+		return
+	}
+	update, err := incremental.Update(request.Users)
+	if err != nil {
+		abortWithError(c, http.StatusUnprocessableEntity, ErrCodeUnprocessable, err)
+		return
+	}
+	if err := checkpointIncremental(); err != nil {
+		abortWithError(c, http.StatusInternalServerError, ErrCodeInternal, err)
+		return
+	}
+	c.JSON(http.StatusOK, update)
+}
+
+// RefitIncremental 不等偏移超过阈值，立即用全部已知用户重新完整拟合
+func RefitIncremental(c *gin.Context) {
+	incrementalLock.Lock()
+	defer incrementalLock.Unlock()
+
+	if !requireIncremental(c) {
+		return
+	}
+	if err := incremental.Refit(); err != nil {
+		abortWithError(c, http.StatusUnprocessableEntity, ErrCodeUnprocessable, err)
+		return
+	}
+	if err := checkpointIncremental(); err != nil {
+		abortWithError(c, http.StatusInternalServerError, ErrCodeInternal, err)
+		return
+	}
+	c.JSON(http.StatusOK, summarizeIncremental(incremental))
+}
+
+// requireIncremental 增量分群未初始化时返回404，调用方需要持有incrementalLock
+func requireIncremental(c *gin.Context) bool {
+	if incremental == nil {
+		abortWithError(c, http.StatusNotFound, ErrCodeNotFound, errors.New("incremental segmentation is not started, POST /incremental first"))
+		return false
+	}
+	return true
+}
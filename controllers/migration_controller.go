@@ -0,0 +1,165 @@
+package controllers
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"rfm_cluster/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-echarts/go-echarts/v2/charts"
+	"github.com/go-echarts/go-echarts/v2/opts"
+)
+
+// MigrationOptions 迁移分析的选项
+type MigrationOptions struct {
+	// 基准运行
+	Baseline string `form:"baseline" binding:"required"`
+}
+
+// loadMigration 读取运行和基准运行的分组结果并统计迁移，失败时已写入错误返回
+func loadMigration(c *gin.Context) (*models.Migration, bool) {
+	options := MigrationOptions{}
+	if err := c.ShouldBindQuery(&options); err != nil {
+		abortWithError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err, ErrorDetail{In: "query", Reason: err.Error()})
+		return nil, false
+	}
+
+	baseline, err := loadRunSnapshot(options.Baseline)
+	if err != nil {
+		abortWithArtifactError(c, err)
+		return nil, false
+	}
+	current, err := loadRunSnapshot(c.Param("id"))
+	if err != nil {
+		abortWithArtifactError(c, err)
+		return nil, false
+	}
+
+	migration, err := models.NewMigration(baseline, current)
+	if err != nil {
+		abortWithError(c, http.StatusUnprocessableEntity, ErrCodeUnprocessable, err)
+		return nil, false
+	}
+	return migration, true
+}
+
+// migrationKeyPrefix 迁移报告的文件名中取两次运行的分析键的前几位
+const migrationKeyPrefix = 8
+
+// GetMigration 返回基准运行到运行之间用户在分组间迁移的人数和金额矩阵
+func GetMigration(c *gin.Context) {
+	migration, ok := loadMigration(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, migration)
+}
+
+// ExportMigration 以Excel返回迁移的人数和金额矩阵
+func ExportMigration(c *gin.Context) {
+	migration, ok := loadMigration(c)
+	if !ok {
+		return
+	}
+
+	// 分析键来自运行中保存的模型，不能假定它的长度
+	if len(migration.BaselineKey) < migrationKeyPrefix || len(migration.CurrentKey) < migrationKeyPrefix {
+		abortWithError(c, http.StatusUnprocessableEntity, ErrCodeUnprocessable,
+			fmt.Errorf("the analysis keys %q and %q must have at least %d characters", migration.BaselineKey, migration.CurrentKey, migrationKeyPrefix))
+		return
+	}
+
+	excel, err := models.NewMigrationWorkbook(migration)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, ErrCodeInternal, err)
+		return
+	}
+	defer excel.Close()
+
+	writeWorkbook(c, excel, fmt.Sprintf("migration_%s_%s.xlsx", migration.BaselineKey[:migrationKeyPrefix], migration.CurrentKey[:migrationKeyPrefix]))
+}
+
+// 桑基图中基准运行和当前运行的节点前缀，两侧的同名分组是不同的节点
+const (
+	sankeyBaselinePrefix = "基准 "
+	sankeyCurrentPrefix  = "当前 "
+)
+
+// ProcessMigrationSankeyChart 用桑基图绘制用户在分组间的迁移人数
+func ProcessMigrationSankeyChart(m *models.Migration) template.HTML {
+	nodes := []opts.SankeyNode{}
+	for _, s := range m.From {
+		nodes = append(nodes, opts.SankeyNode{Name: sankeyBaselinePrefix + s.Label()})
+	}
+	for _, s := range m.To {
+		nodes = append(nodes, opts.SankeyNode{Name: sankeyCurrentPrefix + s.Label()})
+	}
+
+	links := []opts.SankeyLink{}
+	for i, from := range m.From {
+		for j, to := range m.To {
+			if m.Counts[i][j] == 0 {
+				continue
+			}
+			links = append(links, opts.SankeyLink{
+				Source: sankeyBaselinePrefix + from.Label(),
+				Target: sankeyCurrentPrefix + to.Label(),
+				Value:  float32(m.Counts[i][j]),
+			})
+		}
+	}
+
+	sankey := charts.NewSankey()
+	sankey.AssetsHost = "/statics/echarts/"
+	sankey.SetGlobalOptions(
+		charts.WithInitializationOpts(opts.Initialization{Height: "600px"}),
+		charts.WithTooltipOpts(opts.Tooltip{Show: opts.Bool(true), Trigger: "item"}),
+	)
+	sankey.AddSeries("", nodes, links,
+		charts.WithLabelOpts(opts.Label{Show: opts.Bool(true)}),
+		charts.WithLineStyleOpts(opts.LineStyle{Color: "source", Curveness: 0.5}),
+	)
+
+	return template.HTML(sankey.RenderContent())
+}
+
+// ProcessMigrationHeatMapChart 用热力图绘制迁移矩阵，行为基准运行的分组，列为当前运行的分组
+func ProcessMigrationHeatMapChart(m *models.Migration, name string, value func(i, j int) float64) template.HTML {
+	xAxis := make([]string, len(m.To))
+	for j, s := range m.To {
+		xAxis[j] = s.Label()
+	}
+	yAxis := make([]string, len(m.From))
+	for i, s := range m.From {
+		yAxis[i] = s.Label()
+	}
+
+	data := []opts.HeatMapData{}
+	var maximum float64
+	for i := range m.From {
+		for j := range m.To {
+			v := value(i, j)
+			maximum = max(maximum, v)
+			data = append(data, opts.HeatMapData{Value: [3]interface{}{j, i, v}})
+		}
+	}
+
+	heatMap := charts.NewHeatMap()
+	heatMap.AssetsHost = "/statics/echarts/"
+	heatMap.SetGlobalOptions(
+		charts.WithTooltipOpts(opts.Tooltip{Show: opts.Bool(true)}),
+		charts.WithXAxisOpts(opts.XAxis{Name: "当前", Type: "category", Data: xAxis, SplitArea: &opts.SplitArea{Show: opts.Bool(true)}}),
+		charts.WithYAxisOpts(opts.YAxis{Name: "基准", Type: "category", Data: yAxis, SplitArea: &opts.SplitArea{Show: opts.Bool(true)}}),
+		charts.WithVisualMapOpts(opts.VisualMap{
+			Calculable: opts.Bool(true),
+			Min:        0,
+			Max:        float32(maximum),
+			InRange:    &opts.VisualMapInRange{Color: []string{"#f5f5f5", colors[3]}},
+		}),
+	)
+	heatMap.SetXAxis(xAxis).AddSeries(name, data, charts.WithLabelOpts(opts.Label{Show: opts.Bool(true)}))
+
+	return template.HTML(heatMap.RenderContent())
+}
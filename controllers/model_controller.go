@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"rfm_cluster/models"
+	"rfm_cluster/pkg/artifact"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 分群模型的产物名称
+const segmentationModel = "segmentation_model.json"
+
+// WriteSegmentationModel 把分为k组时的分群模型写入运行runID的产物segmentation_model.json
+func WriteSegmentationModel(store artifact.Store, runID string, analysis *models.Analysis, k int) error {
+	model, err := models.NewSegmentationModel(analysis, k)
+	if err != nil {
+		return err
+	}
+
+	w, err := store.Create(runID, segmentationModel)
+	if err != nil {
+		return err
+	}
+	if err := model.Save(w); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+// ExportModel 直接返回分为k组时的分群模型，保存后可离线调用Predict给新用户分组
+func ExportModel(c *gin.Context) {
+	params, ok := bindAnalysisParams(c)
+	if !ok {
+		return
+	}
+
+	analysis, _, ok := loadAnalysis(c, params)
+	if !ok {
+		return
+	}
+	k := analysis.SelectedK(params)
+
+	model, err := models.NewSegmentationModel(analysis, k)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, ErrCodeInternal, err)
+		return
+	}
+
+	filename := fmt.Sprintf("segmentation_model_%s_k%d.json", analysis.Key[:8], k)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Header("Content-Type", artifact.ContentType(filename))
+	c.Status(http.StatusOK)
+	if err := model.Save(c.Writer); err != nil {
+		c.Error(err)
+	}
+}
+
+// PredictRequest 预测接口的请求体，用户只需要填写编号和R、F、M原始值
+type PredictRequest struct {
+	Users []*models.UserRFM `json:"users" binding:"required"`
+}
+
+// PredictResponse 预测接口的返回结构
+type PredictResponse struct {
+	RunID        string              `json:"run_id"`
+	ModelVersion int                 `json:"model_version"`
+	AnalysisKey  string              `json:"analysis_key"`
+	Predictions  []models.Prediction `json:"predictions"`
+}
+
+// Predict 用运行保存的分群模型给新用户分组，不重新聚类
+func Predict(c *gin.Context) {
+	runID := c.Param("id")
+
+	request := PredictRequest{}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		abortWithError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err, ErrorDetail{In: "body", Reason: err.Error()})
+		return
+	}
+
+	reader, _, err := artifactStore.Open(runID, segmentationModel)
+	if err != nil {
+		if errors.Is(err, artifact.ErrNotFound) {
+			err = fmt.Errorf("run %q has no segmentation model: %w", runID, err)
+		}
+		abortWithArtifactError(c, err)
+		return
+	}
+	defer reader.Close()
+
+	model, err := models.LoadSegmentationModel(reader)
+	if err != nil {
+		abortWithError(c, http.StatusUnprocessableEntity, ErrCodeUnprocessable, err)
+		return
+	}
+
+	predictions, err := model.PredictBatch(request.Users)
+	if err != nil {
+		abortWithError(c, http.StatusUnprocessableEntity, ErrCodeUnprocessable, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, PredictResponse{
+		RunID:        runID,
+		ModelVersion: model.Version,
+		AnalysisKey:  model.AnalysisKey,
+		Predictions:  predictions,
+	})
+}
@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAPI 加载后的接口文档，负责对外提供文档并按文档校验请求
+type OpenAPI struct {
+	raw    []byte
+	doc    *openapi3.T
+	router routers.Router
+}
+
+// LoadOpenAPI 读取并校验接口文档
+func LoadOpenAPI(path string) (*OpenAPI, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if err := doc.Validate(loader.Context); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document %s: %w", path, err)
+	}
+
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OpenAPI{raw: raw, doc: doc, router: router}, nil
+}
+
+// ServeJSON 以JSON格式返回接口文档
+func (o *OpenAPI) ServeJSON(c *gin.Context) {
+	c.JSON(http.StatusOK, o.doc)
+}
+
+// ServeYAML 以YAML原文返回接口文档
+func (o *OpenAPI) ServeYAML(c *gin.Context) {
+	c.Data(http.StatusOK, "application/yaml; charset=utf-8", o.raw)
+}
+
+// Validator 按接口文档校验请求参数和请求体，文档中没有的路由直接放行
+func (o *OpenAPI) Validator() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route, pathParams, err := o.router.FindRoute(c.Request)
+		if err != nil {
+			c.Next()
+			return
+		}
+
+		input := &openapi3filter.RequestValidationInput{
+			Request:    c.Request,
+			PathParams: pathParams,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				MultiError:         true,
+				AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+			},
+		}
+
+		err = openapi3filter.ValidateRequest(c.Request.Context(), input)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, ErrCodeInvalidRequest,
+				errors.New("request does not match the API specification"),
+				validationDetails(err)...)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// validationDetails 把kin-openapi的校验错误展开为ErrorDetail
+func validationDetails(err error) []ErrorDetail {
+	switch e := err.(type) {
+	case openapi3.MultiError:
+		details := []ErrorDetail{}
+		for _, inner := range e {
+			details = append(details, validationDetails(inner)...)
+		}
+		return details
+	case *openapi3filter.RequestError:
+		in, name := "body", ""
+		if p := e.Parameter; p != nil {
+			in, name = p.In, p.Name
+		}
+
+		causes := []error{e.Err}
+		if multi, ok := e.Err.(openapi3.MultiError); ok {
+			causes = multi
+		}
+
+		details := []ErrorDetail{}
+		for _, cause := range causes {
+			detail := ErrorDetail{In: in, Name: name, Reason: e.Reason}
+			var schemaErr *openapi3.SchemaError
+			if errors.As(cause, &schemaErr) {
+				detail.Reason = schemaErr.Reason
+				if in == "body" {
+					detail.Name = strings.Join(schemaErr.JSONPointer(), ".")
+				}
+			} else if cause != nil && detail.Reason == "" {
+				detail.Reason = cause.Error()
+			}
+			details = append(details, detail)
+		}
+		return details
+	default:
+		return []ErrorDetail{{In: "query", Reason: err.Error()}}
+	}
+}
@@ -3,21 +3,26 @@ package controllers
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
-	"log"
+	"math"
 	"net/http"
+	"net/url"
+	"os"
 	"rfm_cluster/models"
+	"rfm_cluster/pkg/artifact"
 	"rfm_cluster/pkg/clusters"
+	"rfm_cluster/pkg/gmm"
+	"rfm_cluster/pkg/hierarchical"
+	"rfm_cluster/pkg/kmeans"
 	"rfm_cluster/pkg/silhouette"
+	"strconv"
 	"sync"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-echarts/go-echarts/v2/charts"
 	"github.com/go-echarts/go-echarts/v2/opts"
-	"github.com/spf13/cast"
-	"github.com/xuri/excelize/v2"
 )
 
 var colors = []string{
@@ -27,61 +32,217 @@ var colors = []string{
 	"#1e9fff",
 	"#a233c6",
 	"#2f363c",
-	"#c2c2c2",
+	"#5fb878",
 }
 
-func Index(c *gin.Context) {
-	queryParams := c.Request.URL.Query()
-	// k := queryParams.Get("k")
-	purchaseEnd := queryParams.Get("purchase_end")
+// 噪声在图表中统一使用灰色
+const noiseColor = "#c2c2c2"
+
+// TemplateFuncs 看板模板中使用的函数
+var TemplateFuncs = template.FuncMap{
+	"percent": func(v float64) string {
+		return fmt.Sprintf("%.2f%%", v*100)
+	},
+	"deref": func(v *float64) float64 {
+		return *v
+	},
+}
 
-	excel, err := excelize.OpenFile("original_data.xlsx")
-	if err != nil {
-		c.JSON(http.StatusOK, err.Error())
-		return
+// 原始数据文件
+const originalDataPath = "original_data.xlsx"
+
+// analysisCache 分析结果缓存，为nil时每次请求都重新分析
+var analysisCache *models.AnalysisCache
+
+// SetAnalysisCache 配置分析结果缓存
+func SetAnalysisCache(cache *models.AnalysisCache) {
+	analysisCache = cache
+}
+
+// artifactStore 保存每次运行生成的文件
+var artifactStore artifact.Store = artifact.NewMemory()
+
+// SetArtifactStore 配置运行产物的存储
+func SetArtifactStore(store artifact.Store) {
+	artifactStore = store
+}
+
+// bindAnalysisParams 读取并检查分析参数，失败时已写入错误返回
+func bindAnalysisParams(c *gin.Context) (models.AnalysisParams, bool) {
+	params := models.AnalysisParams{}
+	if err := c.ShouldBindQuery(&params); err != nil {
+		abortWithError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err, ErrorDetail{In: "query", Reason: err.Error()})
+		return params, false
+	}
+	if err := params.Normalize(); err != nil {
+		abortWithParamError(c, err)
+		return params, false
 	}
+	return params, true
+}
 
-	rows, err := excel.GetRows("Sheet1")
+// loadAnalysis 读取原始数据并返回（可能来自缓存的）分析结果，失败时已写入错误返回
+func loadAnalysis(c *gin.Context, params models.AnalysisParams) (*models.Analysis, models.CacheStatus, bool) {
+	content, err := os.ReadFile(originalDataPath)
 	if err != nil {
-		c.JSON(http.StatusOK, err.Error())
-		return
+		abortWithError(c, http.StatusInternalServerError, ErrCodeInternal, err)
+		return nil, "", false
 	}
 
-	originalData := []*models.UserRFM{}
-	for index, row := range rows {
-		if index == 0 {
-			continue
+	analysis, cacheStatus, err := analysisCache.GetOrRun(content, params)
+	if err != nil {
+		// 参数与数据不相容，例如分组大小的限制无法满足
+		var details []ErrorDetail
+		var paramErr *models.ParamError
+		if errors.As(err, &paramErr) {
+			details = append(details, ErrorDetail{In: "query", Name: paramErr.Name, Reason: paramErr.Reason})
 		}
+		abortWithError(c, http.StatusUnprocessableEntity, ErrCodeUnprocessable, err, details...)
+		return nil, "", false
+	}
+	c.Header("X-Cache", string(cacheStatus))
+	c.Header("X-Analysis-Key", analysis.Key)
 
-		rTime, err := time.Parse(time.DateOnly, row[6])
-		if err != nil {
-			log.Fatal(err)
-		}
-		recency := cast.ToFloat64((cast.ToInt64(purchaseEnd) - rTime.UnixMilli()) / 86400000)
-
-		temp := models.UserRFM{
-			UserID:            cast.ToUint64(row[0]),
-			Nickname:          row[1],
-			Birthday:          row[2],
-			Gender:            cast.ToInt8(row[3]),
-			RecencyOriginal:   recency,
-			FrequencyOriginal: cast.ToFloat64(row[4]),
-			MonetaryOriginal:  cast.ToFloat64(row[5]),
-		}
+	return analysis, cacheStatus, true
+}
 
-		originalData = append(originalData, &temp)
+// analysisQuery 返回能复现当前分析结果的查询参数，供看板上的导出链接使用
+func analysisQuery(params models.AnalysisParams, k int) template.URL {
+	values := url.Values{}
+	values.Set("purchase_end", strconv.FormatInt(params.PurchaseEnd, 10))
+	values.Set("k_max", strconv.Itoa(params.KMax))
+	if !models.IsDensityAlgorithm(params.Algorithm) {
+		values.Set("k", strconv.Itoa(k))
+	}
+	values.Set("scoring", params.Scoring)
+	values.Set("seed", strconv.FormatInt(params.Seed, 10))
+	values.Set("algorithm", params.Algorithm)
+	values.Set("linkage", params.Linkage)
+	values.Set("covariance", params.Covariance)
+	if params.Criterion != "" {
+		values.Set("criterion", params.Criterion)
 	}
+	values.Set("fuzzifier", strconv.FormatFloat(params.Fuzzifier, 'f', -1, 64))
+	values.Set("metric", params.Metric)
+	values.Set("batch_size", strconv.Itoa(params.BatchSize))
+	values.Set("min_size", strconv.Itoa(params.MinSize))
+	values.Set("max_size", strconv.Itoa(params.MaxSize))
+	values.Set("init", params.Init)
+	if params.Centers != "" {
+		values.Set("centers", params.Centers)
+	}
+	values.Set("eps", strconv.FormatFloat(params.Eps, 'f', -1, 64))
+	values.Set("min_pts", strconv.Itoa(params.MinPts))
+	values.Set("min_cluster_size", strconv.Itoa(params.MinClusterSize))
+	return template.URL(values.Encode())
+}
 
-	_, scores, estimate, _, err := models.ProcessData(originalData)
-	if err != nil {
-		c.JSON(http.StatusOK, err.Error())
+// DashboardOptions 只影响看板展示的选项
+type DashboardOptions struct {
+	// 软聚类中最高归属概率不超过该值的用户视为归属不确定
+	MaxProbability float64 `form:"max_probability,default=0.8"`
+	// 与之比较分群结构的基准运行，为空时不比较
+	BaselineRun string `form:"baseline_run"`
+	models.DriftThresholds
+}
+
+// 看板上最多列出的归属不确定用户数
+const uncertainMembersLimit = 50
+
+func Index(c *gin.Context) {
+	params, ok := bindAnalysisParams(c)
+	if !ok {
 		return
 	}
 
+	dashboard := DashboardOptions{}
+	if err := c.ShouldBindQuery(&dashboard); err != nil {
+		abortWithError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err, ErrorDetail{In: "query", Reason: err.Error()})
+		return
+	}
+
+	analysis, cacheStatus, ok := loadAnalysis(c, params)
+	if !ok {
+		return
+	}
+
+	originalData := analysis.Data
+	scores := analysis.Scores
+	estimate := analysis.Estimate
+	k := analysis.SelectedK(params)
+
+	// 每次请求的产物保存在独立的运行目录中，并发请求互不覆盖
+	runID := artifact.NewRunID()
+
 	waitGroup := sync.WaitGroup{}
 	renderMap := map[string]interface{}{}
 	renderMap["EstimateCluters"] = estimate
+	renderMap["SelectedClusters"] = k
+	renderMap["KMax"] = params.KMax
+	renderMap["AnalysisKey"] = analysis.Key
+	renderMap["CacheStatus"] = string(cacheStatus)
+	renderMap["RunID"] = runID
+	renderMap["Params"] = params
+	renderMap["AnalysisQuery"] = analysisQuery(params, k)
+	renderMap["ExportColumns"] = models.ExportColumnNames()
+	renderMap["ScoringSchemes"] = models.ScoringSchemeNames()
+	renderMap["Algorithms"] = models.AlgorithmNames()
+	renderMap["Linkages"] = hierarchical.Linkages()
+	renderMap["Covariances"] = gmm.Covariances()
+	renderMap["Metrics"] = clusters.Metrics()
+	renderMap["Inits"] = models.InitNames()
+	renderMap["MaxProbability"] = dashboard.MaxProbability
+	if analysis.SoftPartition(k) != nil {
+		uncertain := analysis.UncertainMembers(k, dashboard.MaxProbability)
+		renderMap["UncertainCount"] = len(uncertain)
+		renderMap["UncertainMembers"] = uncertain[:min(len(uncertain), uncertainMembersLimit)]
+	}
+	renderMap["BaselineRun"] = dashboard.BaselineRun
+	renderMap["DriftThresholds"] = dashboard.DriftThresholds
+	if dashboard.BaselineRun != "" {
+		baseline, err := loadRunSnapshot(dashboard.BaselineRun)
+		if err != nil {
+			abortWithArtifactError(c, err)
+			return
+		}
+		current, err := models.NewRunSnapshot(analysis, k)
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, ErrCodeInternal, err)
+			return
+		}
+		renderMap["Drift"] = models.CompareRuns(baseline, current, dashboard.DriftThresholds)
+
+		migration, err := models.NewMigration(baseline, current)
+		if err != nil {
+			abortWithError(c, http.StatusUnprocessableEntity, ErrCodeUnprocessable, err)
+			return
+		}
+		renderMap["MigrationSankeyChartContent"] = ProcessMigrationSankeyChart(migration)
+		renderMap["MigrationCountChartContent"] = ProcessMigrationHeatMapChart(migration, "人数", func(i, j int) float64 {
+			return float64(migration.Counts[i][j])
+		})
+		renderMap["MigrationRevenueChartContent"] = ProcessMigrationHeatMapChart(migration, "金额", func(i, j int) float64 {
+			return math.Round(migration.Revenue[i][j]*100) / 100
+		})
+	}
+	renderMap["DensityAlgorithm"] = models.IsDensityAlgorithm(params.Algorithm)
+	renderMap["Eps"] = analysis.Eps
+	renderMap["NoiseCount"] = len(analysis.Noise)
+	renderMap["ClusterSummaries"] = models.SummarizeClusters(analysis.Clusters(k))
+	clusterNumbers := []int{}
+	for i := 1; i <= k; i++ {
+		clusterNumbers = append(clusterNumbers, i)
+	}
+	renderMap["ClusterNumbers"] = clusterNumbers
 	lock := sync.Mutex{}
+	var renderErr error
+	setError := func(err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if renderErr == nil {
+			renderErr = err
+		}
+	}
 
 	waitGroup.Add(1)
 
@@ -91,14 +252,14 @@ func Index(c *gin.Context) {
 
 		processedDataBytes, err := json.Marshal(&processedData)
 		if err != nil {
-			c.JSON(http.StatusOK, err.Error())
+			setError(err)
 			return
 		}
 
 		processedDataMap := map[string]interface{}{}
 		err = json.Unmarshal(processedDataBytes, &processedDataMap)
 		if err != nil {
-			c.JSON(http.StatusOK, err.Error())
+			setError(err)
 			return
 		}
 
@@ -121,7 +282,7 @@ func Index(c *gin.Context) {
 		defer waitGroup.Done()
 		line, err := ProcessSilhouetteLineChart(scores)
 		if err != nil {
-			c.JSON(http.StatusOK, err.Error())
+			setError(err)
 			return
 		}
 
@@ -130,11 +291,59 @@ func Index(c *gin.Context) {
 		lock.Unlock()
 	}()
 
+	renderMap["Convergence"] = analysis.Convergence
+	if len(analysis.InertiaComparisons) > 0 {
+		renderMap["InertiaSample"] = analysis.InertiaComparisons[0].Sample
+		renderMap["InertiaChartContent"] = ProcessInertiaComparisonChart(analysis.InertiaComparisons)
+	}
+
+	if len(analysis.SoftPartitions) > 0 {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			line := ProcessCriterionLineChart(params.Algorithm, analysis.SoftPartitions)
+
+			lock.Lock()
+			renderMap["CriterionChartContent"] = line
+			lock.Unlock()
+		}()
+	}
+
+	renderMap["SegmentSplits"] = analysis.SplitSequence()
+	if params.Algorithm == models.AlgorithmHierarchical || params.Algorithm == models.AlgorithmBisecting {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			root, err := analysis.SegmentTree(k, params.KMax)
+			if err != nil {
+				setError(err)
+				return
+			}
+			tree := ProcessDendrogramChart(root)
+
+			lock.Lock()
+			renderMap["DendrogramChartContent"] = tree
+			lock.Unlock()
+		}()
+	}
+
+	if params.Algorithm == models.AlgorithmDBSCAN {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			line := ProcessKDistanceChart(analysis.KDistances, analysis.Eps)
+
+			lock.Lock()
+			renderMap["KDistanceChartContent"] = line
+			lock.Unlock()
+		}()
+	}
+
 	waitGroup.Add(1)
 
 	go func() {
 		defer waitGroup.Done()
-		processedRFMscatter3d, originalRFMscatter3d := ProcessCluteredAndOriginalDataChart(originalData, scores[estimate-2].Clusters)
+		processedRFMscatter3d, originalRFMscatter3d := ProcessCluteredAndOriginalDataChart(analysis.Clusters(k), analysis.Noise)
 
 		lock.Lock()
 		renderMap["ClusteredDataChartContent"] = processedRFMscatter3d
@@ -145,19 +354,46 @@ func Index(c *gin.Context) {
 	waitGroup.Add(1)
 	go func() {
 		defer waitGroup.Done()
-		err := WriteClusteredDataToExcel(scores[estimate-2].Clusters)
+		err := WriteReportToExcel(artifactStore, runID, analysis, k)
+		if err != nil {
+			setError(err)
+			return
+		}
+
+		lock.Lock()
+		renderMap["ReportExcel"] = artifactURL(runID, reportExcel)
+		lock.Unlock()
+	}()
+
+	waitGroup.Add(1)
+	go func() {
+		defer waitGroup.Done()
+		err := WriteSegmentationModel(artifactStore, runID, analysis, k)
 		if err != nil {
-			c.JSON(http.StatusOK, err.Error())
+			setError(err)
 			return
 		}
 
 		lock.Lock()
-		renderMap["ClusteredDataExcel"] = "clustered_data.xlsx"
+		renderMap["SegmentationModel"] = artifactURL(runID, segmentationModel)
 		lock.Unlock()
 	}()
 
+	waitGroup.Add(1)
+	go func() {
+		defer waitGroup.Done()
+		if err := WriteAssignments(artifactStore, runID, analysis, k); err != nil {
+			setError(err)
+		}
+	}()
+
 	waitGroup.Wait()
 
+	if renderErr != nil {
+		abortWithError(c, http.StatusInternalServerError, ErrCodeInternal, renderErr)
+		return
+	}
+
 	c.HTML(200, "dash.html", renderMap)
 }
 
@@ -191,32 +427,43 @@ func ProcessOriginalDataChart(dataCollection []*models.UserRFM) template.HTML {
 	return template.HTML(string(buffer))
 }
 
-func ProcessCluteredAndOriginalDataChart(dataCollection []*models.UserRFM, clusters clusters.Clusters) (template.HTML, template.HTML) {
+// 按分组着色绘制打分后和原始数据的3D图表，噪声作为单独的灰色系列
+func ProcessCluteredAndOriginalDataChart(cc clusters.Clusters, noise clusters.Observations) (template.HTML, template.HTML) {
 	processedRFM := []opts.Chart3DData{}
 	originalRFM := []opts.Chart3DData{}
-	for i, c := range clusters {
+	for i, c := range cc {
 		for _, o := range c.Observations {
 			processedRFM = append(processedRFM, opts.Chart3DData{
 				Value: []interface{}{o.Coordinates()[0], o.Coordinates()[1], o.Coordinates()[2]},
 				ItemStyle: &opts.ItemStyle{
-					Color: colors[i],
+					Color: colors[i%len(colors)],
 				},
 			})
 
 			rfm := o.(*models.UserRFM)
-			for _, user := range dataCollection {
-				if rfm.UserID == user.UserID {
-					originalRFM = append(originalRFM, opts.Chart3DData{
-						Value: []interface{}{user.RecencyOriginal, user.FrequencyOriginal, user.MonetaryOriginal},
-						ItemStyle: &opts.ItemStyle{
-							Color: colors[i],
-						},
-					})
-				}
-			}
+			originalRFM = append(originalRFM, opts.Chart3DData{
+				Value: []interface{}{rfm.RecencyOriginal, rfm.FrequencyOriginal, rfm.MonetaryOriginal},
+				ItemStyle: &opts.ItemStyle{
+					Color: colors[i%len(colors)],
+				},
+			})
 		}
 	}
 
+	processedNoise := []opts.Chart3DData{}
+	originalNoise := []opts.Chart3DData{}
+	for _, o := range noise {
+		processedNoise = append(processedNoise, opts.Chart3DData{
+			Value: []interface{}{o.Coordinates()[0], o.Coordinates()[1], o.Coordinates()[2]},
+		})
+
+		rfm := o.(*models.UserRFM)
+		originalNoise = append(originalNoise, opts.Chart3DData{
+			Value: []interface{}{rfm.RecencyOriginal, rfm.FrequencyOriginal, rfm.MonetaryOriginal},
+		})
+	}
+	noiseStyle := charts.WithItemStyleOpts(opts.ItemStyle{Color: noiseColor, Opacity: 0.6})
+
 	processedRFMscatter3d := charts.NewScatter3D()
 	processedRFMscatter3d.AssetsHost = "/statics/echarts/"
 	// set some global options like Title/Legend/ToolTip or anything else
@@ -227,6 +474,9 @@ func ProcessCluteredAndOriginalDataChart(dataCollection []*models.UserRFM, clust
 	)
 
 	processedRFMscatter3d.AddSeries("", processedRFM)
+	if len(noise) > 0 {
+		processedRFMscatter3d.AddSeries(models.NoiseName, processedNoise, noiseStyle)
+	}
 
 	processedRFMscatter3dBuffer := bytes.NewBuffer([]byte{})
 
@@ -243,6 +493,9 @@ func ProcessCluteredAndOriginalDataChart(dataCollection []*models.UserRFM, clust
 	)
 
 	originalRFMscatter3d.AddSeries("", originalRFM)
+	if len(noise) > 0 {
+		originalRFMscatter3d.AddSeries(models.NoiseName, originalNoise, noiseStyle)
+	}
 
 	originalRFMscatter3dBuffer := bytes.NewBuffer([]byte{})
 
@@ -276,50 +529,167 @@ func ProcessSilhouetteLineChart(scores []silhouette.KScore) (template.HTML, erro
 	return template.HTML(line.RenderContent()), nil
 }
 
-func WriteClusteredDataToExcel(clusters clusters.Clusters) error {
-	excel := excelize.NewFile()
+// k距离曲线最多绘制的点数
+const kDistanceChartPoints = 500
+
+// ProcessKDistanceChart 绘制降序的k距离曲线，eps大于0时标出所用的eps。
+// 数据多于kDistanceChartPoints个时等间隔抽取，保留首尾两点
+func ProcessKDistanceChart(kdistances []float64, eps float64) template.HTML {
+	line := charts.NewLine()
+	line.AssetsHost = "/statics/echarts/"
 
-	// 创建表头
-	headers := []string{
-		"user_id", "nickname", "birthday", "gender",
-		"recency_original", "frequency_original", "monetary_original",
-		"recency_weighted", "frequency_weighted", "monetary_weighted",
-		"cluster",
+	n := min(len(kdistances), kDistanceChartPoints)
+	titles := make([]string, n)
+	lineData := make([]opts.LineData, n)
+	for p := range n {
+		i := p
+		if n > 1 {
+			i = p * (len(kdistances) - 1) / (n - 1)
+		}
+		titles[p] = strconv.Itoa(i + 1)
+		lineData[p] = opts.LineData{Value: kdistances[i]}
 	}
 
-	// 写入表头
-	for i, header := range headers {
-		cell := fmt.Sprintf("%c1", 'A'+i)
-		excel.SetCellValue("Sheet1", cell, header)
+	line.SetGlobalOptions(
+		charts.WithXAxisOpts(opts.XAxis{Name: "数据"}),
+		charts.WithYAxisOpts(opts.YAxis{Name: "k距离"}),
+	)
+	line.SetXAxis(titles).AddSeries("", lineData, charts.WithLineChartOpts(opts.LineChart{ShowSymbol: opts.Bool(false)}))
+	if eps > 0 {
+		line.SetSeriesOptions(charts.WithMarkLineNameYAxisItemOpts(opts.MarkLineNameYAxisItem{Name: "eps", YAxis: eps}))
 	}
 
-	// 写入数据
-	row := 2
-	for clusterIndex, cluster := range clusters {
-		for _, o := range cluster.Observations {
-			rfm := o.(*models.UserRFM)
+	return template.HTML(line.RenderContent())
+}
+
+// ProcessCriterionLineChart 绘制软聚类各分组数的准则：高斯混合模型为BIC和AIC，越小越好；
+// 模糊C均值为划分系数（越大越好）和划分熵（越小越好）
+func ProcessCriterionLineChart(algorithm string, partitions []models.SoftPartition) template.HTML {
+	line := charts.NewLine()
+	line.AssetsHost = "/statics/echarts/"
 
-			// 写入用户数据
-			excel.SetCellValue("Sheet1", fmt.Sprintf("A%d", row), rfm.UserID)
-			excel.SetCellValue("Sheet1", fmt.Sprintf("B%d", row), rfm.Nickname)
-			excel.SetCellValue("Sheet1", fmt.Sprintf("C%d", row), rfm.Birthday)
-			excel.SetCellValue("Sheet1", fmt.Sprintf("D%d", row), rfm.Gender)
-			excel.SetCellValue("Sheet1", fmt.Sprintf("E%d", row), rfm.RecencyOriginal)
-			excel.SetCellValue("Sheet1", fmt.Sprintf("F%d", row), rfm.FrequencyOriginal)
-			excel.SetCellValue("Sheet1", fmt.Sprintf("G%d", row), rfm.MonetaryOriginal)
-			excel.SetCellValue("Sheet1", fmt.Sprintf("H%d", row), rfm.RecencyWeighted)
-			excel.SetCellValue("Sheet1", fmt.Sprintf("I%d", row), rfm.FrequencyWeighted)
-			excel.SetCellValue("Sheet1", fmt.Sprintf("J%d", row), rfm.MonetaryWeighted)
-			excel.SetCellValue("Sheet1", fmt.Sprintf("K%d", row), clusterIndex+1)
-
-			row++
+	titles := []string{}
+	first := []opts.LineData{}
+	second := []opts.LineData{}
+	for _, p := range partitions {
+		titles = append(titles, fmt.Sprintf("k = %d", p.K))
+		if algorithm == models.AlgorithmFuzzy {
+			first = append(first, opts.LineData{Value: p.PartitionCoefficient})
+			second = append(second, opts.LineData{Value: p.PartitionEntropy})
+		} else {
+			first = append(first, opts.LineData{Value: p.BIC})
+			second = append(second, opts.LineData{Value: p.AIC})
 		}
 	}
 
+	line.SetGlobalOptions(
+		charts.WithLegendOpts(opts.Legend{Show: opts.Bool(true)}),
+		charts.WithYAxisOpts(opts.YAxis{Scale: opts.Bool(true)}),
+	)
+	if algorithm == models.AlgorithmFuzzy {
+		line.SetXAxis(titles).
+			AddSeries("PC", first, charts.WithMarkPointNameTypeItemOpts(
+				opts.MarkPointNameTypeItem{Name: "Maximum", Type: "max"},
+			)).
+			AddSeries("PE", second, charts.WithMarkPointNameTypeItemOpts(
+				opts.MarkPointNameTypeItem{Name: "Minimum", Type: "min"},
+			))
+		return template.HTML(line.RenderContent())
+	}
+
+	line.SetXAxis(titles).
+		AddSeries("BIC", first).
+		AddSeries("AIC", second).
+		SetSeriesOptions(charts.WithMarkPointNameTypeItemOpts(
+			opts.MarkPointNameTypeItem{Name: "Minimum", Type: "min"},
+		))
+
+	return template.HTML(line.RenderContent())
+}
+
+// ProcessInertiaComparisonChart 对比小批量k-means与完整k-means在同一样本上各分组数的SSE
+func ProcessInertiaComparisonChart(comparisons []kmeans.Comparison) template.HTML {
+	bar := charts.NewBar()
+	bar.AssetsHost = "/statics/echarts/"
+
+	titles := []string{}
+	mini := []opts.BarData{}
+	full := []opts.BarData{}
+	for _, c := range comparisons {
+		titles = append(titles, fmt.Sprintf("k = %d", c.K))
+		mini = append(mini, opts.BarData{Value: c.MiniBatch})
+		full = append(full, opts.BarData{Value: c.Full})
+	}
+
+	bar.SetGlobalOptions(
+		charts.WithLegendOpts(opts.Legend{Show: opts.Bool(true)}),
+		charts.WithTooltipOpts(opts.Tooltip{Show: opts.Bool(true), Trigger: "axis"}),
+		charts.WithYAxisOpts(opts.YAxis{Name: "SSE"}),
+	)
+	bar.SetXAxis(titles).
+		AddSeries("小批量k-means", mini).
+		AddSeries("完整k-means", full)
+
+	return template.HTML(bar.RenderContent())
+}
+
+// ProcessDendrogramChart 绘制层次聚类的分组树或二分k-means的分裂树，当前分组数下的分组按分组颜色标出
+func ProcessDendrogramChart(root *models.SegmentNode) template.HTML {
+	var convert func(node *models.SegmentNode) *opts.TreeData
+	convert = func(node *models.SegmentNode) *opts.TreeData {
+		data := &opts.TreeData{
+			Name:  fmt.Sprintf("%s (%d)", node.Name, node.Size),
+			Value: node.Height,
+		}
+		if node.Cluster > 0 {
+			data.Name = fmt.Sprintf("第%d组 %s", node.Cluster, data.Name)
+			data.ItemStyle = &opts.ItemStyle{Color: colors[(node.Cluster-1)%len(colors)]}
+			data.SymbolSize = 14
+		}
+		for _, child := range node.Children {
+			data.Children = append(data.Children, convert(child))
+		}
+		return data
+	}
+
+	tree := charts.NewTree()
+	tree.AssetsHost = "/statics/echarts/"
+	tree.SetGlobalOptions(
+		charts.WithInitializationOpts(opts.Initialization{Height: "600px"}),
+		charts.WithTooltipOpts(opts.Tooltip{Show: opts.Bool(true)}),
+	)
+	tree.AddSeries("", []opts.TreeData{*convert(root)},
+		charts.WithTreeOpts(opts.TreeChart{
+			Layout:           "orthogonal",
+			Orient:           "TB",
+			InitialTreeDepth: -1,
+			Roam:             opts.Bool(true),
+			Label:            &opts.Label{Show: opts.Bool(true), Position: "top"},
+		}),
+	)
+
+	return template.HTML(tree.RenderContent())
+}
+
+// 分析报告的产物名称
+const reportExcel = "rfm_report.xlsx"
+
+// WriteReportToExcel 把分为k组时的分析报告写入运行runID的产物rfm_report.xlsx
+func WriteReportToExcel(store artifact.Store, runID string, analysis *models.Analysis, k int) error {
+	excel, err := models.NewReportWorkbook(analysis, k)
+	if err != nil {
+		return err
+	}
+
 	// 保存文件
-	if err := excel.SaveAs("clustered_data.xlsx"); err != nil {
+	w, err := store.Create(runID, reportExcel)
+	if err != nil {
+		return err
+	}
+	if err := excel.Write(w); err != nil {
+		w.Close()
 		return err
 	}
 
-	return nil
+	return w.Close()
 }
@@ -1,15 +1,65 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"net/http"
 	"rfm_cluster/controllers"
+	"rfm_cluster/models"
+	"rfm_cluster/pkg/artifact"
+	"rfm_cluster/pkg/parallel"
+	"runtime"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+var (
+	cacheSize = flag.Int("cache-size", 16, "number of analysis results kept in memory")
+	cacheDir  = flag.String("cache-dir", "", "directory to persist analysis results in, empty disables persistence")
+
+	artifactBackend  = flag.String("artifact-store", "local", "backend storing generated files (local or memory)")
+	artifactLocation = flag.String("artifact-dir", "runs", "location of the artifact store, one sub directory per run")
+	artifactMaxRuns  = flag.Int("artifact-max-runs", 100, "number of most recent runs kept in the artifact store, 0 keeps every run")
+	artifactMaxAge   = flag.Duration("artifact-max-age", 7*24*time.Hour, "runs not modified for longer are removed from the artifact store, 0 keeps runs of any age")
+
+	concurrency = flag.Int("concurrency", runtime.GOMAXPROCS(0), "number of goroutines clustering at the same time across all requests")
+	centers     = flag.String("centers", "", "JSON file with the default initial k-means centers for init=custom, e.g. [[5,1,1],[3,4,4]]")
+	workers     = flag.Int("kmeans-workers", runtime.GOMAXPROCS(0), "number of goroutines a single k-means iteration may use, within -concurrency")
+
+	incrementalCheckpoint = flag.String("incremental-checkpoint", "incremental_state.json", "file the incremental segmentation state is checkpointed to and restored from, empty keeps it in memory")
+)
+
 func main() {
+	flag.Parse()
+
+	parallel.Default = parallel.NewBudget(*concurrency)
+	models.SetKmeansWorkers(*workers)
+	if *centers != "" {
+		if err := models.LoadDefaultCenters(*centers); err != nil {
+			panic(err)
+		}
+	}
+
+	analysisCache, err := models.NewAnalysisCache(*cacheSize, *cacheDir)
+	if err != nil {
+		panic(err)
+	}
+	controllers.SetAnalysisCache(analysisCache)
+
+	artifactStore, err := artifact.Open(*artifactBackend, *artifactLocation)
+	if err != nil {
+		panic(err)
+	}
+	controllers.SetArtifactStore(artifact.WithRetention(artifactStore, artifact.Retention{
+		MaxRuns: *artifactMaxRuns,
+		MaxAge:  *artifactMaxAge,
+	}))
+
+	if err := controllers.SetIncrementalCheckpoint(*incrementalCheckpoint); err != nil {
+		panic(err)
+	}
+
 	httpServer := &http.Server{
 		Addr:              fmt.Sprintf(":%d", 80),
 		Handler:           HTTPRouter(),
@@ -20,7 +70,7 @@ func main() {
 		MaxHeaderBytes:    0,
 	}
 
-	err := httpServer.ListenAndServe()
+	err = httpServer.ListenAndServe()
 	if err != nil {
 		panic(err)
 	}
@@ -29,11 +79,45 @@ func main() {
 func HTTPRouter() *gin.Engine {
 	engine := gin.Default()
 
+	engine.SetFuncMap(controllers.TemplateFuncs)
 	engine.LoadHTMLGlob("views/*")
 
+	openAPI, err := controllers.LoadOpenAPI("docs/openapi.yaml")
+	if err != nil {
+		panic(err)
+	}
+	engine.Use(openAPI.Validator())
+
 	engine.StaticFS("/statics", http.Dir("./statics"))
 
+	engine.GET("/openapi.json", openAPI.ServeJSON)
+	engine.GET("/openapi.yaml", openAPI.ServeYAML)
+
 	engine.GET("/", controllers.Index)
 
+	engine.GET("/export/excel", controllers.ExportExcel)
+	engine.GET("/export/report", controllers.ExportReport)
+	engine.GET("/export/assignments", controllers.ExportAssignments)
+	engine.GET("/export/model", controllers.ExportModel)
+
+	engine.GET("/cache", controllers.GetCache)
+	engine.DELETE("/cache", controllers.PurgeCache)
+	engine.DELETE("/cache/:key", controllers.InvalidateCache)
+
+	engine.GET("/runs/:id/artifacts", controllers.ListArtifacts)
+	engine.GET("/runs/:id/artifacts/:name", controllers.DownloadArtifact)
+	engine.DELETE("/runs/:id", controllers.DeleteRun)
+	engine.PUT("/runs/:id/pin", controllers.PinRun)
+	engine.DELETE("/runs/:id/pin", controllers.UnpinRun)
+	engine.POST("/runs/:id/predict", controllers.Predict)
+	engine.GET("/runs/:id/drift", controllers.CompareRunDrift)
+	engine.GET("/runs/:id/migration", controllers.GetMigration)
+	engine.GET("/runs/:id/migration/excel", controllers.ExportMigration)
+
+	engine.POST("/incremental", controllers.StartIncremental)
+	engine.GET("/incremental", controllers.GetIncremental)
+	engine.POST("/incremental/update", controllers.UpdateIncremental)
+	engine.POST("/incremental/refit", controllers.RefitIncremental)
+
 	return engine
 }
@@ -0,0 +1,161 @@
+package models
+
+import (
+	"fmt"
+	"rfm_cluster/pkg/bisecting"
+	"rfm_cluster/pkg/clusters"
+	"rfm_cluster/pkg/constrained"
+	"rfm_cluster/pkg/density"
+	"rfm_cluster/pkg/fuzzy"
+	"rfm_cluster/pkg/gmm"
+	"rfm_cluster/pkg/hierarchical"
+	"rfm_cluster/pkg/kmeans"
+	"rfm_cluster/pkg/kmedoids"
+	"rfm_cluster/pkg/silhouette"
+	"runtime"
+	"sort"
+)
+
+// 聚类算法名称
+const (
+	AlgorithmKmeans       = "kmeans"
+	AlgorithmMiniBatch    = "minibatch"
+	AlgorithmKmedoids     = "kmedoids"
+	AlgorithmHierarchical = "hierarchical"
+	AlgorithmBisecting    = "bisecting"
+	AlgorithmConstrained  = "constrained"
+	AlgorithmGMM          = "gmm"
+	AlgorithmFuzzy        = "fcm"
+	AlgorithmDBSCAN       = "dbscan"
+	AlgorithmHDBSCAN      = "hdbscan"
+)
+
+// kmeansWorkers k-means每轮迭代最多使用的goroutine数，受parallel.Default的总数限制
+var kmeansWorkers = runtime.GOMAXPROCS(0)
+
+// SetKmeansWorkers 设置k-means每轮迭代最多使用的goroutine数，不影响分组结果
+func SetKmeansWorkers(workers int) {
+	kmeansWorkers = max(workers, 1)
+}
+
+// partitioners 按分析参数构建聚类算法
+var partitioners = map[string]func(params AnalysisParams) (silhouette.Partitioner, error){
+	AlgorithmKmeans: func(params AnalysisParams) (silhouette.Partitioner, error) {
+		km, err := kmeans.NewWithOptions(0.01, nil)
+		if err != nil {
+			return nil, err
+		}
+		// Hamerly加速与逐点计算的分组结果相同
+		km, err = withInit(km.WithSeed(params.Seed).WithAlgorithm(kmeans.Hamerly).WithWorkers(kmeansWorkers), params)
+		if err != nil {
+			return nil, err
+		}
+		return newKmeansPartitioner(km), nil
+	},
+	AlgorithmMiniBatch: func(params AnalysisParams) (silhouette.Partitioner, error) {
+		mb, err := kmeans.NewMiniBatch(params.BatchSize)
+		if err != nil {
+			return nil, err
+		}
+		return mb.WithSeed(params.Seed), nil
+	},
+	AlgorithmKmedoids: func(params AnalysisParams) (silhouette.Partitioner, error) {
+		return kmedoids.New().WithSeed(params.Seed), nil
+	},
+	AlgorithmHierarchical: func(params AnalysisParams) (silhouette.Partitioner, error) {
+		h, err := hierarchical.New(hierarchical.Linkage(params.Linkage))
+		if err != nil {
+			return nil, err
+		}
+		return h.WithSeed(params.Seed), nil
+	},
+	AlgorithmBisecting: func(params AnalysisParams) (silhouette.Partitioner, error) {
+		return bisecting.New().WithSeed(params.Seed), nil
+	},
+	AlgorithmConstrained: func(params AnalysisParams) (silhouette.Partitioner, error) {
+		c, err := constrained.New(params.MinSize, params.MaxSize)
+		if err != nil {
+			return nil, err
+		}
+		return c.WithSeed(params.Seed), nil
+	},
+	AlgorithmGMM: func(params AnalysisParams) (silhouette.Partitioner, error) {
+		g, err := gmm.New(gmm.Covariance(params.Covariance))
+		if err != nil {
+			return nil, err
+		}
+		return newMixturePartitioner(g.WithSeed(params.Seed)), nil
+	},
+	AlgorithmFuzzy: func(params AnalysisParams) (silhouette.Partitioner, error) {
+		f, err := fuzzy.New(params.Fuzzifier)
+		if err != nil {
+			return nil, err
+		}
+		return newFuzzyPartitioner(f.WithSeed(params.Seed)), nil
+	},
+}
+
+// weightedAlgorithms 支持带权重数据的算法，分组前先合并打分后坐标相同的用户
+var weightedAlgorithms = []string{AlgorithmKmeans, AlgorithmKmedoids, AlgorithmHierarchical, AlgorithmBisecting}
+
+// Clusterer 由数据自身决定分组数的密度聚类算法，稀疏区域的数据作为噪声单独返回
+type Clusterer interface {
+	Cluster(data clusters.Observations) (clusters.Clusters, clusters.Observations, error)
+}
+
+// clusterers 按分析参数构建密度聚类算法，eps为实际使用的邻域半径
+var clusterers = map[string]func(params AnalysisParams, eps float64) (Clusterer, error){
+	AlgorithmDBSCAN: func(params AnalysisParams, eps float64) (Clusterer, error) {
+		return density.NewDBSCAN(eps, params.MinPts)
+	},
+	AlgorithmHDBSCAN: func(params AnalysisParams, eps float64) (Clusterer, error) {
+		return density.NewHDBSCAN(params.MinPts, params.MinClusterSize)
+	},
+}
+
+// AlgorithmNames 返回所有可用的聚类算法名称
+func AlgorithmNames() []string {
+	names := make([]string, 0, len(partitioners)+len(clusterers))
+	for name := range partitioners {
+		names = append(names, name)
+	}
+	for name := range clusterers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewPartitioner 按参数中的算法名称构建聚类算法
+func NewPartitioner(params AnalysisParams) (silhouette.Partitioner, error) {
+	name := params.Algorithm
+	if name == "" {
+		name = AlgorithmKmeans
+	}
+
+	build, ok := partitioners[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown algorithm %q", name)
+	}
+	return build(params)
+}
+
+// IsDensityAlgorithm 判断算法是否为密度聚类，密度聚类不需要指定分组数
+func IsDensityAlgorithm(name string) bool {
+	_, ok := clusterers[name]
+	return ok
+}
+
+// NewClusterer 按参数中的算法名称构建密度聚类算法
+func NewClusterer(params AnalysisParams, eps float64) (Clusterer, error) {
+	build, ok := clusterers[params.Algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unknown density algorithm %q", params.Algorithm)
+	}
+	return build(params, eps)
+}
+
+// KDistances 返回每个数据到第MinPts-1个最近邻的距离（降序），即DBSCAN的k距离曲线
+func KDistances(data clusters.Observations, params AnalysisParams) []float64 {
+	return density.KDistances(data, max(params.MinPts-1, 1))
+}
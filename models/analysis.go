@@ -0,0 +1,200 @@
+package models
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"rfm_cluster/pkg/clusters"
+	"rfm_cluster/pkg/kmeans"
+	"rfm_cluster/pkg/silhouette"
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/xuri/excelize/v2"
+)
+
+// Analysis 一次完整的RFM聚类分析结果
+type Analysis struct {
+	// 缓存键，由数据内容和分析参数共同决定
+	Key string `json:"key"`
+	// 原始数据内容的sha256
+	DatasetHash string              `json:"dataset_hash"`
+	Params      AnalysisParams      `json:"params"`
+	Data        []*UserRFM          `json:"-"`
+	Scores      []silhouette.KScore `json:"-"`
+	// 密度聚类中不属于任何分组的数据
+	Noise clusters.Observations `json:"-"`
+	// 软聚类每个分组数的结果
+	SoftPartitions []SoftPartition `json:"-"`
+	// k-means每个分组数的迭代次数、停止原因和SSE
+	Convergence []Convergence `json:"-"`
+	// 小批量k-means与完整k-means在同一样本上的SSE对比
+	InertiaComparisons []kmeans.Comparison `json:"-"`
+	Estimate           int                 `json:"estimate"`
+	Score              float64             `json:"score"`
+	// DBSCAN实际使用的eps
+	Eps float64 `json:"eps,omitempty"`
+	// DBSCAN降序的k距离曲线
+	KDistances []float64 `json:"-"`
+	// 层次聚类的分组树
+	Hierarchy *Hierarchy `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// KScore 返回分为k组时的得分和分组，没有计算过k组时ok为false
+func (a *Analysis) KScore(k int) (score silhouette.KScore, ok bool) {
+	for _, s := range a.Scores {
+		if s.K == k {
+			return s, true
+		}
+	}
+	return silhouette.KScore{}, false
+}
+
+// Clusters 返回分为k组时的分组结果
+func (a *Analysis) Clusters(k int) clusters.Clusters {
+	s, _ := a.KScore(k)
+	return s.Clusters
+}
+
+// SelectedK 返回参数指定的分组数，未指定或没有计算过该分组数（如密度聚类）时返回估计的分组数
+func (a *Analysis) SelectedK(params AnalysisParams) int {
+	if _, ok := a.KScore(params.K); ok {
+		return params.K
+	}
+	return a.Estimate
+}
+
+// Observations 返回打分后的全部数据
+func (a *Analysis) Observations() clusters.Observations {
+	observations := make(clusters.Observations, len(a.Data))
+	for i, row := range a.Data {
+		observations[i] = row
+	}
+	return observations
+}
+
+// collapseClusters 把每个分组中坐标相同的用户合并为一个带权重的数据，轮廓系数不变而计算量大幅减少
+func collapseClusters(cc clusters.Clusters) clusters.Clusters {
+	result := make(clusters.Clusters, len(cc))
+	for i, c := range cc {
+		result[i] = clusters.Cluster{Center: c.Center, Observations: clusters.Collapse(c.Observations)}
+	}
+	return result
+}
+
+// DatasetHash 计算原始数据内容的sha256
+func DatasetHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// AnalysisKey 由数据内容哈希和所有影响分析结果的参数生成缓存键，
+// 只影响展示的参数（如k）不参与计算
+func AnalysisKey(datasetHash string, params AnalysisParams) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s|purchase_end=%d|scoring=%s|k_max=%d|seed=%d|algorithm=%s|eps=%g|min_pts=%d|min_cluster_size=%d|linkage=%s|covariance=%s|criterion=%s|fuzzifier=%g|batch_size=%d|metric=%s|init=%s|centers=%s|min_size=%d|max_size=%d",
+		datasetHash, params.PurchaseEnd, params.Scoring, params.KMax, params.Seed, params.Algorithm,
+		params.Eps, params.MinPts, params.MinClusterSize, params.Linkage,
+		params.Covariance, params.Criterion, params.Fuzzifier, params.BatchSize, params.Metric, params.Init, params.Centers, params.MinSize, params.MaxSize)
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+// RunAnalysis 解析Excel内容并执行完整的聚类分析
+func RunAnalysis(content []byte, params AnalysisParams) (*Analysis, error) {
+	dataCollection, err := ReadUserRFMFromExcel(bytes.NewReader(content), params.PurchaseEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	datasetHash := DatasetHash(content)
+	analysis := &Analysis{
+		Key:         AnalysisKey(datasetHash, params),
+		DatasetHash: datasetHash,
+		Params:      params,
+		Data:        dataCollection,
+		CreatedAt:   time.Now(),
+	}
+
+	if IsDensityAlgorithm(params.Algorithm) {
+		// 密度聚类只运行一次，分组数由数据决定
+		result, err := ProcessDensityData(dataCollection, params)
+		if err != nil {
+			return nil, err
+		}
+		cc := result.Clusters
+		analysis.Score = silhouette.Average(collapseClusters(cc))
+		analysis.Scores = []silhouette.KScore{{Clusters: cc, K: len(cc), Score: analysis.Score}}
+		analysis.Estimate = len(cc)
+		analysis.Noise = result.Noise
+		analysis.Eps = result.Eps
+		analysis.KDistances = result.KDistances
+		return analysis, nil
+	}
+
+	result, err := ProcessData(dataCollection, params)
+	if err != nil {
+		return nil, err
+	}
+	analysis.Scores = result.Scores
+	analysis.SoftPartitions = result.SoftPartitions
+	analysis.Convergence = result.Convergence
+	analysis.Estimate = result.Estimate
+	analysis.Score = result.Score
+	analysis.Hierarchy = result.Hierarchy
+
+	if params.Algorithm == AlgorithmMiniBatch {
+		comparisons, err := CompareInertia(analysis.Observations(), params)
+		if err != nil {
+			return nil, err
+		}
+		analysis.InertiaComparisons = comparisons
+	}
+	return analysis, nil
+}
+
+// ReadUserRFMFromExcel 从Excel的Sheet1读取原始数据，并以purchaseEnd为截止时间计算R值
+func ReadUserRFMFromExcel(r io.Reader, purchaseEnd int64) ([]*UserRFM, error) {
+	excel, err := excelize.OpenReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer excel.Close()
+
+	rows, err := excel.GetRows("Sheet1")
+	if err != nil {
+		return nil, err
+	}
+
+	originalData := []*UserRFM{}
+	for index, row := range rows {
+		if index == 0 {
+			continue
+		}
+		if len(row) < 7 {
+			return nil, fmt.Errorf("row %d: expected 7 columns, got %d", index+1, len(row))
+		}
+
+		rTime, err := time.Parse(time.DateOnly, row[6])
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", index+1, err)
+		}
+		recency := cast.ToFloat64((purchaseEnd - rTime.UnixMilli()) / 86400000)
+
+		temp := UserRFM{
+			UserID:            cast.ToUint64(row[0]),
+			Nickname:          row[1],
+			Birthday:          row[2],
+			Gender:            cast.ToInt8(row[3]),
+			RecencyOriginal:   recency,
+			FrequencyOriginal: cast.ToFloat64(row[4]),
+			MonetaryOriginal:  cast.ToFloat64(row[5]),
+		}
+
+		originalData = append(originalData, &temp)
+	}
+
+	return originalData, nil
+}
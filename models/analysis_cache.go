@@ -0,0 +1,320 @@
+package models
+
+import (
+	"encoding/json"
+	"log"
+	"rfm_cluster/pkg/cache"
+	"rfm_cluster/pkg/clusters"
+	"rfm_cluster/pkg/kmeans"
+	"rfm_cluster/pkg/silhouette"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// CacheStatus 一次查询的缓存命中情况
+type CacheStatus string
+
+const (
+	CacheHitMemory CacheStatus = "hit-memory"
+	CacheHitDisk   CacheStatus = "hit-disk"
+	CacheMiss      CacheStatus = "miss"
+)
+
+// CacheStats 缓存命中统计
+type CacheStats struct {
+	MemoryHits    uint64 `json:"memory_hits"`
+	DiskHits      uint64 `json:"disk_hits"`
+	Misses        uint64 `json:"misses"`
+	Capacity      int    `json:"capacity"`
+	MemoryEntries int    `json:"memory_entries"`
+	DiskEnabled   bool   `json:"disk_enabled"`
+	DiskEntries   int    `json:"disk_entries"`
+}
+
+// AnalysisCache 以AnalysisKey为键缓存分析结果，内存中按LRU淘汰，
+// 配置了目录时同时持久化到磁盘，重启后仍可命中
+type AnalysisCache struct {
+	capacity int
+	memory   *cache.LRU[string, *Analysis]
+	disk     *cache.Disk
+	// flight 让同一个键同时未命中的请求只执行一次分析，其余请求等待并共享结果
+	flight singleflight.Group
+
+	lock  sync.Mutex
+	stats CacheStats
+}
+
+// NewAnalysisCache 创建容量为capacity的缓存，dir为空时不持久化到磁盘
+func NewAnalysisCache(capacity int, dir string) (*AnalysisCache, error) {
+	c := &AnalysisCache{
+		capacity: capacity,
+		memory:   cache.NewLRU[string, *Analysis](capacity),
+	}
+
+	if dir != "" {
+		disk, err := cache.NewDisk(dir, ".json")
+		if err != nil {
+			return nil, err
+		}
+		c.disk = disk
+	}
+
+	return c, nil
+}
+
+// GetOrRun 返回缓存的分析结果，未命中时执行分析并写入缓存，
+// 同一个键同时未命中时只执行一次分析。缓存为nil时直接执行分析
+func (c *AnalysisCache) GetOrRun(content []byte, params AnalysisParams) (*Analysis, CacheStatus, error) {
+	if c == nil {
+		analysis, err := RunAnalysis(content, params)
+		return analysis, CacheMiss, err
+	}
+
+	key := AnalysisKey(DatasetHash(content), params)
+
+	if analysis, ok := c.memory.Get(key); ok {
+		c.count(CacheHitMemory)
+		return analysis, CacheHitMemory, nil
+	}
+
+	if c.disk != nil {
+		analysis, err := c.loadFromDisk(key)
+		if err != nil {
+			log.Printf("failed to load cached analysis %s: %v", key, err)
+		} else if analysis != nil {
+			c.memory.Add(key, analysis)
+			c.count(CacheHitDisk)
+			return analysis, CacheHitDisk, nil
+		}
+	}
+
+	v, err, _ := c.flight.Do(key, func() (interface{}, error) {
+		// 等待期间其他请求可能刚好完成了同一个分析
+		if analysis, ok := c.memory.Get(key); ok {
+			return analysis, nil
+		}
+
+		analysis, err := RunAnalysis(content, params)
+		if err != nil {
+			return nil, err
+		}
+
+		c.memory.Add(key, analysis)
+		if c.disk != nil {
+			if err := c.saveToDisk(analysis); err != nil {
+				log.Printf("failed to persist analysis %s: %v", key, err)
+			}
+		}
+		return analysis, nil
+	})
+	if err != nil {
+		return nil, CacheMiss, err
+	}
+	c.count(CacheMiss)
+
+	return v.(*Analysis), CacheMiss, nil
+}
+
+func (c *AnalysisCache) count(status CacheStatus) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	switch status {
+	case CacheHitMemory:
+		c.stats.MemoryHits++
+	case CacheHitDisk:
+		c.stats.DiskHits++
+	case CacheMiss:
+		c.stats.Misses++
+	}
+}
+
+// Stats 返回命中统计和当前缓存条目数
+func (c *AnalysisCache) Stats() CacheStats {
+	c.lock.Lock()
+	stats := c.stats
+	c.lock.Unlock()
+
+	stats.Capacity = c.capacity
+	stats.MemoryEntries = c.memory.Len()
+	if c.disk != nil {
+		stats.DiskEnabled = true
+		if keys, err := c.disk.Keys(); err == nil {
+			stats.DiskEntries = len(keys)
+		}
+	}
+	return stats
+}
+
+// Keys 返回所有缓存键，内存中的按最近使用排在前面
+func (c *AnalysisCache) Keys() ([]string, error) {
+	keys := c.memory.Keys()
+	if c.disk == nil {
+		return keys, nil
+	}
+
+	diskKeys, err := c.disk.Keys()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for _, key := range keys {
+		seen[key] = true
+	}
+	for _, key := range diskKeys {
+		if !seen[key] {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// Invalidate 删除一个缓存条目，返回该条目是否存在
+func (c *AnalysisCache) Invalidate(key string) (bool, error) {
+	found := c.memory.Remove(key)
+	if c.disk != nil {
+		removed, err := c.disk.Remove(key)
+		if err != nil {
+			return found, err
+		}
+		found = found || removed
+	}
+	return found, nil
+}
+
+// Purge 清空所有缓存条目，命中统计保持不变
+func (c *AnalysisCache) Purge() error {
+	c.memory.Purge()
+	if c.disk != nil {
+		return c.disk.Purge()
+	}
+	return nil
+}
+
+// analysisSnapshot 分析结果的可序列化形式，分组中只记录数据下标
+type analysisSnapshot struct {
+	Key                string              `json:"key"`
+	DatasetHash        string              `json:"dataset_hash"`
+	Params             AnalysisParams      `json:"params"`
+	Data               []*UserRFM          `json:"data"`
+	Scores             []kScoreSnapshot    `json:"scores"`
+	Noise              []int               `json:"noise,omitempty"`
+	SoftPartitions     []SoftPartition     `json:"soft_partitions,omitempty"`
+	Convergence        []Convergence       `json:"convergence,omitempty"`
+	InertiaComparisons []kmeans.Comparison `json:"inertia_comparisons,omitempty"`
+	Estimate           int                 `json:"estimate"`
+	Score              float64             `json:"score"`
+	Eps                float64             `json:"eps,omitempty"`
+	KDistances         []float64           `json:"k_distances,omitempty"`
+	Hierarchy          *Hierarchy          `json:"hierarchy,omitempty"`
+	CreatedAt          time.Time           `json:"created_at"`
+}
+
+type kScoreSnapshot struct {
+	K        int                    `json:"k"`
+	Score    float64                `json:"score"`
+	Centers  []clusters.Coordinates `json:"centers"`
+	Clusters [][]int                `json:"clusters"`
+}
+
+func (c *AnalysisCache) saveToDisk(analysis *Analysis) error {
+	index := make(map[*UserRFM]int, len(analysis.Data))
+	for i, row := range analysis.Data {
+		index[row] = i
+	}
+
+	snapshot := analysisSnapshot{
+		Key:                analysis.Key,
+		DatasetHash:        analysis.DatasetHash,
+		Params:             analysis.Params,
+		Data:               analysis.Data,
+		Estimate:           analysis.Estimate,
+		Score:              analysis.Score,
+		Eps:                analysis.Eps,
+		KDistances:         analysis.KDistances,
+		Hierarchy:          analysis.Hierarchy,
+		SoftPartitions:     analysis.SoftPartitions,
+		Convergence:        analysis.Convergence,
+		InertiaComparisons: analysis.InertiaComparisons,
+		CreatedAt:          analysis.CreatedAt,
+	}
+	for _, o := range analysis.Noise {
+		snapshot.Noise = append(snapshot.Noise, index[o.(*UserRFM)])
+	}
+	for _, score := range analysis.Scores {
+		s := kScoreSnapshot{K: score.K, Score: score.Score}
+		for _, cluster := range score.Clusters {
+			members := make([]int, 0, len(cluster.Observations))
+			for _, o := range cluster.Observations {
+				members = append(members, index[o.(*UserRFM)])
+			}
+			s.Centers = append(s.Centers, cluster.Center)
+			s.Clusters = append(s.Clusters, members)
+		}
+		snapshot.Scores = append(snapshot.Scores, s)
+	}
+
+	data, err := json.Marshal(&snapshot)
+	if err != nil {
+		return err
+	}
+	return c.disk.Put(analysis.Key, data)
+}
+
+func (c *AnalysisCache) loadFromDisk(key string) (*Analysis, error) {
+	data, ok, err := c.disk.Get(key)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	snapshot := analysisSnapshot{}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+	// 早先保存的层次聚类结果没有分组树，重新构建的树可能与保存的分组不一致，按未命中处理
+	if snapshot.Params.Algorithm == AlgorithmHierarchical && snapshot.Hierarchy == nil {
+		return nil, nil
+	}
+	// 度量不随数据保存，按参数重新设置
+	if err := SetMetric(snapshot.Data, snapshot.Params.Metric); err != nil {
+		return nil, err
+	}
+
+	analysis := &Analysis{
+		Key:                snapshot.Key,
+		DatasetHash:        snapshot.DatasetHash,
+		Params:             snapshot.Params,
+		Data:               snapshot.Data,
+		Estimate:           snapshot.Estimate,
+		Score:              snapshot.Score,
+		Eps:                snapshot.Eps,
+		KDistances:         snapshot.KDistances,
+		Hierarchy:          snapshot.Hierarchy,
+		SoftPartitions:     snapshot.SoftPartitions,
+		Convergence:        snapshot.Convergence,
+		InertiaComparisons: snapshot.InertiaComparisons,
+		CreatedAt:          snapshot.CreatedAt,
+	}
+	for _, m := range snapshot.Noise {
+		analysis.Noise = append(analysis.Noise, snapshot.Data[m])
+	}
+	for _, s := range snapshot.Scores {
+		cc := make(clusters.Clusters, len(s.Clusters))
+		for i, members := range s.Clusters {
+			cc[i].Center = s.Centers[i]
+			for _, m := range members {
+				cc[i].Append(snapshot.Data[m])
+			}
+		}
+		analysis.Scores = append(analysis.Scores, silhouette.KScore{Clusters: cc, K: s.K, Score: s.Score})
+	}
+	// 早先保存的结果没有k距离曲线
+	if analysis.Params.Algorithm == AlgorithmDBSCAN && analysis.KDistances == nil {
+		analysis.KDistances = KDistances(analysis.Observations(), analysis.Params)
+	}
+
+	return analysis, nil
+}
@@ -0,0 +1,248 @@
+package models
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"rfm_cluster/pkg/clusters"
+	"rfm_cluster/pkg/silhouette"
+	"slices"
+	"strconv"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// 分组结果明细的导出格式
+const (
+	FormatCSV     = "csv"
+	FormatNDJSON  = "ndjson"
+	FormatParquet = "parquet"
+)
+
+// Assignment 一个用户的分组结果明细
+type Assignment struct {
+	UserID            uint64  `json:"user_id" parquet:"user_id"`
+	RecencyOriginal   float64 `json:"recency_original" parquet:"recency_original"`
+	FrequencyOriginal float64 `json:"frequency_original" parquet:"frequency_original"`
+	MonetaryOriginal  float64 `json:"monetary_original" parquet:"monetary_original"`
+	RecencyScore      float64 `json:"recency_score" parquet:"recency_score"`
+	FrequencyScore    float64 `json:"frequency_score" parquet:"frequency_score"`
+	MonetaryScore     float64 `json:"monetary_score" parquet:"monetary_score"`
+	// 从1开始的分组编号，噪声为NoiseCluster
+	Cluster     int    `json:"cluster" parquet:"cluster"`
+	ClusterName string `json:"cluster_name" parquet:"cluster_name"`
+	// 按本次分析的度量计算的到分组中心的距离
+	Distance float64 `json:"distance" parquet:"distance"`
+	// 轮廓系数，未计算时为空
+	Silhouette *float64 `json:"silhouette" parquet:"silhouette,optional"`
+	// 高斯混合模型中属于所在分组的概率，其他算法为空
+	Probability *float64 `json:"probability" parquet:"probability,optional"`
+	// 高斯混合模型中属于各分组的概率，其他算法为空
+	Probabilities []float64 `json:"probabilities,omitempty" parquet:"probabilities,list"`
+}
+
+// EachAssignment 逐个生成分为k组时用户的分组结果明细并交给fn处理，不在内存中保存全部明细。
+// 噪声的分组编号为NoiseCluster，距离为到最近分组中心的距离，不计算轮廓系数。
+// withSilhouette为true时计算每个用户的轮廓系数，坐标相同的用户合并后只计算一次
+func EachAssignment(analysis *Analysis, k int, withSilhouette bool, fn func(a *Assignment) error) error {
+	cc, noise := analysis.Clusters(k), analysis.Noise
+	names := ClusterNames(cc)
+	memberships := analysis.Memberships(k)
+
+	// 每个用户所在合并数据的轮廓系数
+	silhouettes := map[clusters.Observation]float64{}
+	if withSilhouette {
+		var groups clusters.Observations
+		var labels []int
+		for ci, c := range collapseClusters(cc) {
+			groups = append(groups, c.Observations...)
+			for range c.Observations {
+				labels = append(labels, ci)
+			}
+		}
+		for i, s := range silhouette.PointsMatrix(clusters.MatrixOf(groups), labels, len(cc)) {
+			for _, o := range groups[i].(*clusters.Group).Members {
+				silhouettes[o] = s
+			}
+		}
+	}
+
+	a := &Assignment{}
+	fill := func(o clusters.Observation, cluster int, name string, center clusters.Coordinates) {
+		rfm := o.(*UserRFM)
+		*a = Assignment{
+			UserID:            rfm.UserID,
+			RecencyOriginal:   rfm.RecencyOriginal,
+			FrequencyOriginal: rfm.FrequencyOriginal,
+			MonetaryOriginal:  rfm.MonetaryOriginal,
+			RecencyScore:      rfm.RecencyWeighted,
+			FrequencyScore:    rfm.FrequencyWeighted,
+			MonetaryScore:     rfm.MonetaryWeighted,
+			Cluster:           cluster,
+			ClusterName:       name,
+			Distance:          o.Distance(center),
+		}
+	}
+
+	for ci, c := range cc {
+		for _, o := range c.Observations {
+			fill(o, ci+1, names[ci], c.Center)
+			if withSilhouette {
+				s := silhouettes[o]
+				a.Silhouette = &s
+			}
+			if p, ok := memberships[o.(*UserRFM)]; ok {
+				a.Probabilities = p
+				a.Probability = &p[ci]
+			}
+
+			if err := fn(a); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, o := range noise {
+		fill(o, NoiseCluster, NoiseName, cc[cc.Nearest(o)].Center)
+		if err := fn(a); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AssignmentWriter 流式写入分组结果明细
+type AssignmentWriter interface {
+	Write(a *Assignment) error
+	// Close 写入缓冲的数据和文件尾，不关闭底层的io.Writer
+	Close() error
+}
+
+// NewAssignmentWriter 返回指定格式的AssignmentWriter。
+// components为高斯混合模型的分组数，CSV为每个分组输出一列概率，其他算法传0
+func NewAssignmentWriter(format string, w io.Writer, components int) (AssignmentWriter, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVAssignmentWriter(w, components)
+	case FormatNDJSON:
+		return &ndjsonAssignmentWriter{encoder: json.NewEncoder(w)}, nil
+	case FormatParquet:
+		return &parquetAssignmentWriter{
+			writer: parquet.NewGenericWriter[Assignment](w, parquet.MaxRowsPerRowGroup(assignmentRowGroupSize)),
+		}, nil
+	}
+	return nil, &ParamError{Name: "format", Reason: fmt.Sprintf("unknown format %q", format)}
+}
+
+// AssignmentExtension 返回导出文件的扩展名
+func AssignmentExtension(format string) string {
+	if format == FormatNDJSON {
+		return ".ndjson"
+	}
+	return "." + format
+}
+
+var assignmentHeader = []string{
+	"user_id", "recency_original", "frequency_original", "monetary_original",
+	"recency_score", "frequency_score", "monetary_score",
+	"cluster", "cluster_name", "distance", "silhouette", "probability",
+}
+
+type csvAssignmentWriter struct {
+	writer *csv.Writer
+	record []string
+	rows   int
+}
+
+func newCSVAssignmentWriter(w io.Writer, components int) (*csvAssignmentWriter, error) {
+	header := slices.Clone(assignmentHeader)
+	for c := 1; c <= components; c++ {
+		header = append(header, fmt.Sprintf("probability_%d", c))
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(header); err != nil {
+		return nil, err
+	}
+	return &csvAssignmentWriter{writer: writer, record: make([]string, len(header))}, nil
+}
+
+func (w *csvAssignmentWriter) Write(a *Assignment) error {
+	formatFloat := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+
+	w.record[0] = strconv.FormatUint(a.UserID, 10)
+	w.record[1] = formatFloat(a.RecencyOriginal)
+	w.record[2] = formatFloat(a.FrequencyOriginal)
+	w.record[3] = formatFloat(a.MonetaryOriginal)
+	w.record[4] = formatFloat(a.RecencyScore)
+	w.record[5] = formatFloat(a.FrequencyScore)
+	w.record[6] = formatFloat(a.MonetaryScore)
+	w.record[7] = strconv.Itoa(a.Cluster)
+	w.record[8] = a.ClusterName
+	w.record[9] = formatFloat(a.Distance)
+	w.record[10] = ""
+	if a.Silhouette != nil {
+		w.record[10] = formatFloat(*a.Silhouette)
+	}
+	w.record[11] = ""
+	if a.Probability != nil {
+		w.record[11] = formatFloat(*a.Probability)
+	}
+	for c := range w.record[len(assignmentHeader):] {
+		w.record[len(assignmentHeader)+c] = ""
+		if c < len(a.Probabilities) {
+			// 极小的概率用科学计数法，避免输出几百位的小数
+			w.record[len(assignmentHeader)+c] = strconv.FormatFloat(a.Probabilities[c], 'g', -1, 64)
+		}
+	}
+
+	if err := w.writer.Write(w.record); err != nil {
+		return err
+	}
+
+	// 定期刷新，避免在内存中积压
+	w.rows++
+	if w.rows%assignmentRowGroupSize == 0 {
+		w.writer.Flush()
+		return w.writer.Error()
+	}
+	return nil
+}
+
+func (w *csvAssignmentWriter) Close() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+type ndjsonAssignmentWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *ndjsonAssignmentWriter) Write(a *Assignment) error {
+	return w.encoder.Encode(a)
+}
+
+func (w *ndjsonAssignmentWriter) Close() error {
+	return nil
+}
+
+// 每写入这么多行就输出一次：CSV刷新缓冲，parquet写出一个行组，
+// 内存中最多保留这么多行
+const assignmentRowGroupSize = 10000
+
+type parquetAssignmentWriter struct {
+	writer *parquet.GenericWriter[Assignment]
+	row    [1]Assignment
+}
+
+func (w *parquetAssignmentWriter) Write(a *Assignment) error {
+	w.row[0] = *a
+	_, err := w.writer.Write(w.row[:])
+	return err
+}
+
+func (w *parquetAssignmentWriter) Close() error {
+	return w.writer.Close()
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"rfm_cluster/pkg/clusters"
+	"rfm_cluster/pkg/kmeans"
+	"strconv"
+	"strings"
+)
+
+// InitCustom 使用指定的初始分组中心，其余取值见kmeans.Initializations
+const InitCustom = "custom"
+
+// InitNames 返回k-means初始化分组中心的全部方式
+func InitNames() []string {
+	var names []string
+	for _, init := range kmeans.Initializations() {
+		names = append(names, string(init))
+	}
+	return append(names, InitCustom)
+}
+
+// defaultCenters init为custom且未指定centers时使用的初始分组中心
+var defaultCenters []clusters.Coordinates
+
+// LoadDefaultCenters 从JSON文件读取默认的初始分组中心，格式为[[r,f,m], ...]，
+// 坐标为打分后的坐标，例如新客户、忠诚客户、流失客户等业务定义的典型用户
+func LoadDefaultCenters(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var centers []clusters.Coordinates
+	if err := json.Unmarshal(content, &centers); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if err := checkCenters(centers); err != nil {
+		return fmt.Errorf("invalid centers in %s: %w", path, err)
+	}
+	defaultCenters = centers
+	return nil
+}
+
+// ParseCenters 解析"r,f,m;r,f,m"格式的初始分组中心
+func ParseCenters(s string) ([]clusters.Coordinates, error) {
+	var centers []clusters.Coordinates
+	for _, part := range strings.Split(s, ";") {
+		var center clusters.Coordinates
+		for _, field := range strings.Split(part, ",") {
+			v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid coordinate %q", field)
+			}
+			center = append(center, v)
+		}
+		centers = append(centers, center)
+	}
+	return centers, checkCenters(centers)
+}
+
+// FormatCenters 把初始分组中心格式化为ParseCenters的格式
+func FormatCenters(centers []clusters.Coordinates) string {
+	parts := make([]string, len(centers))
+	for i, center := range centers {
+		fields := make([]string, len(center))
+		for j, v := range center {
+			fields[j] = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		parts[i] = strings.Join(fields, ",")
+	}
+	return strings.Join(parts, ";")
+}
+
+// checkCenters 检查每个中心都有R、F、M三个坐标
+func checkCenters(centers []clusters.Coordinates) error {
+	if len(centers) == 0 {
+		return fmt.Errorf("at least one center is required")
+	}
+	for i, center := range centers {
+		if len(center) != 3 {
+			return fmt.Errorf("center %d must have 3 coordinates (recency, frequency, monetary)", i+1)
+		}
+	}
+	return nil
+}
+
+// withInit 按参数设置k-means初始化分组中心的方式
+func withInit(km kmeans.Kmeans, params AnalysisParams) (kmeans.Kmeans, error) {
+	if params.Init != InitCustom {
+		return km.WithInitialization(kmeans.Initialization(params.Init)), nil
+	}
+	centers, err := ParseCenters(params.Centers)
+	if err != nil {
+		return km, &ParamError{Name: "centers", Reason: err.Error()}
+	}
+	return km.WithCenters(centers), nil
+}
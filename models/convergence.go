@@ -0,0 +1,59 @@
+package models
+
+import (
+	"rfm_cluster/pkg/clusters"
+	"rfm_cluster/pkg/kmeans"
+	"slices"
+	"sync"
+)
+
+// Convergence k-means分为K组时的迭代次数、停止原因和SSE
+type Convergence struct {
+	K int `json:"k"`
+	kmeans.Report
+}
+
+// kmeansPartitioner 用k-means分组，并记录每个分组数的收敛情况
+type kmeansPartitioner struct {
+	kmeans.Kmeans
+	lock    sync.Mutex
+	reports map[int]kmeans.Report
+}
+
+func newKmeansPartitioner(km kmeans.Kmeans) *kmeansPartitioner {
+	return &kmeansPartitioner{Kmeans: km, reports: map[int]kmeans.Report{}}
+}
+
+func (p *kmeansPartitioner) Partition(data clusters.Observations, k int) (clusters.Clusters, error) {
+	cc, report, err := p.PartitionWithReport(data, k)
+	if err != nil {
+		return nil, err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.reports[k] = report
+	return cc, nil
+}
+
+// Convergence 返回按分组数排序的收敛情况
+func (p *kmeansPartitioner) Convergence() []Convergence {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	result := make([]Convergence, 0, len(p.reports))
+	for k, report := range p.reports {
+		result = append(result, Convergence{K: k, Report: report})
+	}
+	slices.SortFunc(result, func(a, b Convergence) int { return a.K - b.K })
+	return result
+}
+
+// ConvergenceOf 返回分为k组时的收敛情况，不是k-means或没有计算过k组时返回nil
+func (a *Analysis) ConvergenceOf(k int) *Convergence {
+	for i := range a.Convergence {
+		if a.Convergence[i].K == k {
+			return &a.Convergence[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package models
+
+import (
+	"fmt"
+	"rfm_cluster/pkg/clusters"
+	"rfm_cluster/pkg/hierarchical"
+	"slices"
+)
+
+// SegmentNode 层次聚类分组树上的一个节点，子节点是合并成该节点的两个分组
+type SegmentNode struct {
+	// 对应分为k组时从1开始的分组编号，不对应任何分组时为0
+	Cluster  int            `json:"cluster"`
+	Name     string         `json:"name"`
+	Size     int            `json:"size"`
+	Height   float64        `json:"height"`
+	Children []*SegmentNode `json:"children,omitempty"`
+}
+
+// Hierarchy 层次聚类树截断为kmax个叶子后的顶部，在分析时由切分出各分组的同一棵树生成，
+// 节点以下标相互引用，可以直接序列化
+type Hierarchy struct {
+	// Nodes[0]为根节点
+	Nodes []HierarchyNode `json:"nodes"`
+	// Roots[k-1]为分为k组时各分组对应的节点下标，顺序与分组一致
+	Roots [][]int `json:"roots"`
+}
+
+// HierarchyNode 层次聚类树上的一个节点
+type HierarchyNode struct {
+	Size     int                  `json:"size"`
+	Height   float64              `json:"height"`
+	Center   clusters.Coordinates `json:"center"`
+	Children []int                `json:"children,omitempty"`
+}
+
+// NewHierarchy 返回tree截断为kmax个叶子后的顶部，以及分为1到kmax组时各分组对应的节点
+func NewHierarchy(tree *hierarchical.Dendrogram, kmax int) (*Hierarchy, error) {
+	top, err := tree.Top(kmax)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &Hierarchy{}
+	index := map[int]int{}
+	var add func(node *hierarchical.Node) int
+	add = func(node *hierarchical.Node) int {
+		i := len(h.Nodes)
+		index[node.ID] = i
+		center, _ := node.Observations.Center()
+		h.Nodes = append(h.Nodes, HierarchyNode{Size: int(node.Observations.Weight()), Height: node.Height, Center: center})
+		for _, child := range node.Children {
+			c := add(child)
+			h.Nodes[i].Children = append(h.Nodes[i].Children, c)
+		}
+		return i
+	}
+	add(top)
+
+	for k := 1; k <= kmax; k++ {
+		roots, err := tree.Roots(k)
+		if err != nil {
+			return nil, err
+		}
+		ids := make([]int, len(roots))
+		for i, id := range roots {
+			ids[i] = index[id]
+		}
+		h.Roots = append(h.Roots, ids)
+	}
+	return h, nil
+}
+
+// SegmentTree 返回层次聚类截断为leaves个叶子的分组树，展示分组之间的嵌套关系。
+// 与分为k组时的分组对应的节点带有分组编号和分组名称，其余节点按中心与整体平均分的比较命名。
+// 二分k-means返回分裂树，其他算法返回nil
+func (a *Analysis) SegmentTree(k, leaves int) (*SegmentNode, error) {
+	if a.Params.Algorithm == AlgorithmBisecting {
+		return a.splitTree(k, leaves)
+	}
+	if a.Params.Algorithm != AlgorithmHierarchical || a.Hierarchy == nil {
+		return nil, nil
+	}
+
+	h := a.Hierarchy
+	if k < 1 || k > len(h.Roots) {
+		return nil, fmt.Errorf("the segment tree has %d leaves, it can not be cut into %d clusters", len(h.Roots), k)
+	}
+	leaves = min(max(leaves, k), len(h.Roots))
+
+	names := ClusterNames(a.Clusters(k))
+	clusterOf := map[int]int{}
+	for i, node := range h.Roots[k-1] {
+		clusterOf[node] = i
+	}
+	// 截断后的叶子不再展开
+	leaf := map[int]bool{}
+	for _, node := range h.Roots[leaves-1] {
+		leaf[node] = true
+	}
+
+	mean, err := a.Observations().Center()
+	if err != nil {
+		return nil, err
+	}
+
+	var convert func(i int) *SegmentNode
+	convert = func(i int) *SegmentNode {
+		node := h.Nodes[i]
+		result := &SegmentNode{Size: node.Size, Height: node.Height}
+		if ci, ok := clusterOf[i]; ok {
+			result.Cluster = ci + 1
+			result.Name = names[ci]
+		} else {
+			result.Name = segmentName(node.Center, mean)
+		}
+
+		if !leaf[i] {
+			for _, child := range node.Children {
+				result.Children = append(result.Children, convert(child))
+			}
+		}
+		return result
+	}
+	return convert(0), nil
+}
+
+// SegmentSplit 二分k-means的一次分裂：分为K-1组时的Parent分成了分为K组时的Left和Right。
+// Left沿用Parent的分组编号，Right的分组编号为K，节点高度为分组的SSE
+type SegmentSplit struct {
+	K      int         `json:"k"`
+	Score  float64     `json:"score"`
+	Parent SegmentNode `json:"parent"`
+	Left   SegmentNode `json:"left"`
+	Right  SegmentNode `json:"right"`
+}
+
+// SplitSSE 返回分裂后两个分组的SSE之和
+func (s SegmentSplit) SplitSSE() float64 {
+	return s.Left.Height + s.Right.Height
+}
+
+// SplitSequence 返回二分k-means从2组到kmax组依次进行的分裂，其他算法返回nil。
+// 二分k-means分为k+1组时只比k组多分裂一个分组，且其余分组的顺序不变，
+// 因此可以直接从各分组数的结果中还原分裂顺序
+func (a *Analysis) SplitSequence() []SegmentSplit {
+	if a.Params.Algorithm != AlgorithmBisecting || len(a.Scores) == 0 {
+		return nil
+	}
+
+	mean, err := a.Observations().Center()
+	if err != nil {
+		return nil
+	}
+	previous := clusters.Clusters{{Center: mean, Observations: a.Observations()}}
+	previousNames := []string{"全部用户"}
+
+	result := []SegmentSplit{}
+	for _, score := range a.Scores {
+		cc := score.Clusters
+		if len(cc) != len(previous)+1 {
+			break
+		}
+		names := ClusterNames(cc)
+
+		p := len(previous) - 1
+		for i := range previous {
+			if len(previous[i].Observations) != len(cc[i].Observations) {
+				p = i
+				break
+			}
+		}
+		result = append(result, SegmentSplit{
+			K:      score.K,
+			Score:  score.Score,
+			Parent: splitNode(previous[p], p, previousNames[p]),
+			Left:   splitNode(cc[p], p, names[p]),
+			Right:  splitNode(cc[len(cc)-1], len(cc)-1, names[len(cc)-1]),
+		})
+		previous, previousNames = cc, names
+	}
+	return result
+}
+
+func splitNode(c clusters.Cluster, i int, name string) SegmentNode {
+	node := SegmentNode{Cluster: i + 1, Name: name, Size: len(c.Observations)}
+	for _, o := range c.Observations {
+		node.Height += clusters.SquaredEuclidean{}.Distance(o.Coordinates(), c.Center)
+	}
+	return node
+}
+
+// splitTree 返回二分k-means分到leaves组为止的分裂树，叶子节点对应分到leaves组时的分组，
+// 与分为k组时的分组对应的节点带有分组编号和分组名称
+func (a *Analysis) splitTree(k, leaves int) (*SegmentNode, error) {
+	splits := a.SplitSequence()
+	if len(splits) == 0 {
+		return nil, nil
+	}
+
+	root := splits[0].Parent
+	slots := []*SegmentNode{&root}
+	var selected []*SegmentNode
+	for _, split := range splits {
+		if split.K > max(leaves, k) {
+			break
+		}
+		p := split.Left.Cluster - 1
+		left, right := split.Left, split.Right
+		slots[p].Children = []*SegmentNode{&left, &right}
+		slots[p] = &left
+		slots = append(slots, &right)
+		if split.K == k {
+			selected = slices.Clone(slots)
+		}
+	}
+
+	// 只保留分为k组时的分组编号
+	var reset func(node *SegmentNode)
+	reset = func(node *SegmentNode) {
+		node.Cluster = 0
+		for _, child := range node.Children {
+			reset(child)
+		}
+	}
+	reset(&root)
+	names := ClusterNames(a.Clusters(k))
+	for i, node := range selected {
+		node.Cluster = i + 1
+		node.Name = names[i]
+	}
+	return &root, nil
+}
@@ -0,0 +1,305 @@
+package models
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"rfm_cluster/pkg/clusters"
+	"slices"
+)
+
+// RunSnapshot 一次运行的分群结果，用于和其他运行比较
+type RunSnapshot struct {
+	Model       *SegmentationModel
+	Assignments []Assignment
+}
+
+// NewRunSnapshot 返回分为k组时的分群结果
+func NewRunSnapshot(analysis *Analysis, k int) (*RunSnapshot, error) {
+	model, err := NewSegmentationModel(analysis, k)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &RunSnapshot{Model: model}
+	err = EachAssignment(analysis, k, false, func(a *Assignment) error {
+		snapshot.Assignments = append(snapshot.Assignments, *a)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// ReadAssignments 读取NDJSON格式的分组结果明细
+func ReadAssignments(r io.Reader) ([]Assignment, error) {
+	var result []Assignment
+	decoder := json.NewDecoder(r)
+	for {
+		a := Assignment{}
+		err := decoder.Decode(&a)
+		if errors.Is(err, io.EOF) {
+			return result, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, a)
+	}
+}
+
+// DriftThresholds 分群结构变化的告警阈值
+type DriftThresholds struct {
+	// 匹配的分组中心之间的欧氏距离（得分单位）超过该值时告警
+	Displacement float64 `form:"displacement_alert,default=0.5" json:"displacement"`
+	// 分组的用户占比变化超过该值时告警
+	Share float64 `form:"share_alert,default=0.05" json:"share"`
+	// R、F、M原始值分布的群体稳定性指数超过该值时告警，通常0.1以下为稳定，0.25以上为显著变化
+	PSI float64 `form:"psi_alert,default=0.25" json:"psi"`
+	// 两次都出现的用户的调整兰德指数低于该值时告警
+	ARI float64 `form:"ari_alert,default=0.7" json:"ari"`
+}
+
+// DriftReport 两次运行之间分群结构的变化
+type DriftReport struct {
+	BaselineKey string          `json:"baseline_key"`
+	CurrentKey  string          `json:"current_key"`
+	Thresholds  DriftThresholds `json:"thresholds"`
+	// 按中心距离匹配的分组，只在一次运行中出现的分组另一侧编号为0
+	Segments []SegmentDrift `json:"segments"`
+	// R、F、M原始值分布的群体稳定性指数
+	PSI []DimensionPSI `json:"psi"`
+	// 两次都出现的用户数
+	Overlap int `json:"overlap"`
+	// 两次都出现的用户的分组的调整兰德指数，少于2个用户时为空
+	ARI      *float64 `json:"ari"`
+	ARIAlert bool     `json:"ari_alert"`
+	// 任意一项超过阈值
+	Alert bool `json:"alert"`
+}
+
+// SegmentDrift 一对匹配的分组的变化
+type SegmentDrift struct {
+	BaselineCluster int    `json:"baseline_cluster"`
+	BaselineName    string `json:"baseline_name"`
+	CurrentCluster  int    `json:"current_cluster"`
+	CurrentName     string `json:"current_name"`
+	// 两个中心之间的欧氏距离，未匹配的分组为空
+	Displacement  *float64 `json:"displacement"`
+	BaselineShare float64  `json:"baseline_share"`
+	CurrentShare  float64  `json:"current_share"`
+	ShareChange   float64  `json:"share_change"`
+	Alert         bool     `json:"alert"`
+}
+
+// DimensionPSI 一个维度的群体稳定性指数
+type DimensionPSI struct {
+	Dimension string  `json:"dimension"`
+	PSI       float64 `json:"psi"`
+	Alert     bool    `json:"alert"`
+}
+
+// CompareRuns 比较基准运行和当前运行的分群结构。
+// 分组编号在两次运行之间没有对应关系，按中心距离从近到远贪心匹配；
+// 中心距离只在两次运行使用相同的打分规则时有意义
+func CompareRuns(baseline, current *RunSnapshot, thresholds DriftThresholds) DriftReport {
+	report := DriftReport{
+		BaselineKey: baseline.Model.AnalysisKey,
+		CurrentKey:  current.Model.AnalysisKey,
+		Thresholds:  thresholds,
+	}
+
+	report.Segments = matchSegments(baseline, current)
+	for i := range report.Segments {
+		s := &report.Segments[i]
+		s.ShareChange = s.CurrentShare - s.BaselineShare
+		s.Alert = s.Displacement == nil || *s.Displacement > thresholds.Displacement || math.Abs(s.ShareChange) > thresholds.Share
+		report.Alert = report.Alert || s.Alert
+	}
+
+	dimensions := []struct {
+		name  string
+		value func(a Assignment) float64
+	}{
+		{"recency", func(a Assignment) float64 { return a.RecencyOriginal }},
+		{"frequency", func(a Assignment) float64 { return a.FrequencyOriginal }},
+		{"monetary", func(a Assignment) float64 { return a.MonetaryOriginal }},
+	}
+	for _, d := range dimensions {
+		psi := PopulationStability(values(baseline.Assignments, d.value), values(current.Assignments, d.value))
+		report.PSI = append(report.PSI, DimensionPSI{Dimension: d.name, PSI: psi, Alert: psi > thresholds.PSI})
+		report.Alert = report.Alert || psi > thresholds.PSI
+	}
+
+	clustersByUser := map[uint64]int{}
+	for _, a := range baseline.Assignments {
+		clustersByUser[a.UserID] = a.Cluster
+	}
+	var before, after []int
+	for _, a := range current.Assignments {
+		if c, ok := clustersByUser[a.UserID]; ok {
+			before = append(before, c)
+			after = append(after, a.Cluster)
+		}
+	}
+	report.Overlap = len(before)
+	if len(before) >= 2 {
+		ari := AdjustedRandIndex(before, after)
+		report.ARI = &ari
+		report.ARIAlert = ari < thresholds.ARI
+		report.Alert = report.Alert || report.ARIAlert
+	}
+	return report
+}
+
+func values(assignments []Assignment, value func(a Assignment) float64) []float64 {
+	result := make([]float64, len(assignments))
+	for i, a := range assignments {
+		result[i] = value(a)
+	}
+	return result
+}
+
+// matchSegments 重复匹配距离最近的一对未匹配分组，剩下的分组单独列出
+func matchSegments(baseline, current *RunSnapshot) []SegmentDrift {
+	shares := func(s *RunSnapshot) map[int]float64 {
+		result := map[int]float64{}
+		for _, a := range s.Assignments {
+			result[a.Cluster]++
+		}
+		for c := range result {
+			result[c] /= float64(len(s.Assignments))
+		}
+		return result
+	}
+	baselineShares, currentShares := shares(baseline), shares(current)
+
+	type pair struct {
+		b, c     int
+		distance float64
+	}
+	var pairs []pair
+	for bi, b := range baseline.Model.Segments {
+		for ci, c := range current.Model.Segments {
+			pairs = append(pairs, pair{bi, ci, clusters.Euclidean{}.Distance(b.Center, c.Center)})
+		}
+	}
+	slices.SortStableFunc(pairs, func(x, y pair) int {
+		return cmp.Compare(x.distance, y.distance)
+	})
+
+	var result []SegmentDrift
+	matchedBaseline := make([]bool, len(baseline.Model.Segments))
+	matchedCurrent := make([]bool, len(current.Model.Segments))
+	for _, p := range pairs {
+		if matchedBaseline[p.b] || matchedCurrent[p.c] {
+			continue
+		}
+		matchedBaseline[p.b], matchedCurrent[p.c] = true, true
+		b, c := baseline.Model.Segments[p.b], current.Model.Segments[p.c]
+		distance := p.distance
+		result = append(result, SegmentDrift{
+			BaselineCluster: b.Cluster,
+			BaselineName:    b.Name,
+			CurrentCluster:  c.Cluster,
+			CurrentName:     c.Name,
+			Displacement:    &distance,
+			BaselineShare:   baselineShares[b.Cluster],
+			CurrentShare:    currentShares[c.Cluster],
+		})
+	}
+	slices.SortFunc(result, func(x, y SegmentDrift) int {
+		return x.BaselineCluster - y.BaselineCluster
+	})
+	for bi, b := range baseline.Model.Segments {
+		if !matchedBaseline[bi] {
+			result = append(result, SegmentDrift{BaselineCluster: b.Cluster, BaselineName: b.Name, BaselineShare: baselineShares[b.Cluster]})
+		}
+	}
+	for ci, c := range current.Model.Segments {
+		if !matchedCurrent[ci] {
+			result = append(result, SegmentDrift{CurrentCluster: c.Cluster, CurrentName: c.Name, CurrentShare: currentShares[c.Cluster]})
+		}
+	}
+	return result
+}
+
+// PSI的分箱数和空箱的最小占比
+const (
+	psiBins  = 10
+	psiFloor = 1e-4
+)
+
+// PopulationStability 返回current相对baseline的群体稳定性指数。
+// 按baseline的十分位数分箱，重复的分位点合并为一个箱
+func PopulationStability(baseline, current []float64) float64 {
+	if len(baseline) == 0 || len(current) == 0 {
+		return 0
+	}
+
+	sorted := slices.Clone(baseline)
+	slices.Sort(sorted)
+	var cuts []float64
+	for i := 1; i < psiBins; i++ {
+		cut := sorted[i*len(sorted)/psiBins]
+		if len(cuts) == 0 || cut > cuts[len(cuts)-1] {
+			cuts = append(cuts, cut)
+		}
+	}
+
+	proportions := func(vs []float64) []float64 {
+		result := make([]float64, len(cuts)+1)
+		for _, v := range vs {
+			bin, _ := slices.BinarySearch(cuts, v)
+			result[bin]++
+		}
+		for i := range result {
+			result[i] /= float64(len(vs))
+		}
+		return result
+	}
+	expected, actual := proportions(baseline), proportions(current)
+
+	var psi float64
+	for i := range expected {
+		e, a := math.Max(expected[i], psiFloor), math.Max(actual[i], psiFloor)
+		psi += (a - e) * math.Log(a/e)
+	}
+	return psi
+}
+
+// AdjustedRandIndex 返回同一批数据的两组分组标签的调整兰德指数，
+// 1为完全一致，0为与随机分组相当
+func AdjustedRandIndex(a, b []int) float64 {
+	pairs := func(n float64) float64 { return n * (n - 1) / 2 }
+
+	type cell struct{ a, b int }
+	contingency := map[cell]float64{}
+	rows, cols := map[int]float64{}, map[int]float64{}
+	for i := range a {
+		contingency[cell{a[i], b[i]}]++
+		rows[a[i]]++
+		cols[b[i]]++
+	}
+
+	var index, sumRows, sumCols float64
+	for _, n := range contingency {
+		index += pairs(n)
+	}
+	for _, n := range rows {
+		sumRows += pairs(n)
+	}
+	for _, n := range cols {
+		sumCols += pairs(n)
+	}
+
+	expected := sumRows * sumCols / pairs(float64(len(a)))
+	maximum := (sumRows + sumCols) / 2
+	if maximum == expected {
+		return 1
+	}
+	return (index - expected) / (maximum - expected)
+}
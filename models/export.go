@@ -0,0 +1,199 @@
+package models
+
+import (
+	"fmt"
+	"rfm_cluster/pkg/clusters"
+	"slices"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// 导出工作簿的工作表布局
+const (
+	// 所有分组写入同一个工作表
+	LayoutSingle = "single"
+	// 每个分组一个工作表
+	LayoutPerCluster = "per_cluster"
+)
+
+// 密度聚类中噪声的分组编号和名称
+const (
+	NoiseCluster = 0
+	NoiseName    = "噪声"
+	// 噪声单独成表时的工作表名称
+	NoiseSheet = "noise"
+)
+
+// ExportColumn 导出的一列数据
+type ExportColumn struct {
+	Name  string
+	Value func(row *UserRFM, cluster int) interface{}
+}
+
+// ExportColumns 所有可导出的列，按默认导出顺序排列。cluster为从1开始的分组编号，噪声为NoiseCluster
+var ExportColumns = []ExportColumn{
+	{"user_id", func(u *UserRFM, _ int) interface{} { return u.UserID }},
+	{"nickname", func(u *UserRFM, _ int) interface{} { return u.Nickname }},
+	{"birthday", func(u *UserRFM, _ int) interface{} { return u.Birthday }},
+	{"gender", func(u *UserRFM, _ int) interface{} { return u.Gender }},
+	{"recency_original", func(u *UserRFM, _ int) interface{} { return u.RecencyOriginal }},
+	{"frequency_original", func(u *UserRFM, _ int) interface{} { return u.FrequencyOriginal }},
+	{"monetary_original", func(u *UserRFM, _ int) interface{} { return u.MonetaryOriginal }},
+	{"recency_weighted", func(u *UserRFM, _ int) interface{} { return u.RecencyWeighted }},
+	{"frequency_weighted", func(u *UserRFM, _ int) interface{} { return u.FrequencyWeighted }},
+	{"monetary_weighted", func(u *UserRFM, _ int) interface{} { return u.MonetaryWeighted }},
+	{"cluster", func(_ *UserRFM, cluster int) interface{} { return cluster }},
+}
+
+// ProbabilitiesColumn 高斯混合模型中属于各分组的概率，每个分组导出一列，其他算法不导出
+const ProbabilitiesColumn = "probabilities"
+
+// ExportColumnNames 返回所有可导出列的名称
+func ExportColumnNames() []string {
+	names := make([]string, len(ExportColumns), len(ExportColumns)+1)
+	for i, column := range ExportColumns {
+		names[i] = column.Name
+	}
+	return append(names, ProbabilitiesColumn)
+}
+
+// ExportOptions 导出分组结果的选项
+type ExportOptions struct {
+	// 导出的列，为空时导出全部列
+	Columns []string `form:"columns"`
+	// 导出的分组编号（从1开始，NoiseCluster表示噪声），为空时导出全部分组和噪声
+	Clusters []int `form:"clusters"`
+	// 工作表布局，为空时使用LayoutSingle
+	Layout string `form:"layout"`
+}
+
+// Normalize 补全未指定的选项并检查取值
+func (o *ExportOptions) Normalize(k int) error {
+	if len(o.Columns) == 0 {
+		o.Columns = ExportColumnNames()
+	}
+	if o.Layout == "" {
+		o.Layout = LayoutSingle
+	}
+
+	for _, name := range o.Columns {
+		if !slices.Contains(ExportColumnNames(), name) {
+			return &ParamError{Name: "columns", Reason: fmt.Sprintf("unknown column %q", name)}
+		}
+	}
+	for _, ci := range o.Clusters {
+		if ci != NoiseCluster && (ci < 1 || ci > k) {
+			return &ParamError{Name: "clusters", Reason: fmt.Sprintf("cluster %d does not exist, there are %d clusters", ci, k)}
+		}
+	}
+	if o.Layout != LayoutSingle && o.Layout != LayoutPerCluster {
+		return &ParamError{Name: "layout", Reason: fmt.Sprintf("unknown layout %q", o.Layout)}
+	}
+	return nil
+}
+
+// NewClusteredWorkbook 按选项在内存中生成分为k组时的分组结果工作簿，噪声排在所有分组之后
+func NewClusteredWorkbook(analysis *Analysis, k int, options ExportOptions) (*excelize.File, error) {
+	cc, noise := analysis.Clusters(k), analysis.Noise
+	if err := options.Normalize(len(cc)); err != nil {
+		return nil, err
+	}
+
+	var memberships map[*UserRFM][]float64
+	if slices.Contains(options.Columns, ProbabilitiesColumn) {
+		memberships = analysis.Memberships(k)
+	}
+
+	columns := []ExportColumn{}
+	for _, name := range options.Columns {
+		for _, column := range ExportColumns {
+			if column.Name == name {
+				columns = append(columns, column)
+			}
+		}
+	}
+
+	header := make([]interface{}, len(columns))
+	for i, column := range columns {
+		header[i] = column.Name
+	}
+	if memberships != nil {
+		for c := 1; c <= len(cc); c++ {
+			header = append(header, fmt.Sprintf("probability_%d", c))
+		}
+	}
+
+	excel := excelize.NewFile()
+	const defaultSheet = "Sheet1"
+
+	groups := make([]clusters.Observations, 0, len(cc)+1)
+	for _, cluster := range cc {
+		groups = append(groups, cluster.Observations)
+	}
+	numbers := make([]int, len(groups))
+	for i := range numbers {
+		numbers[i] = i + 1
+	}
+	if len(noise) > 0 {
+		groups = append(groups, noise)
+		numbers = append(numbers, NoiseCluster)
+	}
+
+	sheet := defaultSheet
+	row := 1
+	for gi, observations := range groups {
+		number := numbers[gi]
+		if len(options.Clusters) > 0 && !slices.Contains(options.Clusters, number) {
+			continue
+		}
+
+		if options.Layout == LayoutPerCluster || row == 1 {
+			if options.Layout == LayoutPerCluster {
+				sheet = fmt.Sprintf("cluster_%d", number)
+				if number == NoiseCluster {
+					sheet = NoiseSheet
+				}
+				if _, err := excel.NewSheet(sheet); err != nil {
+					return nil, err
+				}
+			}
+
+			// 写入表头
+			if err := excel.SetSheetRow(sheet, "A1", &header); err != nil {
+				return nil, err
+			}
+			row = 2
+		}
+
+		// 写入数据
+		for _, o := range observations {
+			rfm := o.(*UserRFM)
+
+			values := make([]interface{}, len(columns), len(header))
+			for i, column := range columns {
+				values[i] = column.Value(rfm, number)
+			}
+			for _, p := range memberships[rfm] {
+				values = append(values, p)
+			}
+
+			cell, err := excelize.CoordinatesToCellName(1, row)
+			if err != nil {
+				return nil, err
+			}
+			if err := excel.SetSheetRow(sheet, cell, &values); err != nil {
+				return nil, err
+			}
+			row++
+		}
+	}
+
+	if options.Layout == LayoutPerCluster && sheet != defaultSheet {
+		if err := excel.DeleteSheet(defaultSheet); err != nil {
+			return nil, err
+		}
+		excel.SetActiveSheet(0)
+	}
+
+	return excel, nil
+}
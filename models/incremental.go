@@ -0,0 +1,276 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"rfm_cluster/pkg/clusters"
+	"rfm_cluster/pkg/kmeans"
+	"slices"
+	"time"
+)
+
+// IncrementalStateVersion 增量分群状态文件的格式版本，格式不兼容时递增
+const IncrementalStateVersion = 1
+
+// IncrementalSegmentation 在分群模型的基础上用顺序k-means逐个吸收新增或变化的用户，
+// 分组中心相对上次完整拟合的偏移超过阈值时，用全部已知用户重新完整拟合
+type IncrementalSegmentation struct {
+	Version int `json:"version"`
+	// 打分规则、度量和分组名称，分组中心为上次完整拟合的结果
+	Model  *SegmentationModel `json:"model"`
+	Online *kmeans.Online     `json:"online"`
+	// 触发完整拟合的分组中心偏移（得分的欧氏距离）
+	DriftThreshold float64 `json:"drift_threshold"`
+	// 每个已知用户打分后的坐标和所在分组，用户变化时先从原分组中移除
+	Customers map[uint64]IncrementalCustomer `json:"customers"`
+	// 完整拟合的次数
+	Refits    int       `json:"refits"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// IncrementalCustomer 一个已知用户的状态
+type IncrementalCustomer struct {
+	Coordinates clusters.Coordinates `json:"coordinates"`
+	// 从0开始的分组下标
+	Segment int `json:"segment"`
+}
+
+// IncrementalUpdate 一批用户的更新结果
+type IncrementalUpdate struct {
+	Predictions []Prediction `json:"predictions"`
+	// 本批更新后、完整拟合前分组中心的最大偏移
+	Drift float64 `json:"drift"`
+	// 偏移超过阈值触发了完整拟合，此时预测结果为拟合后的分组
+	Refit bool `json:"refit"`
+}
+
+// NewIncrementalSegmentation 以分为k组时的分析结果为起点，分析中的用户都成为已知用户。
+// decay在(0, 1]之间，每吸收一个用户前各分组累计的权重乘以decay，越小越偏重近期的用户
+func NewIncrementalSegmentation(analysis *Analysis, k int, decay, driftThreshold float64) (*IncrementalSegmentation, error) {
+	if decay <= 0 || decay > 1 {
+		return nil, &ParamError{Name: "decay", Reason: "must be greater than 0 and at most 1"}
+	}
+	if driftThreshold <= 0 {
+		return nil, &ParamError{Name: "drift_threshold", Reason: "must be greater than 0"}
+	}
+
+	model, err := NewSegmentationModel(analysis, k)
+	if err != nil {
+		return nil, err
+	}
+
+	centers := make([]clusters.Coordinates, len(model.Segments))
+	weights := make([]float64, len(model.Segments))
+	for si, s := range model.Segments {
+		centers[si] = s.Center
+		weights[si] = float64(s.Size)
+	}
+	online, err := kmeans.NewOnline(centers, weights, decay)
+	if err != nil {
+		return nil, err
+	}
+
+	customers := map[uint64]IncrementalCustomer{}
+	for ci, c := range analysis.Clusters(k) {
+		for _, o := range c.Observations {
+			customers[o.(*UserRFM).UserID] = IncrementalCustomer{Coordinates: slices.Clone(o.Coordinates()), Segment: ci}
+		}
+	}
+
+	return &IncrementalSegmentation{
+		Version:        IncrementalStateVersion,
+		Model:          model,
+		Online:         online,
+		DriftThreshold: driftThreshold,
+		Customers:      customers,
+		UpdatedAt:      time.Now(),
+	}, nil
+}
+
+// Update 按顺序吸收一批新增或变化的用户并返回它们的分组，
+// 分组中心的偏移超过阈值时用全部已知用户重新完整拟合。返回错误时状态保持不变
+func (s *IncrementalSegmentation) Update(rows []*UserRFM) (IncrementalUpdate, error) {
+	scored, err := s.Model.score(rows)
+	if err != nil {
+		return IncrementalUpdate{}, err
+	}
+
+	// 完整拟合失败时撤销本批的更新：分组中心恢复为更新前的副本，
+	// 用户恢复为更新前的状态，之前未知的用户记为nil
+	online, updatedAt := s.Online.Clone(), s.UpdatedAt
+	previous := map[uint64]*IncrementalCustomer{}
+	rollback := func() {
+		s.Online, s.UpdatedAt = online, updatedAt
+		for id, c := range previous {
+			if c == nil {
+				delete(s.Customers, id)
+			} else {
+				s.Customers[id] = *c
+			}
+		}
+	}
+
+	result := IncrementalUpdate{Predictions: make([]Prediction, len(scored))}
+	for i, row := range scored {
+		old, ok := s.Customers[row.UserID]
+		if _, recorded := previous[row.UserID]; !recorded {
+			if ok {
+				previous[row.UserID] = &old
+			} else {
+				previous[row.UserID] = nil
+			}
+		}
+		if ok {
+			s.Online.Remove(old.Coordinates, old.Segment)
+		}
+		si := s.Online.Add(row)
+		s.Customers[row.UserID] = IncrementalCustomer{Coordinates: slices.Clone(row.Coordinates()), Segment: si}
+		result.Predictions[i] = s.Model.prediction(row, si, row.Distance(s.Online.Centers[si]))
+	}
+	s.UpdatedAt = time.Now()
+
+	result.Drift = s.Online.Drift()
+	if result.Drift <= s.DriftThreshold {
+		return result, nil
+	}
+
+	if err := s.Refit(); err != nil {
+		rollback()
+		return IncrementalUpdate{}, err
+	}
+	result.Refit = true
+	for i, row := range scored {
+		si := s.Customers[row.UserID].Segment
+		result.Predictions[i] = s.Model.prediction(row, si, row.Distance(s.Online.Centers[si]))
+	}
+	return result, nil
+}
+
+// Refit 从当前的分组中心出发，用k-means对全部已知用户完整拟合，并重新命名分组。
+// 拟合的结果全部算出后才替换状态，返回错误时状态保持不变
+func (s *IncrementalSegmentation) Refit() error {
+	k := len(s.Online.Centers)
+	if len(s.Customers) < k {
+		return fmt.Errorf("%d customers cannot be partitioned into %d clusters", len(s.Customers), k)
+	}
+	metric, err := s.Model.metric()
+	if err != nil {
+		return err
+	}
+
+	// 按用户编号排序，相同的状态得到相同的拟合结果
+	ids := make([]uint64, 0, len(s.Customers))
+	for id := range s.Customers {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	x := clusters.NewMatrix(len(ids), len(s.Online.Centers[0]), metric)
+	for i, id := range ids {
+		copy(x.Row(i), s.Customers[id].Coordinates)
+	}
+
+	cc, err := kmeans.New().WithSeed(s.Model.Params.Seed).WithCenters(s.Online.Centers).WithWorkers(kmeansWorkers).Partition(x.Observations(), k)
+	if err != nil {
+		return err
+	}
+
+	centers := make([]clusters.Coordinates, k)
+	weights := make([]float64, k)
+	segmentOf := make([]int, len(ids))
+	segments := slices.Clone(s.Model.Segments)
+	names := ClusterNames(cc)
+	for ci, c := range cc {
+		for _, o := range c.Observations {
+			segmentOf[o.(clusters.MatrixRow).Index] = ci
+		}
+		centers[ci] = c.Center
+		weights[ci] = float64(len(c.Observations))
+		segments[ci].Center = slices.Clone(c.Center)
+		segments[ci].Name = names[ci]
+		segments[ci].Size = len(c.Observations)
+	}
+	online := s.Online.Clone()
+	if err := online.Rebase(centers, weights); err != nil {
+		return err
+	}
+
+	s.Online = online
+	s.Model.Segments = segments
+	for i, id := range ids {
+		s.Customers[id] = IncrementalCustomer{Coordinates: s.Customers[id].Coordinates, Segment: segmentOf[i]}
+	}
+	s.Refits++
+	s.UpdatedAt = time.Now()
+	return nil
+}
+
+// Save 以JSON格式保存增量分群的状态
+func (s *IncrementalSegmentation) Save(w io.Writer) error {
+	return json.NewEncoder(w).Encode(s)
+}
+
+// LoadIncrementalSegmentation 读取Save保存的状态，版本不一致或内容不完整时返回错误
+func LoadIncrementalSegmentation(r io.Reader) (*IncrementalSegmentation, error) {
+	s := &IncrementalSegmentation{}
+	if err := json.NewDecoder(r).Decode(s); err != nil {
+		return nil, fmt.Errorf("failed to decode incremental state: %w", err)
+	}
+	if s.Version != IncrementalStateVersion {
+		return nil, fmt.Errorf("unsupported incremental state version %d, expected %d", s.Version, IncrementalStateVersion)
+	}
+	if s.Model == nil || s.Online == nil {
+		return nil, fmt.Errorf("the incremental state has no model")
+	}
+	if err := s.Model.Validate(); err != nil {
+		return nil, err
+	}
+	k := len(s.Model.Segments)
+	if len(s.Online.Centers) != k || len(s.Online.Weights) != k || len(s.Online.Reference) != k {
+		return nil, fmt.Errorf("the incremental state must hold %d centers", k)
+	}
+	for id, c := range s.Customers {
+		if c.Segment < 0 || c.Segment >= k {
+			return nil, fmt.Errorf("customer %d: segment %d out of range", id, c.Segment)
+		}
+	}
+	if s.Customers == nil {
+		s.Customers = map[uint64]IncrementalCustomer{}
+	}
+	return s, nil
+}
+
+// Checkpoint 把状态保存到path，先写入同目录的临时文件再替换，中途失败不会损坏已有的文件
+func (s *IncrementalSegmentation) Checkpoint(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := s.Save(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadCheckpoint 读取Checkpoint保存的状态，文件不存在时返回的错误满足errors.Is(err, os.ErrNotExist)
+func LoadCheckpoint(path string) (*IncrementalSegmentation, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s, err := LoadIncrementalSegmentation(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
@@ -0,0 +1,196 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// 迁移矩阵中只在一次运行中出现的用户
+const (
+	// MigrationNew 只在当前运行中出现的用户，作为迁移矩阵的最后一行
+	MigrationNew = "新用户"
+	// MigrationLost 只在基准运行中出现的用户，作为迁移矩阵的最后一列
+	MigrationLost = "流失"
+)
+
+// 迁移报告中工作表的名称
+const (
+	MigrationCountSheet   = "migration_count"
+	MigrationRevenueSheet = "migration_revenue"
+)
+
+// Migration 两次运行之间用户按UserID在分组间的迁移
+type Migration struct {
+	BaselineKey string `json:"baseline_key"`
+	CurrentKey  string `json:"current_key"`
+	// 矩阵的行为基准运行的分组，最后一行为新用户
+	From []MigrationSegment `json:"from"`
+	// 矩阵的列为当前运行的分组，最后一列为流失用户
+	To []MigrationSegment `json:"to"`
+	// Counts[i][j]为从From[i]迁移到To[j]的用户数
+	Counts [][]int `json:"counts"`
+	// Revenue[i][j]为这些用户的消费金额，取当前运行的金额，流失用户取基准运行的金额
+	Revenue [][]float64 `json:"revenue"`
+}
+
+// MigrationSegment 迁移矩阵的一行或一列
+type MigrationSegment struct {
+	// 从1开始的分组编号，噪声为NoiseCluster，新用户和流失用户为-1
+	Cluster int    `json:"cluster"`
+	Name    string `json:"name"`
+}
+
+// Label 返回在图表和工作表中展示的名称
+func (s MigrationSegment) Label() string {
+	if s.Cluster < 0 {
+		return s.Name
+	}
+	return fmt.Sprintf("第%d组 %s", s.Cluster, s.Name)
+}
+
+// NewMigration 按UserID关联两次运行的分组结果，统计用户在分组间的迁移。
+// 用户所在的分组不在运行的模型中时返回错误
+func NewMigration(baseline, current *RunSnapshot) (*Migration, error) {
+	m := &Migration{
+		BaselineKey: baseline.Model.AnalysisKey,
+		CurrentKey:  current.Model.AnalysisKey,
+		From:        append(migrationSegments(baseline), MigrationSegment{Cluster: -1, Name: MigrationNew}),
+		To:          append(migrationSegments(current), MigrationSegment{Cluster: -1, Name: MigrationLost}),
+	}
+	m.Counts = make([][]int, len(m.From))
+	m.Revenue = make([][]float64, len(m.From))
+	for i := range m.From {
+		m.Counts[i] = make([]int, len(m.To))
+		m.Revenue[i] = make([]float64, len(m.To))
+	}
+
+	index := func(segments []MigrationSegment, a Assignment, run string) (int, error) {
+		i := slices.IndexFunc(segments, func(s MigrationSegment) bool { return s.Cluster == a.Cluster })
+		if i < 0 {
+			return 0, fmt.Errorf("user %d of the %s run is assigned to cluster %d, which is not in its model", a.UserID, run, a.Cluster)
+		}
+		return i, nil
+	}
+	newRow, lostColumn := len(m.From)-1, len(m.To)-1
+
+	before := map[uint64]Assignment{}
+	for _, a := range baseline.Assignments {
+		before[a.UserID] = a
+	}
+	seen := map[uint64]bool{}
+	for _, a := range current.Assignments {
+		row := newRow
+		if b, ok := before[a.UserID]; ok {
+			var err error
+			if row, err = index(m.From, b, "baseline"); err != nil {
+				return nil, err
+			}
+		}
+		column, err := index(m.To, a, "current")
+		if err != nil {
+			return nil, err
+		}
+		m.Counts[row][column]++
+		m.Revenue[row][column] += a.MonetaryOriginal
+		seen[a.UserID] = true
+	}
+	for _, b := range baseline.Assignments {
+		if seen[b.UserID] {
+			continue
+		}
+		row, err := index(m.From, b, "baseline")
+		if err != nil {
+			return nil, err
+		}
+		m.Counts[row][lostColumn]++
+		m.Revenue[row][lostColumn] += b.MonetaryOriginal
+		seen[b.UserID] = true
+	}
+	return m, nil
+}
+
+// migrationSegments 返回运行的所有分组，有噪声时最后加上噪声
+func migrationSegments(s *RunSnapshot) []MigrationSegment {
+	var result []MigrationSegment
+	for _, segment := range s.Model.Segments {
+		result = append(result, MigrationSegment{Cluster: segment.Cluster, Name: segment.Name})
+	}
+	if slices.ContainsFunc(s.Assignments, func(a Assignment) bool { return a.Cluster == NoiseCluster }) {
+		result = append(result, MigrationSegment{Cluster: NoiseCluster, Name: NoiseName})
+	}
+	return result
+}
+
+// NewMigrationWorkbook 生成迁移报告，分别为人数和金额的迁移矩阵
+func NewMigrationWorkbook(m *Migration) (*excelize.File, error) {
+	excel := excelize.NewFile()
+
+	decimal, err := excel.NewStyle(&excelize.Style{NumFmt: 2})
+	if err != nil {
+		excel.Close()
+		return nil, err
+	}
+
+	counts := make([][]interface{}, len(m.From))
+	revenue := make([][]interface{}, len(m.From))
+	for i := range m.From {
+		for j := range m.To {
+			counts[i] = append(counts[i], m.Counts[i][j])
+			revenue[i] = append(revenue[i], m.Revenue[i][j])
+		}
+	}
+	if err := writeMigrationSheet(excel, MigrationCountSheet, m, counts, -1); err != nil {
+		excel.Close()
+		return nil, err
+	}
+	if err := writeMigrationSheet(excel, MigrationRevenueSheet, m, revenue, decimal); err != nil {
+		excel.Close()
+		return nil, err
+	}
+
+	if err := excel.DeleteSheet("Sheet1"); err != nil {
+		excel.Close()
+		return nil, err
+	}
+	excel.SetActiveSheet(0)
+	return excel, nil
+}
+
+// writeMigrationSheet 写入一个迁移矩阵，第一行为当前运行的分组，第一列为基准运行的分组。
+// style小于0时不设置单元格格式
+func writeMigrationSheet(excel *excelize.File, sheet string, m *Migration, values [][]interface{}, style int) error {
+	if _, err := excel.NewSheet(sheet); err != nil {
+		return err
+	}
+
+	header := []interface{}{"baseline \\ current"}
+	for _, s := range m.To {
+		header = append(header, s.Label())
+	}
+	if err := excel.SetSheetRow(sheet, "A1", &header); err != nil {
+		return err
+	}
+	for i, s := range m.From {
+		row := append([]interface{}{s.Label()}, values[i]...)
+		if err := excel.SetSheetRow(sheet, fmt.Sprintf("A%d", i+2), &row); err != nil {
+			return err
+		}
+	}
+
+	if style >= 0 {
+		last, err := excelize.CoordinatesToCellName(len(m.To)+1, len(m.From)+1)
+		if err != nil {
+			return err
+		}
+		if err := excel.SetCellStyle(sheet, "B2", last, style); err != nil {
+			return err
+		}
+	}
+	lastColumn, err := excelize.ColumnNumberToName(len(m.To) + 1)
+	if err != nil {
+		return err
+	}
+	return excel.SetColWidth(sheet, "A", lastColumn, 18)
+}
@@ -0,0 +1,195 @@
+package models
+
+import (
+	"fmt"
+	"rfm_cluster/pkg/clusters"
+	"rfm_cluster/pkg/gmm"
+	"rfm_cluster/pkg/hierarchical"
+	"rfm_cluster/pkg/kmeans"
+	"slices"
+	"time"
+)
+
+// AnalysisParams 一次RFM聚类分析的参数
+type AnalysisParams struct {
+	// 统计截止时间（毫秒时间戳），为0时取当天零点
+	PurchaseEnd int64 `form:"purchase_end" json:"purchase_end"`
+	// 轮廓系数遍历的最大分组数，遍历范围为[2, KMax]
+	KMax int `form:"k_max,default=8" json:"k_max"`
+	// 指定分组数，为0时使用轮廓系数最高的分组数
+	K int `form:"k" json:"k"`
+	// 评分预设名称
+	Scoring string `form:"scoring,default=default" json:"scoring"`
+	// 随机种子，相同的种子和数据得到相同的分组，为0时使用DefaultSeed
+	Seed int64 `form:"seed" json:"seed"`
+	// 聚类算法名称
+	Algorithm string `form:"algorithm,default=kmeans" json:"algorithm"`
+	// DBSCAN的邻域半径（与Observation.Distance比较），为0时按k距离曲线的拐点自动选取
+	Eps float64 `form:"eps" json:"eps"`
+	// 密度聚类中核心点邻域内至少包含的数据数（含自身）
+	MinPts int `form:"min_pts,default=5" json:"min_pts"`
+	// HDBSCAN的最小分组大小，为0时取MinPts
+	MinClusterSize int `form:"min_cluster_size" json:"min_cluster_size"`
+	// 层次聚类合并分组时的距离计算方式
+	Linkage string `form:"linkage,default=ward" json:"linkage"`
+	// 高斯混合模型的协方差矩阵形式
+	Covariance string `form:"covariance,default=full" json:"covariance"`
+	// 软聚类估计分组数时使用的准则，不适用于当前算法时使用该算法的默认准则
+	Criterion string `form:"criterion" json:"criterion"`
+	// 模糊C均值的模糊指数m，越接近1越接近k-means
+	Fuzzifier float64 `form:"fuzzifier,default=2" json:"fuzzifier"`
+	// 计算距离使用的度量，影响分组、轮廓系数和到分组中心的距离
+	Metric string `form:"metric,default=euclidean" json:"metric"`
+	// 小批量k-means每次迭代抽取的数据数
+	BatchSize int `form:"batch_size,default=1024" json:"batch_size"`
+	// 限制分组大小的k-means中每个分组的最少用户数
+	MinSize int `form:"min_size" json:"min_size"`
+	// 限制分组大小的k-means中每个分组的最多用户数，为0时不限制
+	MaxSize int `form:"max_size" json:"max_size"`
+	// k-means初始化分组中心的方式，custom为使用Centers
+	Init string `form:"init,default=kmeanspp" json:"init"`
+	// Init为custom时的初始分组中心，格式见ParseCenters，为空时使用配置的默认中心
+	Centers string `form:"centers" json:"centers"`
+}
+
+// 分组数的取值范围
+const (
+	MinK = 2
+	MaxK = 20
+)
+
+// DefaultSeed 未指定种子时使用的随机种子
+const DefaultSeed = 1
+
+// Normalize 补全未指定的参数并检查参数之间的约束
+func (p *AnalysisParams) Normalize() error {
+	if p.PurchaseEnd == 0 {
+		// 取当天零点，保证同一天内的请求参数一致，可以命中缓存
+		now := time.Now()
+		p.PurchaseEnd = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).UnixMilli()
+	}
+	if p.Seed == 0 {
+		// 固定的种子让同一份数据和参数对应同一个缓存键，需要不同的结果时显式指定种子
+		p.Seed = DefaultSeed
+	}
+	if p.KMax == 0 {
+		p.KMax = 8
+	}
+	if p.Scoring == "" {
+		p.Scoring = ScoringDefault
+	}
+	if p.Algorithm == "" {
+		p.Algorithm = AlgorithmKmeans
+	}
+	if p.Linkage == "" {
+		p.Linkage = string(hierarchical.Ward)
+	}
+	if p.Covariance == "" {
+		p.Covariance = string(gmm.Full)
+	}
+	if criteria, ok := defaultCriteria[p.Algorithm]; ok && !slices.Contains(criteria, p.Criterion) {
+		p.Criterion = criteria[0]
+	}
+	if p.Fuzzifier == 0 {
+		p.Fuzzifier = 2
+	}
+	if p.Metric == "" {
+		p.Metric = clusters.MetricEuclidean
+	}
+	if p.BatchSize == 0 {
+		p.BatchSize = 1024
+	}
+	if p.Init == "" {
+		p.Init = string(kmeans.KmeansPP)
+	}
+	if p.MinPts == 0 {
+		p.MinPts = 5
+	}
+	if p.MinClusterSize == 0 {
+		p.MinClusterSize = max(p.MinPts, 2)
+	}
+
+	if p.KMax < MinK || p.KMax > MaxK {
+		return &ParamError{Name: "k_max", Reason: fmt.Sprintf("must be between %d and %d", MinK, MaxK)}
+	}
+	if IsDensityAlgorithm(p.Algorithm) {
+		// 密度聚类的分组数由数据决定
+		p.K = 0
+	}
+	if p.K != 0 && (p.K < MinK || p.K > p.KMax) {
+		return &ParamError{Name: "k", Reason: fmt.Sprintf("must be between %d and k_max (%d)", MinK, p.KMax)}
+	}
+	if _, err := LookupScoringScheme(p.Scoring); err != nil {
+		return &ParamError{Name: "scoring", Reason: err.Error()}
+	}
+	if !slices.Contains(AlgorithmNames(), p.Algorithm) {
+		return &ParamError{Name: "algorithm", Reason: fmt.Sprintf("unknown algorithm %q", p.Algorithm)}
+	}
+	if !slices.Contains(hierarchical.Linkages(), hierarchical.Linkage(p.Linkage)) {
+		return &ParamError{Name: "linkage", Reason: fmt.Sprintf("unknown linkage %q", p.Linkage)}
+	}
+	if !slices.Contains(gmm.Covariances(), gmm.Covariance(p.Covariance)) {
+		return &ParamError{Name: "covariance", Reason: fmt.Sprintf("unknown covariance type %q", p.Covariance)}
+	}
+	if p.Criterion != "" && !slices.Contains(Criteria(), p.Criterion) {
+		return &ParamError{Name: "criterion", Reason: fmt.Sprintf("unknown criterion %q", p.Criterion)}
+	}
+	if _, ok := defaultCriteria[p.Algorithm]; !ok {
+		// 只有软聚类使用准则，清空后不影响缓存键
+		p.Criterion = ""
+	}
+	if p.Fuzzifier <= 1 {
+		return &ParamError{Name: "fuzzifier", Reason: "must be greater than 1"}
+	}
+	if !slices.Contains(clusters.Metrics(), p.Metric) {
+		return &ParamError{Name: "metric", Reason: fmt.Sprintf("unknown metric %q", p.Metric)}
+	}
+	if p.BatchSize < 1 {
+		return &ParamError{Name: "batch_size", Reason: "must be at least 1"}
+	}
+	if p.MinSize < 0 {
+		return &ParamError{Name: "min_size", Reason: "must not be negative"}
+	}
+	if p.MaxSize < 0 || (p.MaxSize > 0 && p.MaxSize < max(p.MinSize, 1)) {
+		return &ParamError{Name: "max_size", Reason: "must be 0 (no limit) or at least min_size and 1"}
+	}
+	if !slices.Contains(InitNames(), p.Init) {
+		return &ParamError{Name: "init", Reason: fmt.Sprintf("unknown initialization %q", p.Init)}
+	}
+	if p.Init == InitCustom {
+		if p.Centers == "" {
+			p.Centers = FormatCenters(defaultCenters)
+		}
+		if p.Centers == "" {
+			return &ParamError{Name: "centers", Reason: "required when init is custom, no default centers are configured"}
+		}
+		centers, err := ParseCenters(p.Centers)
+		if err != nil {
+			return &ParamError{Name: "centers", Reason: err.Error()}
+		}
+		// 统一格式，相同的中心得到相同的缓存键
+		p.Centers = FormatCenters(centers)
+	} else {
+		p.Centers = ""
+	}
+	if p.Eps < 0 {
+		return &ParamError{Name: "eps", Reason: "must not be negative"}
+	}
+	if p.MinPts < 1 {
+		return &ParamError{Name: "min_pts", Reason: "must be at least 1"}
+	}
+	if p.MinClusterSize < 2 {
+		return &ParamError{Name: "min_cluster_size", Reason: "must be at least 2"}
+	}
+	return nil
+}
+
+// ParamError 描述一个不合法的分析参数
+type ParamError struct {
+	Name   string
+	Reason string
+}
+
+func (e *ParamError) Error() string {
+	return fmt.Sprintf("invalid parameter %q: %s", e.Name, e.Reason)
+}
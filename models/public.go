@@ -2,6 +2,7 @@ package models
 
 import (
 	"math"
+	"slices"
 )
 
 func Mean(v []float64) float64 {
@@ -26,3 +27,18 @@ func Variance(v []float64) float64 {
 func Stddev(v []float64) float64 {
 	return math.Sqrt(Variance(v))
 }
+
+func Median(v []float64) float64 {
+	var n int = len(v)
+	if n == 0 {
+		return 0
+	}
+
+	sorted := make([]float64, n)
+	copy(sorted, v)
+	slices.Sort(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
@@ -0,0 +1,273 @@
+package models
+
+import (
+	"fmt"
+	"rfm_cluster/pkg/kmeans"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// 分析报告中汇总类工作表的名称
+const (
+	ReportSummarySheet    = "summary"
+	ReportSilhouetteSheet = "silhouette"
+	ReportParametersSheet = "parameters"
+)
+
+// NewReportWorkbook 生成分为k组时的完整分析报告：汇总、轮廓系数、运行参数，
+// 以及每个分组一个成员工作表，汇总和轮廓系数工作表附带Excel原生图表
+func NewReportWorkbook(analysis *Analysis, k int) (*excelize.File, error) {
+	cc := analysis.Clusters(k)
+
+	excel, err := NewClusteredWorkbook(analysis, k, ExportOptions{Layout: LayoutPerCluster})
+	if err != nil {
+		return nil, err
+	}
+
+	percent, err := excel.NewStyle(&excelize.Style{NumFmt: 10})
+	if err != nil {
+		return nil, err
+	}
+	decimal, err := excel.NewStyle(&excelize.Style{NumFmt: 2})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeSummarySheet(excel, SummarizeClusters(cc), percent, decimal); err != nil {
+		return nil, err
+	}
+	if err := writeSilhouetteSheet(excel, analysis, k, decimal); err != nil {
+		return nil, err
+	}
+	if err := writeParametersSheet(excel, analysis, k); err != nil {
+		return nil, err
+	}
+
+	// 汇总类工作表排在成员工作表之前
+	for _, sheet := range []string{ReportSummarySheet, ReportSilhouetteSheet, ReportParametersSheet} {
+		if err := excel.MoveSheet(sheet, "cluster_1"); err != nil {
+			return nil, err
+		}
+	}
+	index, err := excel.GetSheetIndex(ReportSummarySheet)
+	if err != nil {
+		return nil, err
+	}
+	excel.SetActiveSheet(index)
+
+	return excel, nil
+}
+
+func writeSummarySheet(excel *excelize.File, summaries []ClusterSummary, percent, decimal int) error {
+	sheet := ReportSummarySheet
+	if _, err := excel.NewSheet(sheet); err != nil {
+		return err
+	}
+
+	header := []interface{}{
+		"cluster", "name", "size", "size_share",
+		"recency_mean", "recency_median", "frequency_mean", "frequency_median",
+		"monetary_mean", "monetary_median", "revenue", "revenue_share",
+		"representative_user_id",
+	}
+	if err := excel.SetSheetRow(sheet, "A1", &header); err != nil {
+		return err
+	}
+
+	for i, s := range summaries {
+		row := []interface{}{
+			s.Cluster, s.Name, s.Size, s.SizeShare,
+			s.RecencyMean, s.RecencyMedian, s.FrequencyMean, s.FrequencyMedian,
+			s.MonetaryMean, s.MonetaryMedian, s.Revenue, s.RevenueShare,
+			nil,
+		}
+		if s.Representative != nil {
+			row[len(row)-1] = s.Representative.UserID
+		}
+		if err := excel.SetSheetRow(sheet, fmt.Sprintf("A%d", i+2), &row); err != nil {
+			return err
+		}
+	}
+
+	last := len(summaries) + 1
+	styles := []struct {
+		from, to string
+		style    int
+	}{
+		{"D", "D", percent},
+		{"E", "K", decimal},
+		{"L", "L", percent},
+	}
+	for _, s := range styles {
+		if err := excel.SetCellStyle(sheet, s.from+"2", fmt.Sprintf("%s%d", s.to, last), s.style); err != nil {
+			return err
+		}
+	}
+	if err := excel.SetColWidth(sheet, "B", "B", 16); err != nil {
+		return err
+	}
+
+	sizes := &excelize.Chart{
+		Type:   excelize.Col,
+		Title:  []excelize.RichTextRun{{Text: "分组人数"}},
+		Legend: excelize.ChartLegend{Position: "none"},
+		Series: []excelize.ChartSeries{{
+			Name:       fmt.Sprintf("%s!$C$1", sheet),
+			Categories: fmt.Sprintf("%s!$B$2:$B$%d", sheet, last),
+			Values:     fmt.Sprintf("%s!$C$2:$C$%d", sheet, last),
+		}},
+	}
+	if err := excel.AddChart(sheet, "O2", sizes); err != nil {
+		return err
+	}
+
+	revenue := &excelize.Chart{
+		Type:  excelize.Pie,
+		Title: []excelize.RichTextRun{{Text: "消费金额占比"}},
+		Series: []excelize.ChartSeries{{
+			Name:       fmt.Sprintf("%s!$K$1", sheet),
+			Categories: fmt.Sprintf("%s!$B$2:$B$%d", sheet, last),
+			Values:     fmt.Sprintf("%s!$K$2:$K$%d", sheet, last),
+		}},
+	}
+	return excel.AddChart(sheet, "O18", revenue)
+}
+
+func writeSilhouetteSheet(excel *excelize.File, analysis *Analysis, k int, decimal int) error {
+	sheet := ReportSilhouetteSheet
+	if _, err := excel.NewSheet(sheet); err != nil {
+		return err
+	}
+
+	header := []interface{}{"k", "score", "selected"}
+	fuzzy := analysis.Params.Algorithm == AlgorithmFuzzy
+	comparisons := map[int]kmeans.Comparison{}
+	for _, c := range analysis.InertiaComparisons {
+		comparisons[c.K] = c
+	}
+	if len(analysis.SoftPartitions) > 0 {
+		if fuzzy {
+			header = append(header, "partition_coefficient", "partition_entropy")
+		} else {
+			header = append(header, "bic", "aic", "log_likelihood")
+		}
+	}
+	if len(comparisons) > 0 {
+		header = append(header, "sample_size", "sample_sse_mini_batch", "sample_sse_full")
+	}
+	if len(analysis.Convergence) > 0 {
+		header = append(header, "iterations", "stop_reason", "inertia")
+	}
+	if err := excel.SetSheetRow(sheet, "A1", &header); err != nil {
+		return err
+	}
+	for i, score := range analysis.Scores {
+		row := []interface{}{score.K, score.Score, score.K == k}
+		if p := analysis.SoftPartition(score.K); p != nil {
+			if fuzzy {
+				row = append(row, p.PartitionCoefficient, p.PartitionEntropy)
+			} else {
+				row = append(row, p.BIC, p.AIC, p.LogLikelihood)
+			}
+		}
+		if c, ok := comparisons[score.K]; ok {
+			row = append(row, c.Sample, c.MiniBatch, c.Full)
+		}
+		if c := analysis.ConvergenceOf(score.K); c != nil {
+			row = append(row, c.Iterations, string(c.Reason), c.Inertia)
+		}
+		if err := excel.SetSheetRow(sheet, fmt.Sprintf("A%d", i+2), &row); err != nil {
+			return err
+		}
+	}
+
+	last := len(analysis.Scores) + 1
+	if err := excel.SetCellStyle(sheet, "B2", fmt.Sprintf("B%d", last), decimal); err != nil {
+		return err
+	}
+
+	line := &excelize.Chart{
+		Type:   excelize.Line,
+		Title:  []excelize.RichTextRun{{Text: "轮廓系数"}},
+		Legend: excelize.ChartLegend{Position: "none"},
+		Series: []excelize.ChartSeries{{
+			Name:       fmt.Sprintf("%s!$B$1", sheet),
+			Categories: fmt.Sprintf("%s!$A$2:$A$%d", sheet, last),
+			Values:     fmt.Sprintf("%s!$B$2:$B$%d", sheet, last),
+			Marker:     excelize.ChartMarker{Symbol: "circle"},
+		}},
+	}
+	return excel.AddChart(sheet, "H2", line)
+}
+
+func writeParametersSheet(excel *excelize.File, analysis *Analysis, k int) error {
+	sheet := ReportParametersSheet
+	if _, err := excel.NewSheet(sheet); err != nil {
+		return err
+	}
+
+	params := analysis.Params
+	score, _ := analysis.KScore(k)
+	rows := [][]interface{}{
+		{"parameter", "value"},
+		{"analysis_key", analysis.Key},
+		{"dataset_hash", analysis.DatasetHash},
+		{"rows", len(analysis.Data)},
+		{"purchase_end", time.UnixMilli(params.PurchaseEnd).Format(time.DateTime)},
+		{"scoring", params.Scoring},
+		{"algorithm", params.Algorithm},
+		{"k_max", params.KMax},
+		{"k", k},
+		{"estimated_k", analysis.Estimate},
+		{"silhouette_score", score.Score},
+		{"seed", params.Seed},
+		{"metric", params.Metric},
+	}
+	if params.Algorithm == AlgorithmGMM {
+		rows = append(rows,
+			[]interface{}{"covariance", params.Covariance},
+			[]interface{}{"criterion", params.Criterion},
+		)
+	}
+	if params.Algorithm == AlgorithmKmeans {
+		rows = append(rows, []interface{}{"init", params.Init})
+		if params.Centers != "" {
+			rows = append(rows, []interface{}{"centers", params.Centers})
+		}
+	}
+	if params.Algorithm == AlgorithmConstrained {
+		rows = append(rows,
+			[]interface{}{"min_size", params.MinSize},
+			[]interface{}{"max_size", params.MaxSize},
+		)
+	}
+	if params.Algorithm == AlgorithmMiniBatch {
+		rows = append(rows, []interface{}{"batch_size", params.BatchSize})
+	}
+	if params.Algorithm == AlgorithmFuzzy {
+		rows = append(rows,
+			[]interface{}{"fuzzifier", params.Fuzzifier},
+			[]interface{}{"criterion", params.Criterion},
+		)
+	}
+	if IsDensityAlgorithm(params.Algorithm) {
+		rows = append(rows,
+			[]interface{}{"eps", analysis.Eps},
+			[]interface{}{"min_pts", params.MinPts},
+			[]interface{}{"min_cluster_size", params.MinClusterSize},
+			[]interface{}{"noise", len(analysis.Noise)},
+		)
+	}
+	rows = append(rows,
+		[]interface{}{"analysed_at", analysis.CreatedAt.Format(time.DateTime)},
+		[]interface{}{"generated_at", time.Now().Format(time.DateTime)},
+	)
+	for i, row := range rows {
+		if err := excel.SetSheetRow(sheet, fmt.Sprintf("A%d", i+1), &row); err != nil {
+			return err
+		}
+	}
+
+	return excel.SetColWidth(sheet, "A", "B", 36)
+}
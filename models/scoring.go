@@ -0,0 +1,152 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// ScoringScheme 把原始RFM值映射为1-5分（从未消费的R记为0分）
+type ScoringScheme func(dataCollection []*UserRFM)
+
+// 评分预设名称
+const (
+	ScoringDefault  = "default"
+	ScoringQuintile = "quintile"
+)
+
+var scoringSchemes = map[string]ScoringScheme{
+	ScoringDefault:  scoreByFixedThresholds,
+	ScoringQuintile: scoreByQuintile,
+}
+
+// ScoringSchemeNames 返回所有可用的评分预设名称
+func ScoringSchemeNames() []string {
+	names := make([]string, 0, len(scoringSchemes))
+	for name := range scoringSchemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupScoringScheme 按名称查找评分预设，空字符串返回默认预设
+func LookupScoringScheme(name string) (ScoringScheme, error) {
+	if name == "" {
+		name = ScoringDefault
+	}
+
+	scheme, ok := scoringSchemes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown scoring scheme %q", name)
+	}
+	return scheme, nil
+}
+
+// scoreByFixedThresholds 按业务固定阈值打分
+func scoreByFixedThresholds(dataCollection []*UserRFM) {
+	for _, row := range dataCollection {
+		var r, f, m float64 = 0.0, 0.0, 0.0
+		if row.RecencyOriginal == -1 {
+			r = 0
+		} else if row.RecencyOriginal <= 1 {
+			r = 5
+		} else if row.RecencyOriginal > 1 && row.RecencyOriginal <= 7 {
+			r = 4
+		} else if row.RecencyOriginal > 7 && row.RecencyOriginal <= 31 {
+			r = 3
+		} else if row.RecencyOriginal > 31 && row.RecencyOriginal <= 93 {
+			r = 2
+		} else if row.RecencyOriginal > 93 {
+			r = 1
+		}
+
+		if row.FrequencyOriginal == 1 {
+			f = 1
+		} else if row.FrequencyOriginal >= 2 && row.FrequencyOriginal <= 4 {
+			f = 2
+		} else if row.FrequencyOriginal >= 5 && row.FrequencyOriginal <= 7 {
+			f = 3
+		} else if row.FrequencyOriginal >= 8 && row.FrequencyOriginal <= 10 {
+			f = 4
+		} else if row.FrequencyOriginal >= 11 {
+			f = 5
+		}
+
+		if row.MonetaryOriginal <= 6.99 {
+			m = 1
+		} else if row.MonetaryOriginal > 6.99 && row.MonetaryOriginal <= 14.99 {
+			m = 2
+		} else if row.MonetaryOriginal > 14.99 && row.MonetaryOriginal <= 39.99 {
+			m = 3
+		} else if row.MonetaryOriginal > 39.99 && row.MonetaryOriginal <= 71.88 {
+			m = 4
+		} else if row.MonetaryOriginal > 71.88 {
+			m = 5
+		}
+
+		row.RecencyWeighted = r
+		row.FrequencyWeighted = f
+		row.MonetaryWeighted = m
+	}
+}
+
+// scoreByQuintile 按五分位数打分，R越小分数越高，F、M越大分数越高
+func scoreByQuintile(dataCollection []*UserRFM) {
+	if len(dataCollection) == 0 {
+		return
+	}
+
+	scoreByBarriers(dataCollection, fitQuintileBarriers(dataCollection))
+}
+
+// ScoringBarriers 按分位区间打分时R、F、M各自的分界值，依次为20%、40%、60%、80%位置上的取值
+type ScoringBarriers struct {
+	Recency   []float64 `json:"recency"`
+	Frequency []float64 `json:"frequency"`
+	Monetary  []float64 `json:"monetary"`
+}
+
+// fitQuintileBarriers 返回数据的五分位数分界值
+func fitQuintileBarriers(dataCollection []*UserRFM) ScoringBarriers {
+	return ScoringBarriers{
+		Recency:   quintileBarriers(dataCollection, func(u *UserRFM) float64 { return u.RecencyOriginal }),
+		Frequency: quintileBarriers(dataCollection, func(u *UserRFM) float64 { return u.FrequencyOriginal }),
+		Monetary:  quintileBarriers(dataCollection, func(u *UserRFM) float64 { return u.MonetaryOriginal }),
+	}
+}
+
+// scoreByBarriers 按给定的分界值打分，新数据使用训练时的分界值才能与已有分组对应
+func scoreByBarriers(dataCollection []*UserRFM, barriers ScoringBarriers) {
+	for _, row := range dataCollection {
+		row.RecencyWeighted = 6 - quintileScore(row.RecencyOriginal, barriers.Recency)
+		row.FrequencyWeighted = quintileScore(row.FrequencyOriginal, barriers.Frequency)
+		row.MonetaryWeighted = quintileScore(row.MonetaryOriginal, barriers.Monetary)
+	}
+}
+
+// quintileBarriers 返回20%、40%、60%、80%位置上的取值
+func quintileBarriers(dataCollection []*UserRFM, value func(*UserRFM) float64) []float64 {
+	sorted := make([]float64, len(dataCollection))
+	for i, row := range dataCollection {
+		sorted[i] = value(row)
+	}
+	slices.Sort(sorted)
+
+	barrier := len(sorted) / 5
+	result := make([]float64, 4)
+	for i := 1; i < 5; i++ {
+		result[i-1] = sorted[i*barrier]
+	}
+	return result
+}
+
+// quintileScore 返回v所在的分位区间（1-5）
+func quintileScore(v float64, barriers []float64) float64 {
+	for i, b := range barriers {
+		if v <= b {
+			return float64(i + 1)
+		}
+	}
+	return 5
+}
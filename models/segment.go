@@ -0,0 +1,59 @@
+package models
+
+import (
+	"fmt"
+	"rfm_cluster/pkg/clusters"
+)
+
+// 经典RFM八类客户，依次对应R、F、M是否高于整体平均值
+var segmentNames = map[[3]bool]string{
+	{true, true, true}:    "重要价值客户",
+	{true, false, true}:   "重要发展客户",
+	{false, true, true}:   "重要保持客户",
+	{false, false, true}:  "重要挽留客户",
+	{true, true, false}:   "一般价值客户",
+	{true, false, false}:  "一般发展客户",
+	{false, true, false}:  "一般保持客户",
+	{false, false, false}: "一般挽留客户",
+}
+
+// ClusterNames 比较各组中心与所有数据的平均分，为每个分组命名。
+// 多个分组落在同一类时，依次加上序号区分
+func ClusterNames(cc clusters.Clusters) []string {
+	var all clusters.Observations
+	for _, c := range cc {
+		all = append(all, c.Observations...)
+	}
+	mean, err := all.Center()
+	if err != nil {
+		return make([]string, len(cc))
+	}
+
+	names := make([]string, len(cc))
+	count := map[string]int{}
+	for i, c := range cc {
+		names[i] = segmentName(c.Center, mean)
+		if names[i] == "" {
+			continue
+		}
+		count[names[i]]++
+	}
+
+	seen := map[string]int{}
+	for i, name := range names {
+		if count[name] > 1 {
+			seen[name]++
+			names[i] = fmt.Sprintf("%s%d", name, seen[name])
+		}
+	}
+
+	return names
+}
+
+// segmentName 按中心的R、F、M得分是否高于平均值返回经典RFM客户类型
+func segmentName(center, mean clusters.Coordinates) string {
+	if len(center) < 3 || len(mean) < 3 {
+		return ""
+	}
+	return segmentNames[[3]bool{center[0] > mean[0], center[1] > mean[1], center[2] > mean[2]}]
+}
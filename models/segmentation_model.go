@@ -0,0 +1,254 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"rfm_cluster/pkg/clusters"
+	"rfm_cluster/pkg/kmeans"
+	"slices"
+	"time"
+)
+
+// SegmentationModelVersion 分群模型文件的格式版本，格式不兼容时递增
+const SegmentationModelVersion = 1
+
+// SegmentationModel 确认后的分群结果，保存打分规则、度量、各组中心和名称，
+// 新用户按相同的规则打分后归入最近的分组，不需要重新聚类
+type SegmentationModel struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	// 生成模型的分析的缓存键、数据哈希和参数，用于追溯
+	AnalysisKey string         `json:"analysis_key"`
+	DatasetHash string         `json:"dataset_hash"`
+	Params      AnalysisParams `json:"params"`
+	Scoring     ScoringModel   `json:"scoring"`
+	// R、F、M得分的权重，坐标为得分乘以权重，当前分析不对维度加权，均为1
+	Weights []float64 `json:"weights"`
+	// 计算距离使用的度量名称
+	Metric string `json:"metric"`
+	// 马氏距离按训练数据拟合的协方差逆矩阵，其他度量为空
+	Inverse  [][]float64    `json:"inverse,omitempty"`
+	Segments []ModelSegment `json:"segments"`
+	// k-means分组时的迭代次数、停止原因和SSE，其他算法为空
+	Convergence *kmeans.Report `json:"convergence,omitempty"`
+}
+
+// ScoringModel 固定下来的打分规则
+type ScoringModel struct {
+	Scheme string `json:"scheme"`
+	// 五分位数打分在训练数据上的分界值，固定阈值打分为空
+	Barriers *ScoringBarriers `json:"barriers,omitempty"`
+}
+
+// ModelSegment 模型中的一个分组
+type ModelSegment struct {
+	// 从1开始的分组编号，与分析结果一致
+	Cluster int                  `json:"cluster"`
+	Name    string               `json:"name"`
+	Center  clusters.Coordinates `json:"center"`
+	// 训练数据中该组的用户数
+	Size int `json:"size"`
+}
+
+// Prediction 一个新用户的预测结果
+type Prediction struct {
+	UserID         uint64  `json:"user_id"`
+	RecencyScore   float64 `json:"recency_score"`
+	FrequencyScore float64 `json:"frequency_score"`
+	MonetaryScore  float64 `json:"monetary_score"`
+	// 从1开始的分组编号
+	Cluster     int    `json:"cluster"`
+	ClusterName string `json:"cluster_name"`
+	// 按模型的度量计算的到分组中心的距离
+	Distance float64 `json:"distance"`
+}
+
+// NewSegmentationModel 用分为k组时的分析结果生成分群模型。
+// 新用户总是归入中心最近的分组，因此密度聚类的噪声和软聚类按概率的归属不会被还原
+func NewSegmentationModel(analysis *Analysis, k int) (*SegmentationModel, error) {
+	cc := analysis.Clusters(k)
+	if len(cc) == 0 || len(analysis.Data) == 0 {
+		return nil, fmt.Errorf("no clusters computed for k=%d", k)
+	}
+
+	model := &SegmentationModel{
+		Version:     SegmentationModelVersion,
+		CreatedAt:   time.Now(),
+		AnalysisKey: analysis.Key,
+		DatasetHash: analysis.DatasetHash,
+		Params:      analysis.Params,
+		Scoring:     ScoringModel{Scheme: analysis.Params.Scoring},
+		Weights:     []float64{1, 1, 1},
+		Metric:      analysis.Params.Metric,
+	}
+	if c := analysis.ConvergenceOf(k); c != nil {
+		report := c.Report
+		model.Convergence = &report
+	}
+	if model.Scoring.Scheme == ScoringQuintile {
+		barriers := fitQuintileBarriers(analysis.Data)
+		model.Scoring.Barriers = &barriers
+	}
+	if m, ok := analysis.Data[0].Metric().(clusters.Mahalanobis); ok {
+		for _, row := range m.Inverse {
+			model.Inverse = append(model.Inverse, slices.Clone(row))
+		}
+	}
+
+	names := ClusterNames(cc)
+	for i, c := range cc {
+		model.Segments = append(model.Segments, ModelSegment{
+			Cluster: i + 1,
+			Name:    names[i],
+			Center:  slices.Clone(c.Center),
+			Size:    len(c.Observations),
+		})
+	}
+	return model, nil
+}
+
+// LoadSegmentationModel 读取Save保存的模型，版本不一致或内容不完整时返回错误
+func LoadSegmentationModel(r io.Reader) (*SegmentationModel, error) {
+	model := &SegmentationModel{}
+	if err := json.NewDecoder(r).Decode(model); err != nil {
+		return nil, fmt.Errorf("failed to decode segmentation model: %w", err)
+	}
+	if model.Version != SegmentationModelVersion {
+		return nil, fmt.Errorf("unsupported segmentation model version %d, expected %d", model.Version, SegmentationModelVersion)
+	}
+	if err := model.Validate(); err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+// Save 以JSON格式保存模型
+func (m *SegmentationModel) Save(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(m)
+}
+
+// Validate 检查模型内容能否用于预测
+func (m *SegmentationModel) Validate() error {
+	if _, err := LookupScoringScheme(m.Scoring.Scheme); err != nil {
+		return err
+	}
+	if m.Scoring.Scheme == ScoringQuintile {
+		b := m.Scoring.Barriers
+		if b == nil || len(b.Recency) != 4 || len(b.Frequency) != 4 || len(b.Monetary) != 4 {
+			return fmt.Errorf("quintile scoring requires 4 barriers for each of R, F and M")
+		}
+	}
+	if len(m.Weights) != 3 {
+		return fmt.Errorf("expected 3 weights, got %d", len(m.Weights))
+	}
+	if _, err := m.metric(); err != nil {
+		return err
+	}
+	if len(m.Segments) == 0 {
+		return fmt.Errorf("the model has no segments")
+	}
+	for _, s := range m.Segments {
+		if len(s.Center) != 3 {
+			return fmt.Errorf("segment %d: expected 3 coordinates, got %d", s.Cluster, len(s.Center))
+		}
+	}
+	return nil
+}
+
+// metric 还原模型的度量
+func (m *SegmentationModel) metric() (clusters.Metric, error) {
+	if m.Metric != clusters.MetricMahalanobis {
+		return clusters.NewMetric(m.Metric, nil)
+	}
+	if len(m.Inverse) != 3 {
+		return nil, fmt.Errorf("mahalanobis metric requires a 3x3 inverse covariance")
+	}
+	for _, row := range m.Inverse {
+		if len(row) != 3 {
+			return nil, fmt.Errorf("mahalanobis metric requires a 3x3 inverse covariance")
+		}
+	}
+	return clusters.Mahalanobis{Inverse: m.Inverse}, nil
+}
+
+// Predict 预测一个用户所属的分组，用户需要填写R、F、M原始值
+func (m *SegmentationModel) Predict(row *UserRFM) (Prediction, error) {
+	predictions, err := m.PredictBatch([]*UserRFM{row})
+	if err != nil {
+		return Prediction{}, err
+	}
+	return predictions[0], nil
+}
+
+// PredictBatch 批量预测用户所属的分组，不修改传入的数据
+func (m *SegmentationModel) PredictBatch(rows []*UserRFM) ([]Prediction, error) {
+	scored, err := m.score(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	centers := make([]clusters.Coordinates, len(m.Segments))
+	for si, s := range m.Segments {
+		centers[si] = s.Center
+	}
+	result := make([]Prediction, len(scored))
+	for i, row := range scored {
+		nearest, distance := 0, -1.0
+		for ci, center := range centers {
+			if d := row.Distance(center); distance < 0 || d < distance {
+				nearest, distance = ci, d
+			}
+		}
+		result[i] = m.prediction(row, nearest, distance)
+	}
+	return result, nil
+}
+
+// score 按模型的打分规则和权重给数据的副本打分，并使用模型的度量计算距离
+func (m *SegmentationModel) score(rows []*UserRFM) ([]*UserRFM, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	metric, err := m.metric()
+	if err != nil {
+		return nil, err
+	}
+
+	scored := make([]*UserRFM, len(rows))
+	for i, row := range rows {
+		copied := *row
+		copied.metric = metric
+		scored[i] = &copied
+	}
+	if m.Scoring.Scheme == ScoringQuintile {
+		scoreByBarriers(scored, *m.Scoring.Barriers)
+	} else {
+		scheme, err := LookupScoringScheme(m.Scoring.Scheme)
+		if err != nil {
+			return nil, err
+		}
+		scheme(scored)
+	}
+	for _, row := range scored {
+		row.RecencyWeighted *= m.Weights[0]
+		row.FrequencyWeighted *= m.Weights[1]
+		row.MonetaryWeighted *= m.Weights[2]
+	}
+	return scored, nil
+}
+
+// prediction 返回打分后的用户归入第si个分组的预测结果
+func (m *SegmentationModel) prediction(row *UserRFM, si int, distance float64) Prediction {
+	return Prediction{
+		UserID:         row.UserID,
+		RecencyScore:   row.RecencyWeighted,
+		FrequencyScore: row.FrequencyWeighted,
+		MonetaryScore:  row.MonetaryWeighted,
+		Cluster:        m.Segments[si].Cluster,
+		ClusterName:    m.Segments[si].Name,
+		Distance:       distance,
+	}
+}
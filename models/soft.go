@@ -0,0 +1,186 @@
+package models
+
+import (
+	"rfm_cluster/pkg/clusters"
+	"rfm_cluster/pkg/fuzzy"
+	"rfm_cluster/pkg/gmm"
+	"slices"
+	"sync"
+)
+
+// 软聚类选择分组数的准则
+const (
+	// 高斯混合模型的贝叶斯信息准则和赤池信息准则，越小越好
+	CriterionBIC = "bic"
+	CriterionAIC = "aic"
+	// 模糊C均值的划分系数（越大越好）和划分熵（越小越好）
+	CriterionPC = "pc"
+	CriterionPE = "pe"
+)
+
+// defaultCriteria 各软聚类算法默认的准则，第一个为默认值
+var defaultCriteria = map[string][]string{
+	AlgorithmGMM:   {CriterionBIC, CriterionAIC},
+	AlgorithmFuzzy: {CriterionPC, CriterionPE},
+}
+
+// Criteria 返回所有可用的准则名称
+func Criteria() []string {
+	return []string{CriterionBIC, CriterionAIC, CriterionPC, CriterionPE}
+}
+
+// SoftPartition 软聚类（高斯混合模型、模糊C均值）分为k组时的结果
+type SoftPartition struct {
+	K int `json:"k"`
+	// 每个用户（与Analysis.Data顺序一致）属于各分组的概率（模糊C均值为隶属度）
+	Probabilities [][]float64 `json:"probabilities"`
+	// 高斯混合模型的拟合结果
+	LogLikelihood float64 `json:"log_likelihood,omitempty"`
+	BIC           float64 `json:"bic,omitempty"`
+	AIC           float64 `json:"aic,omitempty"`
+	// 模糊C均值的划分系数和划分熵
+	PartitionCoefficient float64 `json:"partition_coefficient,omitempty"`
+	PartitionEntropy     float64 `json:"partition_entropy,omitempty"`
+}
+
+// Criterion 返回指定的准则，统一为越小越好，划分系数取相反数
+func (s *SoftPartition) Criterion(name string) float64 {
+	switch name {
+	case CriterionAIC:
+		return s.AIC
+	case CriterionPC:
+		return -s.PartitionCoefficient
+	case CriterionPE:
+		return s.PartitionEntropy
+	}
+	return s.BIC
+}
+
+// softPartitioner 用软聚类算法分组，并记录每个分组数的结果
+type softPartitioner struct {
+	fit        func(data clusters.Observations, k int) (clusters.Clusters, SoftPartition, error)
+	lock       sync.Mutex
+	partitions []SoftPartition
+}
+
+func (p *softPartitioner) Partition(data clusters.Observations, k int) (clusters.Clusters, error) {
+	cc, partition, err := p.fit(data, k)
+	if err != nil {
+		return nil, err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.partitions = append(p.partitions, partition)
+	return cc, nil
+}
+
+// SoftPartitions 返回按分组数排序的结果
+func (p *softPartitioner) SoftPartitions() []SoftPartition {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	result := slices.Clone(p.partitions)
+	slices.SortFunc(result, func(a, b SoftPartition) int { return a.K - b.K })
+	return result
+}
+
+// newMixturePartitioner 用高斯混合模型分组
+func newMixturePartitioner(g gmm.GMM) *softPartitioner {
+	return &softPartitioner{fit: func(data clusters.Observations, k int) (clusters.Clusters, SoftPartition, error) {
+		model, err := g.Fit(data, k)
+		if err != nil {
+			return nil, SoftPartition{}, err
+		}
+		return model.Clusters(data), SoftPartition{
+			K:             k,
+			Probabilities: model.Probabilities,
+			LogLikelihood: model.LogLikelihood,
+			BIC:           model.BIC(),
+			AIC:           model.AIC(),
+		}, nil
+	}}
+}
+
+// newFuzzyPartitioner 用模糊C均值分组
+func newFuzzyPartitioner(f fuzzy.CMeans) *softPartitioner {
+	return &softPartitioner{fit: func(data clusters.Observations, k int) (clusters.Clusters, SoftPartition, error) {
+		result, err := f.Fit(data, k)
+		if err != nil {
+			return nil, SoftPartition{}, err
+		}
+		return result.Clusters(data), SoftPartition{
+			K:                    k,
+			Probabilities:        result.Memberships,
+			PartitionCoefficient: result.PartitionCoefficient(),
+			PartitionEntropy:     result.PartitionEntropy(),
+		}, nil
+	}}
+}
+
+// SoftPartition 返回分为k组时的软聚类结果，不是软聚类时返回nil
+func (a *Analysis) SoftPartition(k int) *SoftPartition {
+	for i := range a.SoftPartitions {
+		if a.SoftPartitions[i].K == k {
+			return &a.SoftPartitions[i]
+		}
+	}
+	return nil
+}
+
+// Memberships 返回分为k组时每个用户属于各分组的概率，不是软聚类时返回nil
+func (a *Analysis) Memberships(k int) map[*UserRFM][]float64 {
+	partition := a.SoftPartition(k)
+	if partition == nil {
+		return nil
+	}
+
+	result := make(map[*UserRFM][]float64, len(a.Data))
+	for i, row := range a.Data {
+		result[row] = partition.Probabilities[i]
+	}
+	return result
+}
+
+// UncertainMember 归属概率最高的分组也不够确定的用户
+type UncertainMember struct {
+	User *UserRFM
+	// 概率最高和第二高的分组，从1开始编号
+	Cluster           int
+	Probability       float64
+	SecondCluster     int
+	SecondProbability float64
+}
+
+// UncertainMembers 返回分为k组时最高归属概率不超过threshold的用户，按最高归属概率升序排列
+func (a *Analysis) UncertainMembers(k int, threshold float64) []UncertainMember {
+	partition := a.SoftPartition(k)
+	if partition == nil {
+		return nil
+	}
+
+	result := []UncertainMember{}
+	for i, p := range partition.Probabilities {
+		member := UncertainMember{User: a.Data[i]}
+		for c, v := range p {
+			if v > member.Probability {
+				member.SecondCluster, member.SecondProbability = member.Cluster, member.Probability
+				member.Cluster, member.Probability = c+1, v
+			} else if v > member.SecondProbability {
+				member.SecondCluster, member.SecondProbability = c+1, v
+			}
+		}
+		if member.Probability <= threshold {
+			result = append(result, member)
+		}
+	}
+
+	slices.SortStableFunc(result, func(a, b UncertainMember) int {
+		if a.Probability < b.Probability {
+			return -1
+		} else if a.Probability > b.Probability {
+			return 1
+		}
+		return 0
+	})
+	return result
+}
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"rfm_cluster/pkg/clusters"
+)
+
+// ClusterSummary 一个分组的规模和RFM原始值统计
+type ClusterSummary struct {
+	// 从1开始的分组编号
+	Cluster         int     `json:"cluster"`
+	Name            string  `json:"name"`
+	Size            int     `json:"size"`
+	SizeShare       float64 `json:"size_share"`
+	RecencyMean     float64 `json:"recency_mean"`
+	RecencyMedian   float64 `json:"recency_median"`
+	FrequencyMean   float64 `json:"frequency_mean"`
+	FrequencyMedian float64 `json:"frequency_median"`
+	MonetaryMean    float64 `json:"monetary_mean"`
+	MonetaryMedian  float64 `json:"monetary_median"`
+	// 组内消费金额合计及其占全部消费金额的比例
+	Revenue      float64 `json:"revenue"`
+	RevenueShare float64 `json:"revenue_share"`
+	// 离分组中心最近的真实用户，k-medoids的分组中心就是该用户
+	Representative *UserRFM `json:"representative"`
+}
+
+// SummarizeClusters 统计每个分组的规模、RFM均值和中位数以及消费金额占比
+func SummarizeClusters(cc clusters.Clusters) []ClusterSummary {
+	names := ClusterNames(cc)
+
+	var total int
+	var totalRevenue float64
+	result := make([]ClusterSummary, len(cc))
+	for i, c := range cc {
+		var r, f, m []float64
+		for _, o := range c.Observations {
+			rfm := o.(*UserRFM)
+			r = append(r, rfm.RecencyOriginal)
+			f = append(f, rfm.FrequencyOriginal)
+			m = append(m, rfm.MonetaryOriginal)
+		}
+
+		summary := ClusterSummary{
+			Cluster:        i + 1,
+			Name:           names[i],
+			Size:           len(c.Observations),
+			Representative: representative(c),
+		}
+		if len(c.Observations) > 0 {
+			summary.RecencyMean, summary.RecencyMedian = Mean(r), Median(r)
+			summary.FrequencyMean, summary.FrequencyMedian = Mean(f), Median(f)
+			summary.MonetaryMean, summary.MonetaryMedian = Mean(m), Median(m)
+		}
+		for _, v := range m {
+			summary.Revenue += v
+		}
+
+		total += summary.Size
+		totalRevenue += summary.Revenue
+		result[i] = summary
+	}
+
+	for i := range result {
+		if total > 0 {
+			result[i].SizeShare = float64(result[i].Size) / float64(total)
+		}
+		if totalRevenue > 0 {
+			result[i].RevenueShare = result[i].Revenue / totalRevenue
+		}
+	}
+
+	return result
+}
+
+// representative 返回分组中离中心最近的用户，分组为空时返回nil
+func representative(c clusters.Cluster) *UserRFM {
+	var result *UserRFM
+	dist := -1.0
+	for _, o := range c.Observations {
+		if d := o.Distance(c.Center); dist < 0 || d < dist {
+			dist = d
+			result = o.(*UserRFM)
+		}
+	}
+	return result
+}
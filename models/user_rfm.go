@@ -1,9 +1,12 @@
 package models
 
 import (
+	"errors"
 	"fmt"
-	"math"
 	"rfm_cluster/pkg/clusters"
+	"rfm_cluster/pkg/constrained"
+	"rfm_cluster/pkg/density"
+	"rfm_cluster/pkg/hierarchical"
 	"rfm_cluster/pkg/kmeans"
 	"rfm_cluster/pkg/silhouette"
 	"slices"
@@ -20,6 +23,8 @@ type UserRFM struct {
 	RecencyWeighted   float64 `json:"recency_weighted"`
 	FrequencyWeighted float64 `json:"frequency_weighted"`
 	MonetaryWeighted  float64 `json:"monetary_weighted"`
+	// 本次分析计算距离使用的度量，为nil时使用平方欧氏距离
+	metric clusters.Metric
 }
 
 type DataIndicators struct {
@@ -56,173 +61,220 @@ func ProcessRMFDataIndicators(dataCollection []*UserRFM) RMFDataIndicators {
 	return result
 }
 
-func ProcessData(dataCollection []*UserRFM) (clusters.Observations, []silhouette.KScore, int, float64, error) {
-	var observations clusters.Observations = processRealRFMData(dataCollection)
+// PartitionResult 按分组数划分的聚类结果
+type PartitionResult struct {
+	// 2到kmax个分组的轮廓系数和分组
+	Scores []silhouette.KScore
+	// 软聚类每个分组数的结果
+	SoftPartitions []SoftPartition
+	// k-means每个分组数的收敛情况
+	Convergence []Convergence
+	// 估计的分组数及其轮廓系数
+	Estimate int
+	Score    float64
+	// 层次聚类截断为kmax个叶子的分组树
+	Hierarchy *Hierarchy
+}
+
+// ProcessData 按评分预设给数据打分，并计算2到kmax个分组的轮廓系数。
+// 软聚类同时返回每个分组数的结果，并按参数指定的准则估计分组数；
+// 层次聚类同时返回切分出各分组的同一棵树
+func ProcessData(dataCollection []*UserRFM, params AnalysisParams) (*PartitionResult, error) {
+	if len(dataCollection) < params.KMax {
+		return nil, fmt.Errorf("the data set has %d rows, at least k_max (%d) are required", len(dataCollection), params.KMax)
+	}
 
-	// 构建kmeans
-	km, err := kmeans.NewWithOptions(0.01, nil)
+	observations, err := processRealRFMData(dataCollection, params)
 	if err != nil {
-		return nil, nil, 0, 0, err
+		return nil, err
 	}
 
-	// 计算kmeans的得分和分组
-	scores, estimate, score, err := silhouette.EstimateK(observations, 8, km)
+	// 构建聚类算法
+	partitioner, err := NewPartitioner(params)
 	if err != nil {
-		return nil, nil, 0, 0, err
+		return nil, err
 	}
 
-	return observations, scores, estimate, score, nil
-}
+	// 计算各分组数的得分和分组，支持权重的算法合并相同坐标的用户后再分组，结果展开回每个用户
+	collapsed := collapseObservations(observations, params)
+	scores, estimate, score, err := silhouette.EstimateK(collapsed, params.KMax, partitioner)
+	var infeasible *constrained.InfeasibleError
+	if errors.As(err, &infeasible) {
+		return nil, infeasibleSizeError(infeasible, params)
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := &PartitionResult{Scores: scores, Estimate: estimate, Score: score}
+	if kp, ok := partitioner.(*kmeansPartitioner); ok {
+		result.Convergence = kp.Convergence()
+	}
 
-func processRealRFMData(dataCollection []*UserRFM) clusters.Observations {
-	var d clusters.Observations
+	if h, ok := partitioner.(hierarchical.Hierarchical); ok {
+		// 各分组数的分组都切分自缓存的这棵树，不重新构建
+		tree, err := h.CachedTree(collapsed)
+		if err != nil {
+			return nil, err
+		}
+		result.Hierarchy, err = NewHierarchy(tree, params.KMax)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	// barrier := len(dataCollection) / 5
-
-	// rsorted := make([]*UserRFM, len(dataCollection))
-	// fsorted := make([]*UserRFM, len(dataCollection))
-	// msorted := make([]*UserRFM, len(dataCollection))
-
-	// copy(rsorted, dataCollection)
-	// copy(fsorted, dataCollection)
-	// copy(msorted, dataCollection)
-	// slices.SortFunc(rsorted, func(a, b *UserRFM) int {
-	// 	if a.RecencyOriginal < b.RecencyOriginal {
-	// 		return -1
-	// 	} else if a.RecencyOriginal > b.RecencyOriginal {
-	// 		return 1
-	// 	} else {
-	// 		return 0
-	// 	}
-	// })
-
-	// slices.SortFunc(fsorted, func(a, b *UserRFM) int {
-	// 	if a.FrequencyOriginal < b.FrequencyOriginal {
-	// 		return -1
-	// 	} else if a.FrequencyOriginal > b.FrequencyOriginal {
-	// 		return 1
-	// 	} else {
-	// 		return 0
-	// 	}
-	// })
-
-	// slices.SortFunc(msorted, func(a, b *UserRFM) int {
-	// 	if a.MonetaryOriginal < b.MonetaryOriginal {
-	// 		return -1
-	// 	} else if a.MonetaryOriginal > b.MonetaryOriginal {
-	// 		return 1
-	// 	} else {
-	// 		return 0
-	// 	}
-	// })
-
-	// rbarrier := make([]float64, 4)
-	// fbarrier := make([]float64, 4)
-	// mbarrier := make([]float64, 4)
-	// for i := 1; i < 5; i++ {
-	// 	rbarrier[i-1] = rsorted[i*barrier].RecencyOriginal
-	// 	fbarrier[i-1] = fsorted[i*barrier].FrequencyOriginal
-	// 	mbarrier[i-1] = msorted[i*barrier].MonetaryOriginal
-	// }
-
-	// fmt.Println(rbarrier)
-	// fmt.Println(fbarrier)
-	// fmt.Println(mbarrier)
-
-	// for _, row := range dataCollection {
-	// 	var r, f, m float64 = 0.0, 0.0, 0.0
-	// 	if row.RecencyOriginal <= rbarrier[0] {
-	// 		r = 5
-	// 	} else if row.RecencyOriginal > rbarrier[0] && row.RecencyOriginal <= rbarrier[1] {
-	// 		r = 4
-	// 	} else if row.RecencyOriginal > rbarrier[1] && row.RecencyOriginal <= rbarrier[2] {
-	// 		r = 3
-	// 	} else if row.RecencyOriginal > rbarrier[2] && row.RecencyOriginal <= rbarrier[3] {
-	// 		r = 2
-	// 	} else if row.RecencyOriginal > rbarrier[3] {
-	// 		r = 1
-	// 	} else {
-	// 		r = 1
-	// 	}
-
-	// 	if row.FrequencyOriginal <= fbarrier[0] {
-	// 		f = 1
-	// 	} else if row.FrequencyOriginal > fbarrier[0] && row.FrequencyOriginal <= fbarrier[1] {
-	// 		f = 2
-	// 	} else if row.FrequencyOriginal > fbarrier[1] && row.FrequencyOriginal <= fbarrier[2] {
-	// 		f = 3
-	// 	} else if row.FrequencyOriginal > fbarrier[2] && row.FrequencyOriginal <= fbarrier[3] {
-	// 		f = 4
-	// 	} else if row.FrequencyOriginal > fbarrier[3] {
-	// 		f = 5
-	// 	} else {
-	// 		f = 5
-	// 	}
-
-	// 	if row.MonetaryOriginal <= mbarrier[0] {
-	// 		m = 1
-	// 	} else if row.MonetaryOriginal > mbarrier[0] && row.MonetaryOriginal <= mbarrier[1] {
-	// 		m = 2
-	// 	} else if row.MonetaryOriginal > mbarrier[1] && row.MonetaryOriginal <= mbarrier[2] {
-	// 		m = 3
-	// 	} else if row.MonetaryOriginal > mbarrier[2] && row.MonetaryOriginal <= mbarrier[3] {
-	// 		m = 4
-	// 	} else if row.MonetaryOriginal > mbarrier[3] {
-	// 		m = 5
-	// 	} else {
-	// 		m = 5
-	// 	}
+	if len(collapsed) != len(observations) {
+		for i := range scores {
+			scores[i].Clusters = clusters.Expand(scores[i].Clusters)
+		}
+	}
 
-	for _, row := range dataCollection {
-		var r, f, m float64 = 0.0, 0.0, 0.0
-		if row.RecencyOriginal == -1 {
-			r = 0
-		} else if row.RecencyOriginal <= 1 {
-			r = 5
-		} else if row.RecencyOriginal > 1 && row.RecencyOriginal <= 7 {
-			r = 4
-		} else if row.RecencyOriginal > 7 && row.RecencyOriginal <= 31 {
-			r = 3
-		} else if row.RecencyOriginal > 31 && row.RecencyOriginal <= 93 {
-			r = 2
-		} else if row.RecencyOriginal > 93 {
-			r = 1
+	sp, ok := partitioner.(*softPartitioner)
+	if !ok {
+		return result, nil
+	}
+
+	// 准则最优的分组数
+	result.SoftPartitions = sp.SoftPartitions()
+	best := result.SoftPartitions[0]
+	for _, p := range result.SoftPartitions[1:] {
+		if p.Criterion(params.Criterion) < best.Criterion(params.Criterion) {
+			best = p
 		}
+	}
+	result.Estimate, result.Score = best.K, scores[best.K-MinK].Score
+	return result, nil
+}
+
+// infeasibleSizeError 分组大小的限制无法满足2到k_max中的某个分组数时，给出可行的分组数范围
+func infeasibleSizeError(e *constrained.InfeasibleError, params AnalysisParams) error {
+	name := "max_size"
+	if lo, hi := constrained.FeasibleK(e.Observations, e.MinSize, e.MaxSize); e.K > hi && lo <= e.K {
+		name = "min_size"
+	}
+	return &ParamError{
+		Name:   name,
+		Reason: fmt.Sprintf("every k between %d and k_max (%d) must be feasible: %s", MinK, params.KMax, e.Error()),
+	}
+}
+
+// 对比小批量k-means与完整k-means时的最大样本数
+const inertiaSampleSize = 10000
 
-		if row.FrequencyOriginal == 1 {
-			f = 1
-		} else if row.FrequencyOriginal >= 2 && row.FrequencyOriginal <= 4 {
-			f = 2
-		} else if row.FrequencyOriginal >= 5 && row.FrequencyOriginal <= 7 {
-			f = 3
-		} else if row.FrequencyOriginal >= 8 && row.FrequencyOriginal <= 10 {
-			f = 4
-		} else if row.FrequencyOriginal >= 11 {
-			f = 5
+// CompareInertia 在同一随机样本上分别用小批量k-means和完整k-means分为2到kmax组，
+// 对比两者的SSE，用于评估小批量带来的精度损失
+func CompareInertia(observations clusters.Observations, params AnalysisParams) ([]kmeans.Comparison, error) {
+	mb, err := kmeans.NewMiniBatch(params.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	mb = mb.WithSeed(params.Seed)
+	full := kmeans.New().WithSeed(params.Seed).WithAlgorithm(kmeans.Hamerly).WithWorkers(kmeansWorkers)
+
+	result := make([]kmeans.Comparison, 0, params.KMax-MinK+1)
+	for k := MinK; k <= params.KMax; k++ {
+		comparison, err := mb.Compare(full, observations, k, inertiaSampleSize)
+		if err != nil {
+			return nil, err
 		}
+		result = append(result, comparison)
+	}
+	return result, nil
+}
 
-		if row.MonetaryOriginal <= 6.99 {
-			m = 1
-		} else if row.MonetaryOriginal > 6.99 && row.MonetaryOriginal <= 14.99 {
-			m = 2
-		} else if row.MonetaryOriginal > 14.99 && row.MonetaryOriginal <= 39.99 {
-			m = 3
-		} else if row.MonetaryOriginal > 39.99 && row.MonetaryOriginal <= 71.88 {
-			m = 4
-		} else if row.MonetaryOriginal > 71.88 {
-			m = 5
+// DensityResult 密度聚类的结果
+type DensityResult struct {
+	Clusters clusters.Clusters
+	// 不属于任何分组的数据
+	Noise clusters.Observations
+	// 实际使用的eps，参数未指定时取k距离曲线的拐点
+	Eps float64
+	// DBSCAN降序的k距离曲线，HDBSCAN不使用eps，不计算
+	KDistances []float64
+}
+
+// ProcessDensityData 按评分预设给数据打分，并用密度聚类算法分组。
+// k距离曲线的计算量为O(n²)，只为DBSCAN在这里计算一次
+func ProcessDensityData(dataCollection []*UserRFM, params AnalysisParams) (*DensityResult, error) {
+	observations, err := processRealRFMData(dataCollection, params)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &DensityResult{Eps: params.Eps}
+	if params.Algorithm == AlgorithmDBSCAN {
+		result.KDistances = KDistances(observations, params)
+		if result.Eps == 0 {
+			result.Eps = density.SuggestEps(result.KDistances)
+			if result.Eps == 0 {
+				return nil, fmt.Errorf("failed to choose eps automatically, all k-distances are 0")
+			}
 		}
+	}
+
+	clusterer, err := NewClusterer(params, result.Eps)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Clusters, result.Noise, err = clusterer.Cluster(observations)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Clusters) == 0 {
+		return nil, fmt.Errorf("no clusters found, all %d rows are noise", len(result.Noise))
+	}
+
+	return result, nil
+}
+
+// collapseObservations 支持带权重数据的算法把打分后坐标相同的用户合并为一个数据，
+// 不同坐标不足kmax个或算法不支持权重时原样返回
+func collapseObservations(observations clusters.Observations, params AnalysisParams) clusters.Observations {
+	if !slices.Contains(weightedAlgorithms, params.Algorithm) {
+		return observations
+	}
+	collapsed := clusters.Collapse(observations)
+	if len(collapsed) < params.KMax {
+		return observations
+	}
+	return collapsed
+}
 
-		row.RecencyWeighted = r
-		row.FrequencyWeighted = f
-		row.MonetaryWeighted = m
+func processRealRFMData(dataCollection []*UserRFM, params AnalysisParams) (clusters.Observations, error) {
+	scheme, err := LookupScoringScheme(params.Scoring)
+	if err != nil {
+		return nil, err
+	}
+	scheme(dataCollection)
 
+	var d clusters.Observations
+	for _, row := range dataCollection {
 		d = append(d, row)
 	}
+	if err := SetMetric(dataCollection, params.Metric); err != nil {
+		return nil, err
+	}
 
 	fmt.Printf("%d data points\n", len(d))
 
-	return d
+	return d, nil
+}
+
+// SetMetric 让所有数据按名为name的度量计算距离，马氏距离按打分后的数据拟合协方差，
+// 因此需要在打分之后调用
+func SetMetric(dataCollection []*UserRFM, name string) error {
+	observations := make(clusters.Observations, len(dataCollection))
+	for i, row := range dataCollection {
+		observations[i] = row
+	}
+	metric, err := clusters.NewMetric(name, observations)
+	if err != nil {
+		return err
+	}
+	for _, row := range dataCollection {
+		row.metric = metric
+	}
+	return nil
 }
 
 // clusters.Observation 协议实现
@@ -231,9 +283,13 @@ func (c *UserRFM) Coordinates() clusters.Coordinates {
 }
 
 func (c *UserRFM) Distance(p2 clusters.Coordinates) float64 {
-	var r float64
-	for i, v := range c.Coordinates() {
-		r += math.Pow(v-p2.Coordinates()[i], 2)
+	return c.Metric().Distance(c.Coordinates(), p2)
+}
+
+// Metric 实现clusters.Measured，返回本次分析使用的度量
+func (c *UserRFM) Metric() clusters.Metric {
+	if c.metric == nil {
+		return clusters.SquaredEuclidean{}
 	}
-	return r
+	return c.metric
 }
@@ -0,0 +1,146 @@
+// Package artifact stores the files generated by an analysis run. Every run
+// gets its own namespace, so concurrent runs never overwrite each other's
+// output.
+package artifact
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when a run or an artifact does not exist
+var ErrNotFound = errors.New("artifact not found")
+
+// Info describes a stored artifact
+type Info struct {
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mod_time"`
+	ContentType string    `json:"content_type"`
+}
+
+// Store is the interface storage backends implement
+type Store interface {
+	// Create returns a writer for the named artifact of a run. The artifact
+	// becomes visible once the writer has been closed successfully.
+	Create(runID, name string) (io.WriteCloser, error)
+	// Open returns a reader for the named artifact of a run
+	Open(runID, name string) (io.ReadCloser, Info, error)
+	// List returns all artifacts of a run
+	List(runID string) ([]Info, error)
+	// Remove removes the named artifact of a run
+	Remove(runID, name string) error
+	// Delete removes a run along with all its artifacts
+	Delete(runID string) error
+	// Runs returns all runs, most recently modified first
+	Runs() ([]RunInfo, error)
+}
+
+// RunInfo describes a stored run
+type RunInfo struct {
+	ID string `json:"id"`
+	// ModTime is the time the last artifact of the run was written
+	ModTime time.Time `json:"mod_time"`
+}
+
+// InvalidError is returned for a run ID or an artifact name that can not
+// safely be used as a path element
+type InvalidError struct {
+	Kind  string
+	Value string
+}
+
+func (e *InvalidError) Error() string {
+	return fmt.Sprintf("invalid %s %q", e.Kind, e.Value)
+}
+
+var (
+	validRunID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	validName  = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+)
+
+// NewRunID returns a new, time ordered run ID
+func NewRunID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
+
+// Validate checks that runID and name can safely be used as path elements
+func Validate(runID, name string) error {
+	if !validRunID.MatchString(runID) {
+		return &InvalidError{Kind: "run id", Value: runID}
+	}
+	if name != "" && !validName.MatchString(name) {
+		return &InvalidError{Kind: "artifact name", Value: name}
+	}
+	return nil
+}
+
+// sortRuns orders runs from the most to the least recently modified
+func sortRuns(runs []RunInfo) {
+	sort.Slice(runs, func(i, j int) bool {
+		if !runs[i].ModTime.Equal(runs[j].ModTime) {
+			return runs[i].ModTime.After(runs[j].ModTime)
+		}
+		return runs[i].ID > runs[j].ID
+	})
+}
+
+// ContentType returns the MIME type for an artifact name
+func ContentType(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".xlsx":
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case ".csv":
+		return "text/csv; charset=utf-8"
+	case ".json":
+		return "application/json"
+	case ".ndjson":
+		return "application/x-ndjson"
+	case ".parquet":
+		return "application/vnd.apache.parquet"
+	case ".png":
+		return "image/png"
+	}
+
+	if t := mime.TypeByExtension(filepath.Ext(name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// Factory creates a store backend from a backend specific location
+type Factory func(location string) (Store, error)
+
+var backends = map[string]Factory{
+	"local": func(location string) (Store, error) {
+		return NewLocal(location)
+	},
+	"memory": func(string) (Store, error) {
+		return NewMemory(), nil
+	},
+}
+
+// Register makes a store backend available under name
+func Register(name string, factory Factory) {
+	backends[name] = factory
+}
+
+// Open returns a store of the backend registered under name
+func Open(name, location string) (Store, error) {
+	factory, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown artifact store backend %q", name)
+	}
+	return factory(location)
+}
@@ -0,0 +1,170 @@
+package artifact
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Local stores the artifacts of every run in its own sub directory
+type Local struct {
+	dir string
+}
+
+// NewLocal returns a store rooted at dir, creating the directory if needed
+func NewLocal(dir string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &Local{dir: dir}, nil
+}
+
+// Create implements the Store interface. Data is written to a temporary
+// file which is renamed into place on Close.
+func (s *Local) Create(runID, name string) (io.WriteCloser, error) {
+	if err := Validate(runID, name); err != nil {
+		return nil, err
+	}
+
+	runDir := filepath.Join(s.dir, runID)
+	if err := os.MkdirAll(runDir, 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.CreateTemp(runDir, "."+name+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &localWriter{File: f, path: filepath.Join(runDir, name)}, nil
+}
+
+type localWriter struct {
+	*os.File
+	path string
+}
+
+func (w *localWriter) Close() error {
+	if err := w.File.Close(); err != nil {
+		os.Remove(w.Name())
+		return err
+	}
+	return os.Rename(w.Name(), w.path)
+}
+
+// Open implements the Store interface
+func (s *Local) Open(runID, name string) (io.ReadCloser, Info, error) {
+	if err := Validate(runID, name); err != nil {
+		return nil, Info{}, err
+	}
+
+	f, err := os.Open(filepath.Join(s.dir, runID, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, Info{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, Info{}, err
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, Info{}, err
+	}
+	return f, localInfo(stat), nil
+}
+
+// List implements the Store interface
+func (s *Local) List(runID string) ([]Info, error) {
+	if err := Validate(runID, ""); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(filepath.Join(s.dir, runID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	infos := []Info{}
+	for _, e := range entries {
+		if e.IsDir() || !validName.MatchString(e.Name()) {
+			continue
+		}
+		stat, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, localInfo(stat))
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+// Remove implements the Store interface
+func (s *Local) Remove(runID, name string) error {
+	if err := Validate(runID, name); err != nil {
+		return err
+	}
+
+	err := os.Remove(filepath.Join(s.dir, runID, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Delete implements the Store interface
+func (s *Local) Delete(runID string) error {
+	if err := Validate(runID, ""); err != nil {
+		return err
+	}
+
+	runDir := filepath.Join(s.dir, runID)
+	if _, err := os.Stat(runDir); errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	return os.RemoveAll(runDir)
+}
+
+// Runs implements the Store interface. A run was modified when an artifact
+// was last renamed into its directory.
+func (s *Local) Runs() ([]RunInfo, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	runs := []RunInfo{}
+	for _, e := range entries {
+		if !e.IsDir() || !validRunID.MatchString(e.Name()) {
+			continue
+		}
+		stat, err := e.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			// deleted meanwhile
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, RunInfo{ID: e.Name(), ModTime: stat.ModTime()})
+	}
+
+	sortRuns(runs)
+	return runs, nil
+}
+
+func localInfo(stat os.FileInfo) Info {
+	return Info{
+		Name:        stat.Name(),
+		Size:        stat.Size(),
+		ModTime:     stat.ModTime(),
+		ContentType: ContentType(stat.Name()),
+	}
+}
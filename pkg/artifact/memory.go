@@ -0,0 +1,154 @@
+package artifact
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory keeps all artifacts in memory, which is useful when the generated
+// files only need to live as long as the process
+type Memory struct {
+	lock sync.RWMutex
+	runs map[string]map[string]memoryFile
+}
+
+type memoryFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemory returns an empty in-memory store
+func NewMemory() *Memory {
+	return &Memory{runs: map[string]map[string]memoryFile{}}
+}
+
+// Create implements the Store interface
+func (s *Memory) Create(runID, name string) (io.WriteCloser, error) {
+	if err := Validate(runID, name); err != nil {
+		return nil, err
+	}
+
+	return &memoryWriter{store: s, runID: runID, name: name}, nil
+}
+
+type memoryWriter struct {
+	bytes.Buffer
+	store *Memory
+	runID string
+	name  string
+}
+
+func (w *memoryWriter) Close() error {
+	w.store.lock.Lock()
+	defer w.store.lock.Unlock()
+
+	files, ok := w.store.runs[w.runID]
+	if !ok {
+		files = map[string]memoryFile{}
+		w.store.runs[w.runID] = files
+	}
+	files[w.name] = memoryFile{data: w.Bytes(), modTime: time.Now()}
+	return nil
+}
+
+// Open implements the Store interface
+func (s *Memory) Open(runID, name string) (io.ReadCloser, Info, error) {
+	if err := Validate(runID, name); err != nil {
+		return nil, Info{}, err
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	f, ok := s.runs[runID][name]
+	if !ok {
+		return nil, Info{}, ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(f.data)), f.info(name), nil
+}
+
+// List implements the Store interface
+func (s *Memory) List(runID string) ([]Info, error) {
+	if err := Validate(runID, ""); err != nil {
+		return nil, err
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	files, ok := s.runs[runID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	infos := make([]Info, 0, len(files))
+	for name, f := range files {
+		infos = append(infos, f.info(name))
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+// Remove implements the Store interface
+func (s *Memory) Remove(runID, name string) error {
+	if err := Validate(runID, name); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.runs[runID][name]; !ok {
+		return ErrNotFound
+	}
+	delete(s.runs[runID], name)
+	return nil
+}
+
+// Delete implements the Store interface
+func (s *Memory) Delete(runID string) error {
+	if err := Validate(runID, ""); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.runs[runID]; !ok {
+		return ErrNotFound
+	}
+	delete(s.runs, runID)
+	return nil
+}
+
+// Runs implements the Store interface
+func (s *Memory) Runs() ([]RunInfo, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	runs := make([]RunInfo, 0, len(s.runs))
+	for runID, files := range s.runs {
+		run := RunInfo{ID: runID}
+		for _, f := range files {
+			if f.modTime.After(run.ModTime) {
+				run.ModTime = f.modTime
+			}
+		}
+		runs = append(runs, run)
+	}
+
+	sortRuns(runs)
+	return runs, nil
+}
+
+func (f memoryFile) info(name string) Info {
+	return Info{
+		Name:        name,
+		Size:        int64(len(f.data)),
+		ModTime:     f.modTime,
+		ContentType: ContentType(name),
+	}
+}
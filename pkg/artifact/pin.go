@@ -0,0 +1,54 @@
+package artifact
+
+import (
+	"errors"
+	"time"
+)
+
+// PinName is the artifact marking a run as pinned. Retention never prunes
+// pinned runs, so a run used as the baseline of later comparisons outlives
+// the runs created on every page load.
+const PinName = "pinned"
+
+// Pin marks an existing run as pinned
+func Pin(store Store, runID string) error {
+	if _, err := store.List(runID); err != nil {
+		return err
+	}
+
+	w, err := store.Create(runID, PinName)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(time.Now().Format(time.RFC3339) + "\n")); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Unpin removes the pin of a run, so that retention prunes it like any other
+// run. Unpinning a run that is not pinned is not an error.
+func Unpin(store Store, runID string) error {
+	if _, err := store.List(runID); err != nil {
+		return err
+	}
+
+	if err := store.Remove(runID, PinName); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// Pinned reports whether a run is pinned
+func Pinned(store Store, runID string) (bool, error) {
+	reader, _, err := store.Open(runID, PinName)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	reader.Close()
+	return true, nil
+}
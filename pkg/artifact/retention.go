@@ -0,0 +1,83 @@
+package artifact
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// Retention limits the runs a store keeps. A zero value keeps every run.
+type Retention struct {
+	// MaxRuns is the number of most recently modified runs kept, 0 keeps
+	// any number of runs
+	MaxRuns int
+	// MaxAge removes runs not modified for longer, 0 keeps runs of any age
+	MaxAge time.Duration
+}
+
+// Prune deletes the runs of store the retention does not keep, except for
+// the run keep and pinned runs, which do not count towards MaxRuns either.
+// Runs deleted meanwhile by someone else are skipped.
+func (r Retention) Prune(store Store, keep string) error {
+	if r.MaxRuns <= 0 && r.MaxAge <= 0 {
+		return nil
+	}
+
+	runs, err := store.Runs()
+	if err != nil {
+		return err
+	}
+
+	kept := 0
+	if keep != "" {
+		// room for the run being written
+		kept = 1
+	}
+	now := time.Now()
+	for _, run := range runs {
+		if run.ID == keep {
+			continue
+		}
+		pinned, err := Pinned(store, run.ID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		if pinned {
+			continue
+		}
+		if (r.MaxRuns <= 0 || kept < r.MaxRuns) && (r.MaxAge <= 0 || now.Sub(run.ModTime) <= r.MaxAge) {
+			kept++
+			continue
+		}
+		if err := store.Delete(run.ID); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Retained is a store that prunes old runs according to its retention
+// whenever the first artifact of a new run is created
+type Retained struct {
+	Store
+	retention Retention
+}
+
+// WithRetention returns store wrapped to keep only the runs retention keeps
+func WithRetention(store Store, retention Retention) *Retained {
+	return &Retained{Store: store, retention: retention}
+}
+
+// Create implements the Store interface
+func (s *Retained) Create(runID, name string) (io.WriteCloser, error) {
+	if err := Validate(runID, name); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.Store.List(runID); errors.Is(err, ErrNotFound) {
+		if err := s.retention.Prune(s.Store, runID); err != nil {
+			return nil, err
+		}
+	}
+	return s.Store.Create(runID, name)
+}
@@ -0,0 +1,112 @@
+package artifact
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRetainedKeepsMostRecentRuns(t *testing.T) {
+	store := WithRetention(NewMemory(), Retention{MaxRuns: 3})
+	for i := 0; i < 5; i++ {
+		w, err := store.Create(fmt.Sprintf("run-%d", i), "report.xlsx")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	runs, err := store.Runs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, run := range runs {
+		ids = append(ids, run.ID)
+	}
+	if fmt.Sprint(ids) != "[run-4 run-3 run-2]" {
+		t.Fatalf("got runs %v, expected the 3 most recent", ids)
+	}
+}
+
+func TestRetentionMaxAge(t *testing.T) {
+	store := NewMemory()
+	for _, runID := range []string{"old", "new"} {
+		w, err := store.Create(runID, "model.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.runs["old"]["model.json"] = memoryFile{modTime: time.Now().Add(-2 * time.Hour)}
+
+	if err := (Retention{MaxAge: time.Hour}).Prune(store, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.List("old"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("the old run was kept: %v", err)
+	}
+	if _, err := store.List("new"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateInvalidRunID(t *testing.T) {
+	var invalid *InvalidError
+	if _, err := NewMemory().List("../etc"); !errors.As(err, &invalid) {
+		t.Fatalf("got %v, expected an InvalidError", err)
+	}
+}
+
+func TestPruneSkipsPinnedRuns(t *testing.T) {
+	store := WithRetention(NewMemory(), Retention{MaxRuns: 2})
+	for i := 0; i < 5; i++ {
+		w, err := store.Create(fmt.Sprintf("run-%d", i), "report.xlsx")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			if err := Pin(store, "run-0"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	runs, err := store.Runs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, run := range runs {
+		ids = append(ids, run.ID)
+	}
+	if fmt.Sprint(ids) != "[run-4 run-3 run-0]" {
+		t.Fatalf("got runs %v, expected the pinned run and the 2 most recent", ids)
+	}
+
+	if err := Unpin(store, "run-0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := (Retention{MaxRuns: 2}).Prune(store, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.List("run-0"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("the unpinned run was kept: %v", err)
+	}
+}
+
+func TestPinMissingRun(t *testing.T) {
+	if err := Pin(NewMemory(), "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+}
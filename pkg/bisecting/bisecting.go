@@ -0,0 +1,248 @@
+// Package bisecting implements bisecting k-means. All observations start in a
+// single cluster and the cluster with the highest sum of squared errors is
+// split in two by 2-means until k clusters are left. The splits form a tree,
+// so the clusters for k+1 are the clusters for k with one of them split.
+// See: Steinbach, Karypis and Kumar, "A Comparison of Document Clustering
+// Techniques" (2000)
+package bisecting
+
+import (
+	"fmt"
+	"rfm_cluster/pkg/clusters"
+	"rfm_cluster/pkg/kmeans"
+	"slices"
+	"sync"
+)
+
+// Bisecting configuration/option struct
+type Bisecting struct {
+	// trials is the number of 2-means runs per split, the one with the lowest
+	// sum of squared errors wins
+	trials int
+	// seed makes the 2-means runs reproducible, 0 seeds from the current time
+	seed int64
+	// cache keeps the last tree, so that partitioning the same data set at
+	// several values of k splits it only once
+	cache *treeCache
+}
+
+type treeCache struct {
+	sync.Mutex
+	dataset clusters.Observations
+	tree    *Tree
+}
+
+// NewWithOptions returns a Bisecting configuration struct with custom
+// settings
+func NewWithOptions(trials int) (Bisecting, error) {
+	if trials < 1 {
+		return Bisecting{}, fmt.Errorf("trials must be at least 1")
+	}
+
+	return Bisecting{trials: trials, cache: &treeCache{}}, nil
+}
+
+// New returns a Bisecting configuration struct with default settings
+func New() Bisecting {
+	m, _ := NewWithOptions(3)
+	return m
+}
+
+// WithSeed returns a copy of the configuration whose 2-means runs are
+// derived from seed. A seed of 0 restores the time based default.
+func (m Bisecting) WithSeed(seed int64) Bisecting {
+	m.seed = seed
+	m.cache = &treeCache{}
+	return m
+}
+
+// Node is a cluster of the split tree
+type Node struct {
+	ID           int
+	Center       clusters.Coordinates
+	Observations clusters.Observations
+	// SSE is the sum of squared errors of the observations to the center
+	SSE float64
+}
+
+// Split divides the node Parent into the nodes Left and Right
+type Split struct {
+	Parent int
+	Left   int
+	Right  int
+}
+
+// Tree is the split tree of a data set. Node 0 holds all observations,
+// Splits are in the order they were made.
+type Tree struct {
+	Nodes  []Node
+	Splits []Split
+	// final marks the nodes 2-means could not split
+	final map[int]bool
+}
+
+// Partition splits the given dataset until there are k clusters, centered on
+// their means
+func (m Bisecting) Partition(dataset clusters.Observations, k int) (clusters.Clusters, error) {
+	if m.cache == nil {
+		tree, err := m.Tree(dataset, k)
+		if err != nil {
+			return clusters.Clusters{}, err
+		}
+		return tree.Cut(k)
+	}
+
+	// the cached tree grows while other goroutines partition at other values
+	// of k, so it is cut while the lock is held
+	m.cache.Lock()
+	defer m.cache.Unlock()
+	if m.cache.tree == nil || len(m.cache.dataset) != len(dataset) || &m.cache.dataset[0] != &dataset[0] {
+		tree, err := newTree(dataset)
+		if err != nil {
+			return clusters.Clusters{}, err
+		}
+		m.cache.dataset, m.cache.tree = dataset, tree
+	}
+	// the splits are greedy, growing the cached tree yields the same tree as
+	// splitting from scratch
+	if err := m.grow(m.cache.tree, k); err != nil {
+		return clusters.Clusters{}, err
+	}
+	return m.cache.tree.Cut(k)
+}
+
+// Tree splits the given dataset until there are k clusters and returns the
+// split tree
+func (m Bisecting) Tree(dataset clusters.Observations, k int) (*Tree, error) {
+	tree, err := newTree(dataset)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.grow(tree, k); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+func newTree(dataset clusters.Observations) (*Tree, error) {
+	if len(dataset) == 0 {
+		return nil, fmt.Errorf("the data set must not be empty")
+	}
+	return &Tree{Nodes: []Node{newNode(0, dataset)}, final: map[int]bool{}}, nil
+}
+
+func newNode(id int, observations clusters.Observations) Node {
+	center, _ := observations.Center()
+	node := Node{ID: id, Center: center, Observations: observations}
+	for _, o := range observations {
+		node.SSE += clusters.WeightOf(o) * clusters.SquaredEuclidean{}.Distance(o.Coordinates(), center)
+	}
+	return node
+}
+
+// grow splits the leaf with the highest sum of squared errors until the tree
+// has k leaves
+func (m Bisecting) grow(t *Tree, k int) error {
+	if k < 1 {
+		return fmt.Errorf("k must be greater than 0")
+	}
+	if k > len(t.Nodes[0].Observations) {
+		return fmt.Errorf("the size of the data set must at least equal k")
+	}
+
+	for len(t.Splits)+1 < k {
+		// leaves holding identical observations can not be split
+		parent := -1
+		for _, id := range t.Leaves(len(t.Splits) + 1) {
+			if t.Nodes[id].SSE > 0 && !t.final[id] && (parent < 0 || t.Nodes[id].SSE > t.Nodes[parent].SSE) {
+				parent = id
+			}
+		}
+		if parent < 0 {
+			return fmt.Errorf("the data set can not be split into more than %d clusters", len(t.Splits)+1)
+		}
+
+		left, right, err := m.bisect(t.Nodes[parent].Observations, len(t.Splits))
+		if err != nil {
+			return err
+		}
+		if len(left) == 0 || len(right) == 0 {
+			// 2-means found no split, the leaf can not be split any further
+			t.final[parent] = true
+			continue
+		}
+
+		split := Split{Parent: parent, Left: len(t.Nodes), Right: len(t.Nodes) + 1}
+		t.Nodes = append(t.Nodes, newNode(split.Left, left), newNode(split.Right, right))
+		t.Splits = append(t.Splits, split)
+	}
+	return nil
+}
+
+// bisect splits the observations in two with the best of several 2-means
+// runs. Every observation ends up on the side of its nearest center.
+func (m Bisecting) bisect(observations clusters.Observations, step int) (clusters.Observations, clusters.Observations, error) {
+	var left, right clusters.Observations
+	best := -1.0
+	for trial := 0; trial < m.trials; trial++ {
+		seed := m.seed
+		if seed != 0 {
+			seed += int64(step*m.trials + trial)
+		}
+		cc, err := kmeans.New().WithSeed(seed).WithAlgorithm(kmeans.Hamerly).Partition(observations, 2)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// k-means may hand a random observation to an emptied cluster without
+		// removing it from its old one, so assign every observation anew
+		var l, r clusters.Observations
+		sse := 0.0
+		for _, o := range observations {
+			ci := cc.Nearest(o)
+			if ci == 0 {
+				l = append(l, o)
+			} else {
+				r = append(r, o)
+			}
+			sse += clusters.WeightOf(o) * clusters.SquaredEuclidean{}.Distance(o.Coordinates(), cc[ci].Center)
+		}
+		if len(l) > 0 && len(r) > 0 && (best < 0 || sse < best) {
+			left, right, best = l, r, sse
+		}
+	}
+	return left, right, nil
+}
+
+// Leaves returns the ids of the k leaves left after the first k-1 splits.
+// The left half of a split takes the place of its parent and the right half
+// is appended, so the clusters for k+1 keep the order of the clusters for k.
+func (t *Tree) Leaves(k int) []int {
+	leaves := []int{0}
+	for _, split := range t.Splits[:min(k-1, len(t.Splits))] {
+		for i, id := range leaves {
+			if id == split.Parent {
+				leaves[i] = split.Left
+				break
+			}
+		}
+		leaves = append(leaves, split.Right)
+	}
+	return leaves
+}
+
+// Cut returns the k clusters left after the first k-1 splits
+func (t *Tree) Cut(k int) (clusters.Clusters, error) {
+	if k < 1 || k > len(t.Splits)+1 {
+		return clusters.Clusters{}, fmt.Errorf("the tree has %d leaves, it can not be cut into %d clusters", len(t.Splits)+1, k)
+	}
+
+	leaves := t.Leaves(k)
+	cc := make(clusters.Clusters, len(leaves))
+	for i, id := range leaves {
+		// copies, the nodes are shared by the clusters of every k
+		cc[i].Center = slices.Clone(t.Nodes[id].Center)
+		cc[i].Observations = slices.Clone(t.Nodes[id].Observations)
+	}
+	return cc, nil
+}
@@ -0,0 +1,65 @@
+package bisecting
+
+import (
+	"math/rand"
+	"rfm_cluster/pkg/clusters"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testDataset(n int, seed int64) clusters.Observations {
+	r := rand.New(rand.NewSource(seed))
+	dataset := make(clusters.Observations, n)
+	for i := range dataset {
+		dataset[i] = clusters.Coordinates{r.Float64() * 5, r.Float64() * 5, r.Float64() * 5}
+	}
+	return dataset
+}
+
+// TestPartitionConcurrent partitions the same data set at several values of k
+// at the same time, as silhouette.Scores does. Run with -race.
+func TestPartitionConcurrent(t *testing.T) {
+	dataset := testDataset(2000, 1)
+
+	expected := map[int]clusters.Clusters{}
+	for k := 2; k <= 10; k++ {
+		cc, err := New().WithSeed(42).Partition(dataset, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected[k] = cc
+	}
+
+	// a fresh cache every round, so that small values of k cut the tree while
+	// larger ones still grow it
+	results := make([]clusters.Clusters, 11)
+	errs := make([]error, 11)
+	for round := 0; round < 5; round++ {
+		m := New().WithSeed(42)
+		var wg sync.WaitGroup
+		for k := 2; k <= 10; k++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[k], errs[k] = m.Partition(dataset, k)
+			}()
+			time.Sleep(time.Millisecond)
+		}
+		wg.Wait()
+	}
+
+	for k := 2; k <= 10; k++ {
+		if errs[k] != nil {
+			t.Fatalf("k=%d: %v", k, errs[k])
+		}
+		if len(results[k]) != k {
+			t.Fatalf("k=%d: got %d clusters", k, len(results[k]))
+		}
+		for ci := range results[k] {
+			if len(results[k][ci].Observations) != len(expected[k][ci].Observations) {
+				t.Fatalf("k=%d cluster %d: got %d observations, expected %d", k, ci, len(results[k][ci].Observations), len(expected[k][ci].Observations))
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// validKey restricts keys to names that are safe to use as file names
+var validKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Disk persists cache entries as one file per key inside a directory
+type Disk struct {
+	dir string
+	ext string
+}
+
+// NewDisk returns a store writing files with extension ext into dir, creating
+// the directory if needed
+func NewDisk(dir string, ext string) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &Disk{dir: dir, ext: ext}, nil
+}
+
+func (d *Disk) path(key string) (string, error) {
+	if !validKey.MatchString(key) {
+		return "", fmt.Errorf("invalid cache key %q", key)
+	}
+	return filepath.Join(d.dir, key+d.ext), nil
+}
+
+// Get returns the stored bytes for key, ok is false if there are none
+func (d *Disk) Get(key string) ([]byte, bool, error) {
+	path, err := d.path(key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// Put stores data for key. The file is written next to its final location
+// and renamed, so readers never see partially written entries.
+func (d *Disk) Put(key string, data []byte) error {
+	path, err := d.path(key)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(d.dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Remove deletes the entry for key and reports whether it existed
+func (d *Disk) Remove(key string) (bool, error) {
+	path, err := d.path(key)
+	if err != nil {
+		return false, err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Keys returns the keys of all stored entries
+func (d *Disk) Keys() ([]string, error) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, d.ext) {
+			continue
+		}
+		key := strings.TrimSuffix(name, d.ext)
+		if validKey.MatchString(key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// Purge deletes all stored entries
+func (d *Disk) Purge() error {
+	keys, err := d.Keys()
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if _, err := d.Remove(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
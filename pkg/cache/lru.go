@@ -0,0 +1,112 @@
+// Package cache implements a size bounded in-memory LRU cache and a simple
+// directory backed store that can be used to persist cache entries
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// LRU is a thread safe cache holding at most capacity entries. When full,
+// adding a new entry evicts the least recently used one.
+type LRU[K comparable, V any] struct {
+	capacity int
+	lock     sync.Mutex
+	order    *list.List
+	items    map[K]*list.Element
+}
+
+type entry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+// NewLRU returns an empty cache holding at most capacity entries
+func NewLRU[K comparable, V any](capacity int) *LRU[K, V] {
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	return &LRU[K, V]{
+		capacity: capacity,
+		order:    list.New(),
+		items:    map[K]*list.Element{},
+	}
+}
+
+// Get returns the value stored for key and marks it as recently used
+func (c *LRU[K, V]) Get(key K) (V, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.order.MoveToFront(el)
+		return el.Value.(*entry[K, V]).value, true
+	}
+
+	var zero V
+	return zero, false
+}
+
+// Add stores value for key, evicting the least recently used entry if the
+// cache is full
+func (c *LRU[K, V]) Add(key K, value V) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		el.Value.(*entry[K, V]).value = value
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&entry[K, V]{key: key, value: value})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*entry[K, V]).key)
+	}
+}
+
+// Remove deletes key from the cache and reports whether it was present
+func (c *LRU[K, V]) Remove(key K) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return false
+	}
+	c.order.Remove(el)
+	delete(c.items, key)
+	return true
+}
+
+// Purge removes all entries
+func (c *LRU[K, V]) Purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.order.Init()
+	c.items = map[K]*list.Element{}
+}
+
+// Keys returns all keys, most recently used first
+func (c *LRU[K, V]) Keys() []K {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	keys := make([]K, 0, c.order.Len())
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		keys = append(keys, el.Value.(*entry[K, V]).key)
+	}
+	return keys
+}
+
+// Len returns the number of cached entries
+func (c *LRU[K, V]) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.order.Len()
+}
@@ -2,6 +2,7 @@ package clusters
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
 	"time"
 )
@@ -16,13 +17,12 @@ type Cluster struct {
 type Clusters []Cluster
 
 // New sets up a new set of clusters and randomly seeds their initial positions
+// within [0,1) in every dimension, whatever the range of the data set. Use
+// NewInBounds for positions within the data set.
 func New(k int, dataset Observations) (Clusters, error) {
 	var c Clusters
-	if len(dataset) == 0 || len(dataset[0].Coordinates()) == 0 {
-		return c, fmt.Errorf("there must be at least one dimension in the data set")
-	}
-	if k == 0 {
-		return c, fmt.Errorf("k must be greater than 0")
+	if err := checkNew(k, dataset); err != nil {
+		return c, err
 	}
 
 	source := rand.NewSource(time.Now().UnixNano())
@@ -41,12 +41,53 @@ func New(k int, dataset Observations) (Clusters, error) {
 	return c, nil
 }
 
+// NewInBounds sets up a new set of clusters whose initial positions are drawn
+// from r uniformly within the bounding box of the data set
+func NewInBounds(k int, dataset Observations, r *rand.Rand) (Clusters, error) {
+	var c Clusters
+	if err := checkNew(k, dataset); err != nil {
+		return c, err
+	}
+
+	low := append(Coordinates(nil), dataset[0].Coordinates()...)
+	high := append(Coordinates(nil), low...)
+	for _, o := range dataset {
+		for j, v := range o.Coordinates() {
+			low[j] = math.Min(low[j], v)
+			high[j] = math.Max(high[j], v)
+		}
+	}
+
+	for i := 0; i < k; i++ {
+		var p Coordinates
+		for j := range low {
+			p = append(p, low[j]+r.Float64()*(high[j]-low[j]))
+		}
+
+		c = append(c, Cluster{
+			Center: p,
+		})
+	}
+	return c, nil
+}
+
+func checkNew(k int, dataset Observations) error {
+	if len(dataset) == 0 || len(dataset[0].Coordinates()) == 0 {
+		return fmt.Errorf("there must be at least one dimension in the data set")
+	}
+	if k == 0 {
+		return fmt.Errorf("k must be greater than 0")
+	}
+	return nil
+}
+
 // Append adds an observation to the Cluster
 func (c *Cluster) Append(point Observation) {
 	c.Observations = append(c.Observations, point)
 }
 
-// Nearest returns the index of the cluster nearest to point
+// Nearest returns the index of the cluster nearest to point, measured with
+// the metric of point
 func (c Clusters) Nearest(point Observation) int {
 	var ci int
 	dist := -1.0
@@ -0,0 +1,38 @@
+package clusters
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestNewKeepsUnitRange(t *testing.T) {
+	// the records range from 1 to 5, New still draws from [0,1)
+	cc, err := New(10, records(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cc {
+		for _, v := range c.Center {
+			if v < 0 || v >= 1 {
+				t.Fatalf("center %v is not within [0,1)", c.Center)
+			}
+		}
+	}
+}
+
+func TestNewInBounds(t *testing.T) {
+	dataset := Observations{Coordinates{1, -10}, Coordinates{3, 20}, Coordinates{2, 0}}
+	cc, err := NewInBounds(50, dataset, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cc {
+		if c.Center[0] < 1 || c.Center[0] > 3 || c.Center[1] < -10 || c.Center[1] > 20 {
+			t.Fatalf("center %v is outside the bounding box", c.Center)
+		}
+	}
+
+	if _, err := NewInBounds(0, dataset, rand.New(rand.NewSource(1))); err == nil {
+		t.Fatal("k=0 must be rejected")
+	}
+}
@@ -0,0 +1,132 @@
+package clusters
+
+// Matrix stores observations densely in a flat row-major slice, row i holds
+// the coordinates of observation i. Reading a row does not allocate, which
+// makes it the representation of choice for the inner loops of the
+// partitioners and the silhouette.
+type Matrix struct {
+	Data   []float64
+	Rows   int
+	Stride int
+	// Weights holds the weight of every row, nil when all weights are 1
+	Weights []float64
+	// Metric measures the distances between rows and points
+	Metric Metric
+}
+
+// NewMatrix returns a zeroed matrix of rows observations in cols dimensions,
+// measuring distances with metric
+func NewMatrix(rows, cols int, metric Metric) *Matrix {
+	return &Matrix{
+		Data:   make([]float64, rows*cols),
+		Rows:   rows,
+		Stride: cols,
+		Metric: metric,
+	}
+}
+
+// MatrixOf copies the coordinates of the observations into a matrix. The
+// metric is taken from the first observation, weights from all of them.
+func MatrixOf(dataset Observations) *Matrix {
+	if len(dataset) == 0 {
+		return NewMatrix(0, 0, SquaredEuclidean{})
+	}
+
+	m := NewMatrix(len(dataset), len(dataset[0].Coordinates()), MetricOf(dataset[0]))
+	for i, o := range dataset {
+		copy(m.Row(i), o.Coordinates())
+		if w := WeightOf(o); w != 1 {
+			if m.Weights == nil {
+				m.Weights = make([]float64, len(dataset))
+				for j := range m.Weights {
+					m.Weights[j] = 1
+				}
+			}
+			m.Weights[i] = w
+		}
+	}
+	return m
+}
+
+// Row returns the coordinates of row i. The slice shares the storage of the
+// matrix.
+func (m *Matrix) Row(i int) Coordinates {
+	return m.Data[i*m.Stride : (i+1)*m.Stride : (i+1)*m.Stride]
+}
+
+// Weight returns the weight of row i
+func (m *Matrix) Weight(i int) float64 {
+	if m.Weights == nil {
+		return 1
+	}
+	return m.Weights[i]
+}
+
+// TotalWeight returns the total weight of all rows
+func (m *Matrix) TotalWeight() float64 {
+	if m.Weights == nil {
+		return float64(m.Rows)
+	}
+	var w float64
+	for _, v := range m.Weights {
+		w += v
+	}
+	return w
+}
+
+// Distance returns the distance between row i and point
+func (m *Matrix) Distance(i int, point Coordinates) float64 {
+	return m.Metric.Distance(m.Row(i), point)
+}
+
+// Nearest returns the index of the center nearest to row i, ties resolve to
+// the first center like Clusters.Nearest
+func (m *Matrix) Nearest(i int, centers []Coordinates) int {
+	var ci int
+	dist := -1.0
+	row := m.Row(i)
+	for j, center := range centers {
+		d := m.Metric.Distance(row, center)
+		if dist < 0 || d < dist {
+			dist = d
+			ci = j
+		}
+	}
+	return ci
+}
+
+// Observations returns an observation for every row. The observations read
+// from the matrix, they do not copy it.
+func (m *Matrix) Observations() Observations {
+	result := make(Observations, m.Rows)
+	for i := range result {
+		result[i] = MatrixRow{Matrix: m, Index: i}
+	}
+	return result
+}
+
+// MatrixRow adapts a row of a matrix to the Observation interface
+type MatrixRow struct {
+	Matrix *Matrix
+	Index  int
+}
+
+// Coordinates implements the Observation interface
+func (r MatrixRow) Coordinates() Coordinates {
+	return r.Matrix.Row(r.Index)
+}
+
+// Distance implements the Observation interface
+func (r MatrixRow) Distance(point Coordinates) float64 {
+	return r.Matrix.Distance(r.Index, point)
+}
+
+// Metric implements the Measured interface
+func (r MatrixRow) Metric() Metric {
+	return r.Matrix.Metric
+}
+
+// Weight implements the Weighted interface
+func (r MatrixRow) Weight() float64 {
+	return r.Matrix.Weight(r.Index)
+}
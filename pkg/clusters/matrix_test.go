@@ -0,0 +1,76 @@
+package clusters
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// record is an observation that builds its coordinates on every call, like
+// the RFM records of the models package
+type record struct {
+	r, f, m float64
+}
+
+func (o *record) Coordinates() Coordinates {
+	return Coordinates{o.r, o.f, o.m}
+}
+
+func (o *record) Distance(point Coordinates) float64 {
+	return SquaredEuclidean{}.Distance(o.Coordinates(), point)
+}
+
+func records(n int) Observations {
+	r := rand.New(rand.NewSource(1))
+	dataset := make(Observations, n)
+	for i := range dataset {
+		dataset[i] = &record{1 + 4*r.Float64(), 1 + 4*r.Float64(), 1 + 4*r.Float64()}
+	}
+	return dataset
+}
+
+func TestMatrixOf(t *testing.T) {
+	dataset := records(100)
+	x := MatrixOf(dataset)
+	if x.Weights != nil {
+		t.Fatal("unweighted observations must not allocate weights")
+	}
+	for i, o := range dataset {
+		for j, p := range dataset {
+			if got, expected := x.Distance(i, x.Row(j)), o.Distance(p.Coordinates()); got != expected {
+				t.Fatalf("distance of rows %d and %d: got %v, expected %v", i, j, got, expected)
+			}
+		}
+	}
+}
+
+// the pairwise distances of 1000 observations, the inner loop of the
+// silhouette, k-medoids and the density based algorithms
+
+func BenchmarkObservationDistances(b *testing.B) {
+	dataset := records(1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		var sum float64
+		for _, o := range dataset {
+			for _, p := range dataset {
+				sum += o.Distance(p.Coordinates())
+			}
+		}
+	}
+}
+
+func BenchmarkMatrixDistances(b *testing.B) {
+	dataset := records(1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		x := MatrixOf(dataset)
+		var sum float64
+		for i := 0; i < x.Rows; i++ {
+			for j := 0; j < x.Rows; j++ {
+				sum += x.Distance(i, x.Row(j))
+			}
+		}
+	}
+}
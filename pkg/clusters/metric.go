@@ -0,0 +1,223 @@
+package clusters
+
+import (
+	"fmt"
+	"math"
+)
+
+// Metric measures the distance between two points
+type Metric interface {
+	Distance(a, b Coordinates) float64
+}
+
+// Names of the supported metrics
+const (
+	MetricEuclidean        = "euclidean"
+	MetricSquaredEuclidean = "sqeuclidean"
+	MetricManhattan        = "manhattan"
+	MetricChebyshev        = "chebyshev"
+	MetricCosine           = "cosine"
+	MetricMahalanobis      = "mahalanobis"
+)
+
+// Metrics returns the names of all supported metrics
+func Metrics() []string {
+	return []string{MetricEuclidean, MetricSquaredEuclidean, MetricManhattan, MetricChebyshev, MetricCosine, MetricMahalanobis}
+}
+
+// NewMetric returns the metric with the given name. Mahalanobis is fitted to
+// the covariance of dataset, the other metrics ignore it.
+func NewMetric(name string, dataset Observations) (Metric, error) {
+	switch name {
+	case MetricEuclidean:
+		return Euclidean{}, nil
+	case MetricSquaredEuclidean:
+		return SquaredEuclidean{}, nil
+	case MetricManhattan:
+		return Manhattan{}, nil
+	case MetricChebyshev:
+		return Chebyshev{}, nil
+	case MetricCosine:
+		return Cosine{}, nil
+	case MetricMahalanobis:
+		return NewMahalanobis(dataset)
+	}
+	return nil, fmt.Errorf("unknown metric %q", name)
+}
+
+// Measured is implemented by observations which measure their distances with
+// a configurable metric
+type Measured interface {
+	Metric() Metric
+}
+
+// MetricOf returns the metric o measures its distances with, which is also
+// the metric to measure the distance between two centers. Observations that
+// do not implement Measured use the squared euclidean distance.
+func MetricOf(o Observation) Metric {
+	if m, ok := o.(Measured); ok && m.Metric() != nil {
+		return m.Metric()
+	}
+	return SquaredEuclidean{}
+}
+
+// SquaredEuclidean is the sum of the squared differences, the distance
+// k-means minimizes. It is the default of Coordinates.
+type SquaredEuclidean struct{}
+
+func (SquaredEuclidean) Distance(a, b Coordinates) float64 {
+	var r float64
+	for i, v := range a {
+		r += (v - b[i]) * (v - b[i])
+	}
+	return r
+}
+
+// Euclidean is the straight line distance
+type Euclidean struct{}
+
+func (Euclidean) Distance(a, b Coordinates) float64 {
+	return math.Sqrt(SquaredEuclidean{}.Distance(a, b))
+}
+
+// Manhattan is the sum of the absolute differences
+type Manhattan struct{}
+
+func (Manhattan) Distance(a, b Coordinates) float64 {
+	var r float64
+	for i, v := range a {
+		r += math.Abs(v - b[i])
+	}
+	return r
+}
+
+// Chebyshev is the largest absolute difference in any dimension
+type Chebyshev struct{}
+
+func (Chebyshev) Distance(a, b Coordinates) float64 {
+	var r float64
+	for i, v := range a {
+		r = math.Max(r, math.Abs(v-b[i]))
+	}
+	return r
+}
+
+// Cosine is one minus the cosine of the angle between two points, it only
+// compares their directions. The zero vector is at distance 1 of every other
+// point.
+type Cosine struct{}
+
+func (Cosine) Distance(a, b Coordinates) float64 {
+	var dot, na, nb float64
+	for i, v := range a {
+		dot += v * b[i]
+		na += v * v
+		nb += b[i] * b[i]
+	}
+	if na == 0 && nb == 0 {
+		return 0
+	}
+	if na == 0 || nb == 0 {
+		return 1
+	}
+	// rounding may push the cosine slightly beyond 1
+	return math.Max(1-dot/math.Sqrt(na*nb), 0)
+}
+
+// Mahalanobis is the euclidean distance after decorrelating and scaling the
+// dimensions with the inverse covariance of a data set
+type Mahalanobis struct {
+	// Inverse is the inverse of the covariance matrix
+	Inverse [][]float64
+}
+
+// NewMahalanobis fits the metric to the covariance of dataset. A small ridge
+// keeps constant dimensions from making the covariance singular.
+func NewMahalanobis(dataset Observations) (Mahalanobis, error) {
+	mean, err := dataset.Center()
+	if err != nil {
+		return Mahalanobis{}, err
+	}
+
+	d := len(mean)
+	cov := make([][]float64, d)
+	for i := range cov {
+		cov[i] = make([]float64, d)
+	}
+	for _, o := range dataset {
+		x := o.Coordinates()
+		for i := 0; i < d; i++ {
+			for j := 0; j < d; j++ {
+				cov[i][j] += (x[i] - mean[i]) * (x[j] - mean[j])
+			}
+		}
+	}
+	for i := range cov {
+		for j := range cov[i] {
+			cov[i][j] /= float64(len(dataset))
+		}
+		cov[i][i] += 1e-6
+	}
+
+	inverse, err := invert(cov)
+	if err != nil {
+		return Mahalanobis{}, err
+	}
+	return Mahalanobis{Inverse: inverse}, nil
+}
+
+func (m Mahalanobis) Distance(a, b Coordinates) float64 {
+	var r float64
+	for i := range a {
+		var row float64
+		for j := range b {
+			row += m.Inverse[i][j] * (a[j] - b[j])
+		}
+		r += (a[i] - b[i]) * row
+	}
+	return math.Sqrt(math.Max(r, 0))
+}
+
+// invert inverts a matrix by Gauss-Jordan elimination with partial pivoting
+func invert(a [][]float64) ([][]float64, error) {
+	n := len(a)
+	m := make([][]float64, n)
+	for i := range a {
+		m[i] = make([]float64, 2*n)
+		copy(m[i], a[i])
+		m[i][n+i] = 1
+	}
+
+	for c := 0; c < n; c++ {
+		pivot := c
+		for r := c + 1; r < n; r++ {
+			if math.Abs(m[r][c]) > math.Abs(m[pivot][c]) {
+				pivot = r
+			}
+		}
+		if m[pivot][c] == 0 {
+			return nil, fmt.Errorf("the covariance matrix is singular")
+		}
+		m[c], m[pivot] = m[pivot], m[c]
+
+		p := m[c][c]
+		for j := range m[c] {
+			m[c][j] /= p
+		}
+		for r := 0; r < n; r++ {
+			if r == c || m[r][c] == 0 {
+				continue
+			}
+			f := m[r][c]
+			for j := range m[r] {
+				m[r][j] -= f * m[c][j]
+			}
+		}
+	}
+
+	inverse := make([][]float64, n)
+	for i := range m {
+		inverse[i] = m[i][n:]
+	}
+	return inverse, nil
+}
@@ -2,13 +2,14 @@ package clusters
 
 import (
 	"fmt"
-	"math"
 )
 
 // Coordinates is a slice of float64
 type Coordinates []float64
 
-// Observation is a data point (float64 between 0.0 and 1.0) in n dimensions
+// Observation is a data point (float64 between 0.0 and 1.0) in n dimensions.
+// Distance measures the distance to a point with the metric of the
+// observation, see Measured.
 type Observation interface {
 	Coordinates() Coordinates
 	Distance(point Coordinates) float64
@@ -23,40 +24,41 @@ func (c Coordinates) Coordinates() Coordinates {
 	return Coordinates(c)
 }
 
-// Distance returns the euclidean distance between two coordinates
+// Distance returns the squared euclidean distance between two coordinates
 func (c Coordinates) Distance(p2 Coordinates) float64 {
-	var r float64
-	for i, v := range c {
-		r += math.Pow(v-p2[i], 2)
-	}
-	return r
+	return SquaredEuclidean{}.Distance(c, p2)
 }
 
-// Center returns the center coordinates of a set of Observations
+// Center returns the center coordinates of a set of Observations, weighted
+// by their weights
 func (c Observations) Center() (Coordinates, error) {
-	var l = len(c)
-	if l == 0 {
+	if len(c) == 0 {
 		return nil, fmt.Errorf("there is no mean for an empty set of points")
 	}
 
+	var l float64
 	cc := make([]float64, len(c[0].Coordinates()))
 	for _, point := range c {
+		w := WeightOf(point)
 		for j, v := range point.Coordinates() {
-			cc[j] += v
+			cc[j] += w * v
 		}
+		l += w
 	}
 
 	var mean Coordinates
 	for _, v := range cc {
-		mean = append(mean, v/float64(l))
+		mean = append(mean, v/l)
 	}
 	return mean, nil
 }
 
-// AverageDistance returns the average distance between o and all observations
+// AverageDistance returns the average distance between o and all observations,
+// weighted by their weights. Observations at the same coordinates as o,
+// including o itself, are left out.
 func AverageDistance(o Observation, observations Observations) float64 {
 	var d float64
-	var l int
+	var l float64
 
 	for _, observation := range observations {
 		dist := o.Distance(observation.Coordinates())
@@ -64,12 +66,13 @@ func AverageDistance(o Observation, observations Observations) float64 {
 			continue
 		}
 
-		l++
-		d += dist
+		w := WeightOf(observation)
+		l += w
+		d += w * dist
 	}
 
 	if l == 0 {
 		return 0
 	}
-	return d / float64(l)
+	return d / l
 }
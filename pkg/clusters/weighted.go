@@ -0,0 +1,104 @@
+package clusters
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Weighted is implemented by observations which stand for several
+// observations at the same coordinates. Centers, average distances and the
+// algorithms that support weights count such an observation Weight times.
+type Weighted interface {
+	Weight() float64
+}
+
+// WeightOf returns the weight of o, 1 for observations that do not implement
+// Weighted
+func WeightOf(o Observation) float64 {
+	if w, ok := o.(Weighted); ok {
+		return w.Weight()
+	}
+	return 1
+}
+
+// Group is an observation standing for observations with identical
+// coordinates. It measures its distances like its first member.
+type Group struct {
+	Members Observations
+}
+
+// Coordinates implements the Observation interface
+func (g *Group) Coordinates() Coordinates {
+	return g.Members[0].Coordinates()
+}
+
+// Distance implements the Observation interface
+func (g *Group) Distance(point Coordinates) float64 {
+	return g.Members[0].Distance(point)
+}
+
+// Metric implements the Measured interface
+func (g *Group) Metric() Metric {
+	return MetricOf(g.Members[0])
+}
+
+// Weight implements the Weighted interface, the total weight of the members
+func (g *Group) Weight() float64 {
+	var w float64
+	for _, o := range g.Members {
+		w += WeightOf(o)
+	}
+	return w
+}
+
+// Collapse groups the observations with identical coordinates. The groups
+// are ordered by their first member, so collapsing the same data set twice
+// yields the same groups.
+func Collapse(dataset Observations) Observations {
+	index := map[string]*Group{}
+	var result Observations
+	var key strings.Builder
+	for _, o := range dataset {
+		key.Reset()
+		for _, v := range o.Coordinates() {
+			key.WriteString(strconv.FormatUint(math.Float64bits(v), 16))
+			key.WriteByte(',')
+		}
+
+		g, ok := index[key.String()]
+		if !ok {
+			g = &Group{}
+			index[key.String()] = g
+			result = append(result, g)
+		}
+		g.Members = append(g.Members, o)
+	}
+	return result
+}
+
+// Expand replaces every group in the clusters by its members. Centers are
+// kept, other observations are left as they are.
+func Expand(cc Clusters) Clusters {
+	result := make(Clusters, len(cc))
+	for i, c := range cc {
+		result[i].Center = c.Center
+		for _, o := range c.Observations {
+			if g, ok := o.(*Group); ok {
+				result[i].Observations = append(result[i].Observations, g.Members...)
+			} else {
+				result[i].Observations = append(result[i].Observations, o)
+			}
+		}
+	}
+	return result
+}
+
+// Weight returns the total weight of the observations
+func (c Observations) Weight() float64 {
+	var w float64
+	for _, o := range c {
+		w += WeightOf(o)
+	}
+	return w
+}
@@ -0,0 +1,339 @@
+// Package constrained implements k-means with bounds on the cluster sizes.
+// Every iteration assigns the observations to the centers by a min-cost flow,
+// which minimizes the total distance subject to every cluster holding between
+// a minimum and a maximum number of observations.
+// See: Bradley, Bennett and Demiriz, "Constrained K-Means Clustering" (2000)
+package constrained
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"rfm_cluster/pkg/clusters"
+	"rfm_cluster/pkg/kmeans"
+)
+
+// Constrained configuration/option struct
+type Constrained struct {
+	// minSize is the smallest number of observations of a cluster
+	minSize int
+	// maxSize is the largest number of observations of a cluster, 0 for no
+	// limit
+	maxSize int
+	// iterationThreshold aborts processing when the specified amount of
+	// iterations was reached
+	iterationThreshold int
+	// seed makes the initial centers reproducible, 0 seeds from the current
+	// time
+	seed int64
+}
+
+// NewWithOptions returns a Constrained configuration struct with custom
+// settings
+func NewWithOptions(minSize, maxSize, iterationThreshold int) (Constrained, error) {
+	if minSize < 0 {
+		return Constrained{}, fmt.Errorf("min size must not be negative")
+	}
+	if maxSize < 0 || (maxSize > 0 && maxSize < max(minSize, 1)) {
+		return Constrained{}, fmt.Errorf("max size must be 0 (no limit) or at least the min size and 1")
+	}
+	if iterationThreshold < 1 {
+		return Constrained{}, fmt.Errorf("iteration threshold must be at least 1")
+	}
+
+	return Constrained{
+		minSize:            minSize,
+		maxSize:            maxSize,
+		iterationThreshold: iterationThreshold,
+	}, nil
+}
+
+// New returns a Constrained configuration struct with the given size bounds
+// and default settings
+func New(minSize, maxSize int) (Constrained, error) {
+	return NewWithOptions(minSize, maxSize, 50)
+}
+
+// WithSeed returns a copy of the configuration whose initial centers are
+// derived from seed. A seed of 0 restores the time based default.
+func (m Constrained) WithSeed(seed int64) Constrained {
+	m.seed = seed
+	return m
+}
+
+// InfeasibleError reports that the observations cannot be partitioned into
+// K clusters within the size bounds
+type InfeasibleError struct {
+	Observations int
+	K            int
+	MinSize      int
+	MaxSize      int
+}
+
+func (e *InfeasibleError) Error() string {
+	lo, hi := FeasibleK(e.Observations, e.MinSize, e.MaxSize)
+	reason := "no k is feasible"
+	if lo <= hi {
+		reason = fmt.Sprintf("k must be between %d and %d", lo, hi)
+	}
+	return fmt.Sprintf("%d observations cannot be partitioned into %d clusters of %d to %s observations, %s",
+		e.Observations, e.K, e.MinSize, sizeLimit(e.MaxSize), reason)
+}
+
+func sizeLimit(maxSize int) string {
+	if maxSize == 0 {
+		return "any number of"
+	}
+	return fmt.Sprint(maxSize)
+}
+
+// FeasibleK returns the smallest and the largest number of clusters n
+// observations can be partitioned into within the size bounds. lo is greater
+// than hi when there is none.
+func FeasibleK(n, minSize, maxSize int) (lo, hi int) {
+	lo = 1
+	if maxSize > 0 {
+		lo = (n + maxSize - 1) / maxSize
+	}
+	hi = n
+	if minSize > 0 {
+		hi = n / minSize
+	}
+	return max(lo, 1), hi
+}
+
+// Partition executes the size constrained k-means algorithm on the given
+// dataset and partitions it into k clusters. Sizes count observations,
+// weights are ignored. An *InfeasibleError is returned when the bounds
+// cannot be met.
+func (m Constrained) Partition(dataset clusters.Observations, k int) (clusters.Clusters, error) {
+	if k < 1 {
+		return clusters.Clusters{}, fmt.Errorf("k must be greater than 0")
+	}
+	if k > len(dataset) {
+		return clusters.Clusters{}, fmt.Errorf("the size of the data set must at least equal k")
+	}
+	if lo, hi := FeasibleK(len(dataset), m.minSize, m.maxSize); k < lo || k > hi {
+		return clusters.Clusters{}, &InfeasibleError{Observations: len(dataset), K: k, MinSize: m.minSize, MaxSize: m.maxSize}
+	}
+
+	// the unconstrained solution is the best starting point
+	cc, err := kmeans.New().WithSeed(m.seed).Partition(dataset, k)
+	if err != nil {
+		return clusters.Clusters{}, err
+	}
+	centers := make([]clusters.Coordinates, k)
+	for ci := range cc {
+		centers[ci] = cc[ci].Center
+	}
+
+	x := clusters.MatrixOf(dataset)
+	var assigned []int
+	for i := 0; i < m.iterationThreshold; i++ {
+		next, err := m.assign(x, centers)
+		if err != nil {
+			return clusters.Clusters{}, err
+		}
+		if assigned != nil && equal(assigned, next) {
+			break
+		}
+		assigned = next
+
+		for ci := range centers {
+			var members clusters.Observations
+			for p, a := range assigned {
+				if a == ci {
+					members = append(members, clusters.Coordinates(x.Row(p)))
+				}
+			}
+			if center, err := members.Center(); err == nil {
+				centers[ci] = center
+			}
+		}
+	}
+
+	result := make(clusters.Clusters, k)
+	for ci := range result {
+		result[ci].Center = centers[ci]
+	}
+	for p, ci := range assigned {
+		result[ci].Append(dataset[p])
+	}
+	return result, nil
+}
+
+func equal(a, b []int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// assign returns the cluster of every row minimizing the total distance to
+// the centers within the size bounds, which Partition checked to be
+// feasible.
+//
+// The flow network sends one unit from the source through every row to one
+// of the clusters. Cluster j passes minSize units to the sink directly and up
+// to maxSize-minSize units through a pool of n-k*minSize units, so a maximum
+// flow fills every cluster to at least minSize. The flow is built by
+// successive shortest paths, one row at a time. A path starts at the new
+// row, may move rows from cluster to cluster and ends at a cluster with room
+// left, so the search only needs the clusters as nodes. An error is returned
+// if a row finds no such path, which feasible bounds rule out.
+func (m Constrained) assign(x *clusters.Matrix, centers []clusters.Coordinates) ([]int, error) {
+	n, k := x.Rows, len(centers)
+	maxSize := m.maxSize
+	if maxSize == 0 {
+		maxSize = n
+	}
+	pool := n - k*m.minSize
+
+	dist := make([][]float64, n)
+	for p := range dist {
+		dist[p] = make([]float64, k)
+		for j := range centers {
+			dist[p][j] = x.Distance(p, centers[j])
+		}
+	}
+
+	assigned := make([]int, n)
+	for p := range assigned {
+		assigned[p] = -1
+	}
+	sizes := make([]int, k)
+	// moves[j][l] holds the rows of cluster j by the cost of moving them to
+	// cluster l, rows which left j are skipped lazily
+	moves := make([][]moveHeap, k)
+	for j := range moves {
+		moves[j] = make([]moveHeap, k)
+	}
+	join := func(p, j int) {
+		if assigned[p] >= 0 {
+			sizes[assigned[p]]--
+		}
+		assigned[p] = j
+		sizes[j]++
+		for l := range moves[j] {
+			if l != j {
+				heap.Push(&moves[j][l], move{cost: dist[p][l] - dist[p][j], row: p})
+			}
+		}
+	}
+	// cheapest returns the row of cluster j cheapest to move to cluster l
+	cheapest := func(j, l int) (move, bool) {
+		h := &moves[j][l]
+		for h.Len() > 0 {
+			if top := (*h)[0]; assigned[top.row] == j {
+				return top, true
+			}
+			heap.Pop(h)
+		}
+		return move{}, false
+	}
+
+	// the nodes of the search are the clusters and the pool, node k
+	pooled := k
+	best := make([]float64, k+1)
+	via := make([]int, k+1)
+	prev := make([]int, k+1)
+	relax := func(from, to, row int, cost float64) bool {
+		if best[from]+cost < best[to]-1e-12 {
+			best[to], via[to], prev[to] = best[from]+cost, row, from
+			return true
+		}
+		return false
+	}
+	for p := 0; p < n; p++ {
+		// the units passed to the sink through the pool
+		used := 0
+		for _, size := range sizes {
+			used += max(size-m.minSize, 0)
+		}
+
+		// Bellman-Ford, moving rows may lower the cost. A cluster above the
+		// min size may also take a unit of the pool from a cluster above the
+		// min size, as long as both stay within the bounds.
+		for j := range best {
+			best[j], via[j], prev[j] = math.Inf(1), -1, -1
+			if j < k {
+				best[j] = dist[p][j]
+			}
+		}
+		for round := 0; round < k+1; round++ {
+			changed := false
+			for j := 0; j < k; j++ {
+				for l := 0; l < k; l++ {
+					if l == j {
+						continue
+					}
+					if mv, ok := cheapest(j, l); ok && relax(j, l, mv.row, mv.cost) {
+						changed = true
+					}
+				}
+				if sizes[j] >= m.minSize && sizes[j] < maxSize && relax(j, pooled, -1, 0) {
+					changed = true
+				}
+				if sizes[j] > m.minSize && relax(pooled, j, -1, 0) {
+					changed = true
+				}
+			}
+			if !changed {
+				break
+			}
+		}
+
+		// the path ends at a cluster below the min size or at the pool
+		end := -1
+		for j := 0; j < k; j++ {
+			if sizes[j] < m.minSize && (end < 0 || best[j] < best[end]) {
+				end = j
+			}
+		}
+		if used < pool && (end < 0 || best[pooled] < best[end]) {
+			end = pooled
+		}
+
+		if end < 0 || math.IsInf(best[end], 1) {
+			return nil, fmt.Errorf("no cluster can take row %d within the size bounds", p)
+		}
+
+		// every cluster on the path passes a row on to the next one
+		for j, steps := end, 0; assigned[p] < 0; steps++ {
+			if steps > k+1 {
+				return nil, fmt.Errorf("the path of row %d does not lead back to it", p)
+			}
+			if prev[j] < 0 {
+				join(p, j)
+				break
+			}
+			if via[j] >= 0 {
+				join(via[j], j)
+			}
+			j = prev[j]
+		}
+	}
+	return assigned, nil
+}
+
+// move is a row and the change of the total distance when moving it
+type move struct {
+	cost float64
+	row  int
+}
+
+// moveHeap is a min-heap of moves by cost
+type moveHeap []move
+
+func (h moveHeap) Len() int           { return len(h) }
+func (h moveHeap) Less(i, j int) bool { return h[i].cost < h[j].cost }
+func (h moveHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *moveHeap) Push(v any)        { *h = append(*h, v.(move)) }
+func (h *moveHeap) Pop() any {
+	old := *h
+	v := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return v
+}
@@ -0,0 +1,184 @@
+package constrained
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"rfm_cluster/pkg/clusters"
+	"testing"
+)
+
+func randomMatrix(r *rand.Rand, n int) *clusters.Matrix {
+	x := clusters.NewMatrix(n, 2, clusters.SquaredEuclidean{})
+	for i := 0; i < n; i++ {
+		for j := range x.Row(i) {
+			x.Row(i)[j] = r.Float64() * 5
+		}
+	}
+	return x
+}
+
+func randomCenters(r *rand.Rand, k int) []clusters.Coordinates {
+	centers := make([]clusters.Coordinates, k)
+	for ci := range centers {
+		centers[ci] = clusters.Coordinates{r.Float64() * 5, r.Float64() * 5}
+	}
+	return centers
+}
+
+// cost returns the total distance of the rows to the centers they are
+// assigned to
+func cost(x *clusters.Matrix, centers []clusters.Coordinates, assigned []int) float64 {
+	var result float64
+	for p, ci := range assigned {
+		result += x.Distance(p, centers[ci])
+	}
+	return result
+}
+
+// bruteForce returns the smallest total distance of all assignments within
+// the size bounds, trying all k^n of them
+func bruteForce(x *clusters.Matrix, centers []clusters.Coordinates, minSize, maxSize int) float64 {
+	n, k := x.Rows, len(centers)
+	if maxSize == 0 {
+		maxSize = n
+	}
+	best := math.Inf(1)
+	assigned := make([]int, n)
+	sizes := make([]int, k)
+	var walk func(p int, total float64)
+	walk = func(p int, total float64) {
+		if p == n {
+			for _, size := range sizes {
+				if size < minSize {
+					return
+				}
+			}
+			best = math.Min(best, total)
+			return
+		}
+		for ci := 0; ci < k; ci++ {
+			if sizes[ci] == maxSize {
+				continue
+			}
+			assigned[p] = ci
+			sizes[ci]++
+			walk(p+1, total+x.Distance(p, centers[ci]))
+			sizes[ci]--
+		}
+	}
+	walk(0, 0)
+	return best
+}
+
+func checkBounds(t *testing.T, assigned []int, k, minSize, maxSize int) {
+	t.Helper()
+	sizes := make([]int, k)
+	for p, ci := range assigned {
+		if ci < 0 || ci >= k {
+			t.Fatalf("row %d assigned to cluster %d", p, ci)
+		}
+		sizes[ci]++
+	}
+	for ci, size := range sizes {
+		if size < minSize || (maxSize > 0 && size > maxSize) {
+			t.Fatalf("cluster %d has %d rows, expected %d to %d: %v", ci, size, minSize, maxSize, sizes)
+		}
+	}
+}
+
+func TestAssignMatchesBruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		n := 3 + r.Intn(6)
+		k := 2 + r.Intn(2)
+		minSize := r.Intn(n/k + 1)
+		maxSize := 0
+		if r.Intn(2) == 0 {
+			maxSize = max((n+k-1)/k, minSize, 1) + r.Intn(2)
+		}
+		if lo, hi := FeasibleK(n, minSize, maxSize); k < lo || k > hi {
+			continue
+		}
+		m, err := New(minSize, maxSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		x, centers := randomMatrix(r, n), randomCenters(r, k)
+		assigned, err := m.assign(x, centers)
+		if err != nil {
+			t.Fatalf("n=%d k=%d sizes %d to %d: %v", n, k, minSize, maxSize, err)
+		}
+		checkBounds(t, assigned, k, minSize, maxSize)
+		if got, expected := cost(x, centers, assigned), bruteForce(x, centers, minSize, maxSize); math.Abs(got-expected) > 1e-9 {
+			t.Fatalf("n=%d k=%d sizes %d to %d: got total distance %v, the optimum is %v", n, k, minSize, maxSize, got, expected)
+		}
+	}
+}
+
+func TestPartitionHoldsBounds(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for _, test := range []struct{ n, k, minSize, maxSize int }{
+		{500, 4, 100, 0},
+		{500, 4, 0, 130},
+		{500, 5, 90, 110},
+		{500, 5, 100, 100},
+		{1000, 8, 50, 200},
+	} {
+		m, err := New(test.minSize, test.maxSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		x := randomMatrix(r, test.n)
+		cc, err := m.WithSeed(42).Partition(x.Observations(), test.k)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assigned := make([]int, test.n)
+		for ci, c := range cc {
+			for _, o := range c.Observations {
+				assigned[o.(clusters.MatrixRow).Index] = ci
+			}
+		}
+		checkBounds(t, assigned, test.k, test.minSize, test.maxSize)
+	}
+}
+
+func TestPartitionInfeasible(t *testing.T) {
+	dataset := randomMatrix(rand.New(rand.NewSource(3)), 100).Observations()
+	for _, test := range []struct{ k, minSize, maxSize int }{
+		// 5 clusters of at least 30 need 150 observations
+		{5, 30, 0},
+		// 3 clusters of at most 20 hold only 60 observations
+		{3, 0, 20},
+	} {
+		m, err := New(test.minSize, test.maxSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = m.WithSeed(1).Partition(dataset, test.k)
+		var infeasible *InfeasibleError
+		if !errors.As(err, &infeasible) {
+			t.Fatalf("k=%d sizes %d to %d: got %v, expected an InfeasibleError", test.k, test.minSize, test.maxSize, err)
+		}
+		if infeasible.K != test.k || infeasible.Observations != 100 {
+			t.Fatalf("got %+v", infeasible)
+		}
+	}
+}
+
+// TestAssignWithoutPath calls assign with bounds Partition would reject: the
+// last row finds no cluster with room left, which has to be an error rather
+// than a row left unassigned
+func TestAssignWithoutPath(t *testing.T) {
+	m, err := New(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := rand.New(rand.NewSource(4))
+	if _, err := m.assign(randomMatrix(r, 3), randomCenters(r, 2)); err == nil {
+		t.Fatal("3 rows were assigned to 2 clusters of at most 1 row")
+	}
+}
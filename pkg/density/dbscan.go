@@ -0,0 +1,103 @@
+package density
+
+import (
+	"fmt"
+	"rfm_cluster/pkg/clusters"
+)
+
+// DBSCAN configuration/option struct
+type DBSCAN struct {
+	// eps is the neighbourhood radius, compared against the distances measured
+	// with the metric of the observations
+	eps float64
+	// minPts is the number of observations (including itself) an observation
+	// needs within eps to be a core point
+	minPts int
+}
+
+// NewDBSCAN returns a DBSCAN configuration struct
+func NewDBSCAN(eps float64, minPts int) (DBSCAN, error) {
+	if eps <= 0 {
+		return DBSCAN{}, fmt.Errorf("eps must be greater than 0")
+	}
+	if minPts < 1 {
+		return DBSCAN{}, fmt.Errorf("minPts must be at least 1")
+	}
+
+	return DBSCAN{eps: eps, minPts: minPts}, nil
+}
+
+// Cluster executes the DBSCAN algorithm on the given dataset. It returns the
+// clusters found, centered on their means, and the observations which are
+// noise. For the euclidean, squared euclidean, Manhattan and Chebyshev
+// distance the neighbours are looked up in a grid of cells eps wide; the
+// other metrics measure all pairs, which is O(n²) and limits them to some ten
+// thousand observations.
+func (m DBSCAN) Cluster(dataset clusters.Observations) (clusters.Clusters, clusters.Observations, error) {
+	x := clusters.MatrixOf(dataset)
+
+	const unvisited = -2
+	labels := make([]int, len(dataset))
+	for i := range labels {
+		labels[i] = unvisited
+	}
+
+	neighbours := func(p int) []int {
+		var r []int
+		for j := range dataset {
+			if x.Distance(p, x.Row(j)) <= m.eps {
+				r = append(r, j)
+			}
+		}
+		return r
+	}
+	if g := newGrid(x, m.eps); g != nil {
+		neighbours = g.within
+	}
+
+	// queued avoids adding an observation to the seeds of a cluster twice
+	queued := make([]bool, len(dataset))
+
+	n := 0
+	for p := range dataset {
+		if labels[p] != unvisited {
+			continue
+		}
+
+		seeds := neighbours(p)
+		if len(seeds) < m.minPts {
+			labels[p] = noise
+			continue
+		}
+
+		// expand a new cluster from the core point p
+		labels[p] = n
+		for _, q := range seeds {
+			queued[q] = true
+		}
+		for i := 0; i < len(seeds); i++ {
+			q := seeds[i]
+			if labels[q] == noise {
+				// border point
+				labels[q] = n
+			}
+			if labels[q] != unvisited {
+				continue
+			}
+
+			labels[q] = n
+			if qn := neighbours(q); len(qn) >= m.minPts {
+				for _, r := range qn {
+					if !queued[r] {
+						queued[r] = true
+						seeds = append(seeds, r)
+					}
+				}
+			}
+		}
+		n++
+	}
+
+	cc, outliers := fromLabels(dataset, labels, n)
+	return cc, outliers, nil
+}
@@ -0,0 +1,108 @@
+// Package density implements density based clustering. Unlike k-means the
+// number of clusters follows from the data, and observations in sparse regions
+// are reported as noise instead of being forced into a cluster.
+// See: https://en.wikipedia.org/wiki/DBSCAN and
+// https://hdbscan.readthedocs.io/en/latest/how_hdbscan_works.html
+package density
+
+import (
+	"math"
+	"rfm_cluster/pkg/clusters"
+	"slices"
+)
+
+// noise is the label of observations which do not belong to any cluster
+const noise = -1
+
+// KDistances returns the distance of every observation to its k-th nearest
+// neighbour (not counting itself), sorted in descending order. Plotted, the
+// curve has an elbow at a good value for the DBSCAN eps parameter. All pairs
+// are measured, which is O(n²).
+func KDistances(dataset clusters.Observations, k int) []float64 {
+	x := clusters.MatrixOf(dataset)
+
+	result := make([]float64, len(dataset))
+	// the k smallest distances of a row, ascending
+	nearest := make([]float64, 0, k+1)
+	for i := range dataset {
+		nearest = nearest[:0]
+		for j := range dataset {
+			if i == j {
+				continue
+			}
+			d := x.Distance(i, x.Row(j))
+			if len(nearest) == k && d >= nearest[k-1] {
+				continue
+			}
+			at, _ := slices.BinarySearch(nearest, d)
+			nearest = slices.Insert(nearest, at, d)
+			if len(nearest) > k {
+				nearest = nearest[:k]
+			}
+		}
+		if len(nearest) == 0 {
+			continue
+		}
+		result[i] = nearest[len(nearest)-1]
+	}
+
+	slices.SortFunc(result, func(a, b float64) int {
+		if a > b {
+			return -1
+		} else if a < b {
+			return 1
+		}
+		return 0
+	})
+	return result
+}
+
+// SuggestEps returns the elbow of a descending k-distance curve, the point
+// furthest away from the straight line between its first and last value.
+// Data with many duplicates can have its elbow at 0, the smallest positive
+// distance is returned instead. 0 is only returned if all distances are 0.
+func SuggestEps(kdistances []float64) float64 {
+	n := len(kdistances)
+	if n == 0 {
+		return 0
+	}
+	if n < 3 {
+		return kdistances[0]
+	}
+
+	x1, y1 := 0.0, kdistances[0]
+	x2, y2 := float64(n-1), kdistances[n-1]
+	norm := math.Hypot(x2-x1, y2-y1)
+
+	best, bestDist := n-1, -1.0
+	for i, y := range kdistances {
+		d := math.Abs((y2-y1)*float64(i)-(x2-x1)*y+x2*y1-y2*x1) / norm
+		if d > bestDist {
+			best, bestDist = i, d
+		}
+	}
+
+	for i := best; i >= 0; i-- {
+		if kdistances[i] > 0 {
+			return kdistances[i]
+		}
+	}
+	return 0
+}
+
+// fromLabels turns cluster labels (0..n-1, or noise) into clusters centered
+// on their means, plus the observations labelled as noise
+func fromLabels(dataset clusters.Observations, labels []int, n int) (clusters.Clusters, clusters.Observations) {
+	cc := make(clusters.Clusters, n)
+	var outliers clusters.Observations
+	for i, label := range labels {
+		if label == noise {
+			outliers = append(outliers, dataset[i])
+			continue
+		}
+		cc[label].Append(dataset[i])
+	}
+
+	cc.Recenter()
+	return cc, outliers
+}
@@ -0,0 +1,175 @@
+package density
+
+import (
+	"math/rand"
+	"rfm_cluster/pkg/clusters"
+	"slices"
+	"testing"
+)
+
+// testMatrix returns n rows of integer scores between 1 and 5 with a little
+// noise, so that there are dense groups and exact ties
+func testMatrix(n int, metric clusters.Metric) *clusters.Matrix {
+	r := rand.New(rand.NewSource(1))
+	x := clusters.NewMatrix(n, 3, metric)
+	for i := 0; i < n; i++ {
+		for j := range x.Row(i) {
+			x.Row(i)[j] = float64(1+r.Intn(5)) + r.Float64()*0.3*float64(r.Intn(2))
+		}
+	}
+	return x
+}
+
+func TestGridWithinMatchesAllPairs(t *testing.T) {
+	metrics := []clusters.Metric{clusters.Euclidean{}, clusters.SquaredEuclidean{}, clusters.Manhattan{}, clusters.Chebyshev{}}
+	for _, metric := range metrics {
+		x := testMatrix(1000, metric)
+		for _, eps := range []float64{0.1, 0.5, 1, 2} {
+			g := newGrid(x, eps)
+			if g == nil {
+				t.Fatalf("%T: no grid", metric)
+			}
+			for p := 0; p < x.Rows; p++ {
+				var expected []int
+				for q := 0; q < x.Rows; q++ {
+					if x.Distance(p, x.Row(q)) <= eps {
+						expected = append(expected, q)
+					}
+				}
+				if got := g.within(p); !slices.Equal(got, expected) {
+					t.Fatalf("%T eps=%g row %d: got %d neighbours, expected %d", metric, eps, p, len(got), len(expected))
+				}
+			}
+		}
+	}
+
+	if newGrid(testMatrix(10, clusters.Cosine{}), 0.5) != nil {
+		t.Fatal("the cosine distance has no coordinate bound")
+	}
+}
+
+func TestKDistances(t *testing.T) {
+	x := testMatrix(300, clusters.Euclidean{})
+	for _, k := range []int{1, 4, 10} {
+		expected := make([]float64, x.Rows)
+		for i := range expected {
+			var dist []float64
+			for j := 0; j < x.Rows; j++ {
+				if i != j {
+					dist = append(dist, x.Distance(i, x.Row(j)))
+				}
+			}
+			slices.Sort(dist)
+			expected[i] = dist[k-1]
+		}
+		slices.Sort(expected)
+		slices.Reverse(expected)
+
+		if got := KDistances(x.Observations(), k); !slices.Equal(got, expected) {
+			t.Fatalf("k=%d: got %v..., expected %v...", k, got[:5], expected[:5])
+		}
+	}
+}
+
+// blobs returns size rows around each of the centers, at most 0.5 away, and
+// the outliers after them
+func blobs(centers, outliers [][]float64, size int) *clusters.Matrix {
+	r := rand.New(rand.NewSource(2))
+	x := clusters.NewMatrix(len(centers)*size+len(outliers), 2, clusters.Euclidean{})
+	for ci, center := range centers {
+		for i := 0; i < size; i++ {
+			row := x.Row(ci*size + i)
+			row[0] = center[0] + (r.Float64()-0.5)*0.7
+			row[1] = center[1] + (r.Float64()-0.5)*0.7
+		}
+	}
+	for i, o := range outliers {
+		copy(x.Row(len(centers)*size+i), o)
+	}
+	return x
+}
+
+// checkBlobs checks that every cluster holds exactly the rows of one blob and
+// that the rows after the blobs are noise
+func checkBlobs(t *testing.T, cc clusters.Clusters, noise clusters.Observations, blobCount, size, outliers int) {
+	t.Helper()
+	if len(cc) != blobCount {
+		t.Fatalf("got %d clusters, expected %d", len(cc), blobCount)
+	}
+	for ci, c := range cc {
+		if len(c.Observations) != size {
+			t.Fatalf("cluster %d has %d rows, expected %d", ci, len(c.Observations), size)
+		}
+		blob := c.Observations[0].(clusters.MatrixRow).Index / size
+		for _, o := range c.Observations {
+			if o.(clusters.MatrixRow).Index/size != blob {
+				t.Fatalf("cluster %d mixes the blobs %d and %d", ci, blob, o.(clusters.MatrixRow).Index/size)
+			}
+		}
+	}
+	if len(noise) != outliers {
+		t.Fatalf("got %d noise rows, expected %d", len(noise), outliers)
+	}
+	for _, o := range noise {
+		if o.(clusters.MatrixRow).Index < blobCount*size {
+			t.Fatalf("row %d of a blob is noise", o.(clusters.MatrixRow).Index)
+		}
+	}
+}
+
+var (
+	blobCenters = [][]float64{{0, 0}, {10, 0}, {0, 10}}
+	outliers    = [][]float64{{20, 20}, {-10, -10}, {30, -5}}
+)
+
+func TestDBSCANFindsBlobs(t *testing.T) {
+	x := blobs(blobCenters, outliers, 30)
+	m, err := NewDBSCAN(1, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc, noise, err := m.Cluster(x.Observations())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBlobs(t, cc, noise, len(blobCenters), 30, len(outliers))
+}
+
+func TestDBSCANBorderPoints(t *testing.T) {
+	// 0.5 is a border point: within eps of the core point 0.4 but with only
+	// two neighbours itself. It comes first, so it is labelled noise before
+	// the cluster reaches it. 5 is too far from everything.
+	values := []float64{0.5, 0, 0.1, 0.2, 0.3, 0.4, 5}
+	x := clusters.NewMatrix(len(values), 1, clusters.Euclidean{})
+	for i, v := range values {
+		x.Row(i)[0] = v
+	}
+
+	m, err := NewDBSCAN(0.15, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc, noise, err := m.Cluster(x.Observations())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cc) != 1 || len(cc[0].Observations) != 6 {
+		t.Fatalf("got %d clusters, expected one cluster of the first 6 rows: %v", len(cc), cc)
+	}
+	if len(noise) != 1 || noise[0].(clusters.MatrixRow).Index != 6 {
+		t.Fatalf("got noise %v, expected the last row", noise)
+	}
+}
+
+func TestHDBSCANFindsBlobs(t *testing.T) {
+	x := blobs(blobCenters, outliers, 30)
+	m, err := NewHDBSCAN(4, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc, noise, err := m.Cluster(x.Observations())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBlobs(t, cc, noise, len(blobCenters), 30, len(outliers))
+}
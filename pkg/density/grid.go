@@ -0,0 +1,111 @@
+package density
+
+import (
+	"encoding/binary"
+	"math"
+	"rfm_cluster/pkg/clusters"
+	"slices"
+)
+
+// grid buckets the rows of a matrix into cubic cells as wide as the largest
+// coordinate difference two rows within eps can have. The rows within eps of
+// a row are then in its own or an adjacent cell, so a range query measures
+// the rows of 3^d cells instead of all rows.
+type grid struct {
+	x     *clusters.Matrix
+	eps   float64
+	width float64
+	cells map[string][]int
+}
+
+// coordinateBound returns the largest difference in any single coordinate
+// between two points whose distance is at most eps. Metrics without such a
+// bound, like cosine and Mahalanobis, return false.
+func coordinateBound(metric clusters.Metric, eps float64) (float64, bool) {
+	switch metric.(type) {
+	case clusters.Euclidean, clusters.Manhattan, clusters.Chebyshev:
+		return eps, true
+	case clusters.SquaredEuclidean:
+		return math.Sqrt(eps), true
+	}
+	return 0, false
+}
+
+// maxCell keeps the cell coordinates far from overflowing an int64
+const maxCell = 1 << 52
+
+// newGrid indexes the rows of x for range queries with radius eps. It returns
+// nil if the metric of x has no coordinate bound or the cells would be too
+// small for the range of the coordinates.
+func newGrid(x *clusters.Matrix, eps float64) *grid {
+	width, ok := coordinateBound(x.Metric, eps)
+	if !ok || width <= 0 {
+		return nil
+	}
+
+	// widened, so that rounding never puts two rows exactly width apart two
+	// cells apart
+	width *= 1 + 1e-9
+	g := &grid{x: x, eps: eps, width: width, cells: map[string][]int{}}
+	cell := make([]int64, x.Stride)
+	for i := 0; i < x.Rows; i++ {
+		for j, v := range x.Row(i) {
+			c := math.Floor(v / width)
+			if math.IsNaN(c) || math.Abs(c) > maxCell {
+				return nil
+			}
+			cell[j] = int64(c)
+		}
+		key := cellKey(cell)
+		g.cells[key] = append(g.cells[key], i)
+	}
+	return g
+}
+
+func cellKey(cell []int64) string {
+	b := make([]byte, 0, 8*len(cell))
+	for _, c := range cell {
+		b = binary.LittleEndian.AppendUint64(b, uint64(c))
+	}
+	return string(b)
+}
+
+// within returns the rows within eps of row p in ascending order, the order
+// measuring every row yields
+func (g *grid) within(p int) []int {
+	row := g.x.Row(p)
+	center := make([]int64, len(row))
+	for j, v := range row {
+		center[j] = int64(math.Floor(v / g.width))
+	}
+
+	var r []int
+	cell := make([]int64, len(row))
+	offsets := make([]int64, len(row))
+	for j := range offsets {
+		offsets[j] = -1
+	}
+	for {
+		for j := range cell {
+			cell[j] = center[j] + offsets[j]
+		}
+		for _, q := range g.cells[cellKey(cell)] {
+			if g.x.Distance(p, g.x.Row(q)) <= g.eps {
+				r = append(r, q)
+			}
+		}
+
+		// next combination of offsets in {-1, 0, 1}^d
+		j := 0
+		for ; j < len(offsets) && offsets[j] == 1; j++ {
+			offsets[j] = -1
+		}
+		if j == len(offsets) {
+			break
+		}
+		offsets[j]++
+	}
+
+	slices.Sort(r)
+	return r
+}
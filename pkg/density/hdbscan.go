@@ -0,0 +1,315 @@
+package density
+
+import (
+	"fmt"
+	"math"
+	"rfm_cluster/pkg/clusters"
+	"slices"
+)
+
+// HDBSCAN configuration/option struct
+type HDBSCAN struct {
+	// minSamples is the number of observations (including itself) defining
+	// the core distance of an observation
+	minSamples int
+	// minClusterSize is the smallest group of observations considered a
+	// cluster, smaller groups splitting off are noise
+	minClusterSize int
+}
+
+// NewHDBSCAN returns a HDBSCAN configuration struct
+func NewHDBSCAN(minSamples, minClusterSize int) (HDBSCAN, error) {
+	if minSamples < 1 {
+		return HDBSCAN{}, fmt.Errorf("minSamples must be at least 1")
+	}
+	if minClusterSize < 2 {
+		return HDBSCAN{}, fmt.Errorf("minClusterSize must be at least 2")
+	}
+
+	return HDBSCAN{minSamples: minSamples, minClusterSize: minClusterSize}, nil
+}
+
+// mergeStep is one step of the single linkage hierarchy, joining the nodes
+// left and right at distance dist into a node holding size observations
+type mergeStep struct {
+	left, right int
+	dist        float64
+	size        int
+}
+
+// condensedEdge is an edge of the condensed cluster tree. child is either a
+// cluster (>= n) or an observation (< n) falling out of parent at lambda.
+type condensedEdge struct {
+	parent, child int
+	lambda        float64
+	size          int
+}
+
+// Cluster executes the HDBSCAN algorithm on the given dataset. It returns the
+// most stable clusters, centered on their means, and the observations which
+// are noise. Runtime and memory are quadratic in the size of the data set.
+func (m HDBSCAN) Cluster(dataset clusters.Observations) (clusters.Clusters, clusters.Observations, error) {
+	n := len(dataset)
+	if n < m.minClusterSize {
+		return nil, nil, fmt.Errorf("the size of the data set must at least equal the minimum cluster size")
+	}
+
+	merges := m.singleLinkage(dataset)
+	edges := m.condense(merges, n)
+	selected := selectClusters(edges, n)
+
+	// label every observation with the selected cluster it belongs to
+	parent := map[int]int{}
+	for _, e := range edges {
+		parent[e.child] = e.parent
+	}
+	index := map[int]int{}
+	for i, c := range selected {
+		index[c] = i
+	}
+
+	labels := make([]int, n)
+	for i := range labels {
+		labels[i] = noise
+		for node, ok := parent[i]; ok; node, ok = parent[node] {
+			if ci, found := index[node]; found {
+				labels[i] = ci
+				break
+			}
+		}
+	}
+
+	cc, outliers := fromLabels(dataset, labels, len(selected))
+	return cc, outliers, nil
+}
+
+// singleLinkage builds the minimum spanning tree of the mutual reachability
+// graph with Prim's algorithm and returns it as single linkage merge steps
+func (m HDBSCAN) singleLinkage(dataset clusters.Observations) []mergeStep {
+	n := len(dataset)
+	x := clusters.MatrixOf(dataset)
+
+	// core distance: distance to the minSamples-th nearest observation,
+	// counting the observation itself
+	core := make([]float64, n)
+	dist := make([]float64, n)
+	for i := range dataset {
+		for j := range dataset {
+			dist[j] = x.Distance(i, x.Row(j))
+		}
+		slices.Sort(dist)
+		core[i] = dist[min(m.minSamples, n)-1]
+	}
+
+	type mstEdge struct {
+		a, b int
+		dist float64
+	}
+	edges := make([]mstEdge, 0, n-1)
+
+	inTree := make([]bool, n)
+	best := make([]float64, n)
+	from := make([]int, n)
+	for i := range best {
+		best[i] = math.Inf(1)
+	}
+
+	current := 0
+	inTree[0] = true
+	for len(edges) < n-1 {
+		next, nextDist := -1, math.Inf(1)
+		for j := 0; j < n; j++ {
+			if inTree[j] {
+				continue
+			}
+			d := math.Max(x.Distance(current, x.Row(j)), math.Max(core[current], core[j]))
+			if d < best[j] {
+				best[j], from[j] = d, current
+			}
+			if best[j] < nextDist {
+				next, nextDist = j, best[j]
+			}
+		}
+
+		inTree[next] = true
+		edges = append(edges, mstEdge{a: from[next], b: next, dist: nextDist})
+		current = next
+	}
+
+	slices.SortStableFunc(edges, func(x, y mstEdge) int {
+		if x.dist < y.dist {
+			return -1
+		} else if x.dist > y.dist {
+			return 1
+		}
+		return 0
+	})
+
+	// union find over the sorted edges; merged nodes get ids n, n+1, ...
+	uf := make([]int, 2*n-1)
+	size := make([]int, 2*n-1)
+	for i := range uf {
+		uf[i] = i
+		size[i] = 1
+	}
+	find := func(x int) int {
+		for uf[x] != x {
+			uf[x] = uf[uf[x]]
+			x = uf[x]
+		}
+		return x
+	}
+
+	merges := make([]mergeStep, 0, n-1)
+	for i, e := range edges {
+		a, b := find(e.a), find(e.b)
+		node := n + i
+		uf[a], uf[b] = node, node
+		size[node] = size[a] + size[b]
+		merges = append(merges, mergeStep{left: a, right: b, dist: e.dist, size: size[node]})
+	}
+	return merges
+}
+
+// condense walks the single linkage hierarchy from the root and keeps only
+// splits where both sides hold at least minClusterSize observations. Cluster
+// ids of the condensed tree start at n, the root being n.
+func (m HDBSCAN) condense(merges []mergeStep, n int) []condensedEdge {
+	root := 2*n - 2
+	if n == 1 {
+		return nil
+	}
+
+	// distances of 0 (duplicate observations) would give infinite lambdas,
+	// cap them at the smallest positive merge distance
+	minDist := math.Inf(1)
+	for _, s := range merges {
+		if s.dist > 0 && s.dist < minDist {
+			minDist = s.dist
+		}
+	}
+	if math.IsInf(minDist, 1) {
+		minDist = 1
+	}
+
+	sizeOf := func(node int) int {
+		if node < n {
+			return 1
+		}
+		return merges[node-n].size
+	}
+	var leaves func(node int, fn func(int))
+	leaves = func(node int, fn func(int)) {
+		stack := []int{node}
+		for len(stack) > 0 {
+			x := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if x < n {
+				fn(x)
+				continue
+			}
+			stack = append(stack, merges[x-n].left, merges[x-n].right)
+		}
+	}
+
+	relabel := map[int]int{root: n}
+	next := n + 1
+	var edges []condensedEdge
+
+	queue := []int{root}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		if node < n {
+			continue
+		}
+
+		step := merges[node-n]
+		lambda := 1 / math.Max(step.dist, minDist)
+		parent := relabel[node]
+		left, right := step.left, step.right
+		leftBig, rightBig := sizeOf(left) >= m.minClusterSize, sizeOf(right) >= m.minClusterSize
+
+		fallOut := func(child int) {
+			leaves(child, func(p int) {
+				edges = append(edges, condensedEdge{parent: parent, child: p, lambda: lambda, size: 1})
+			})
+		}
+
+		switch {
+		case leftBig && rightBig:
+			// a true split, both children become new clusters
+			for _, child := range []int{left, right} {
+				relabel[child] = next
+				edges = append(edges, condensedEdge{parent: parent, child: next, lambda: lambda, size: sizeOf(child)})
+				next++
+				queue = append(queue, child)
+			}
+		case leftBig:
+			// the cluster lives on in the left child
+			relabel[left] = parent
+			queue = append(queue, left)
+			fallOut(right)
+		case rightBig:
+			relabel[right] = parent
+			queue = append(queue, right)
+			fallOut(left)
+		default:
+			fallOut(left)
+			fallOut(right)
+		}
+	}
+
+	return edges
+}
+
+// selectClusters chooses the clusters of the condensed tree with the excess
+// of mass method: a cluster is kept unless its children are more stable
+// together. The root is never selected.
+func selectClusters(edges []condensedEdge, n int) []int {
+	birth := map[int]float64{n: 0}
+	children := map[int][]int{}
+	maxID := n
+	for _, e := range edges {
+		if e.child >= n {
+			birth[e.child] = e.lambda
+			children[e.parent] = append(children[e.parent], e.child)
+			maxID = max(maxID, e.child)
+		}
+	}
+
+	stability := map[int]float64{}
+	for _, e := range edges {
+		stability[e.parent] += (e.lambda - birth[e.parent]) * float64(e.size)
+	}
+
+	selected := map[int]bool{}
+	for c := maxID; c > n; c-- {
+		var childStability float64
+		for _, child := range children[c] {
+			childStability += stability[child]
+		}
+
+		if len(children[c]) > 0 && childStability > stability[c] {
+			stability[c] = childStability
+			continue
+		}
+
+		// c is more stable than its descendants, which are dropped
+		selected[c] = true
+		stack := append([]int{}, children[c]...)
+		for len(stack) > 0 {
+			x := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			delete(selected, x)
+			stack = append(stack, children[x]...)
+		}
+	}
+
+	result := make([]int, 0, len(selected))
+	for c := range selected {
+		result = append(result, c)
+	}
+	slices.Sort(result)
+	return result
+}
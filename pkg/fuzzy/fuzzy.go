@@ -0,0 +1,259 @@
+// Package fuzzy implements the fuzzy c-means clustering algorithm. Every
+// observation belongs to every cluster with a membership degree between 0 and
+// 1, the degrees of one observation summing up to 1.
+// See: https://en.wikipedia.org/wiki/Fuzzy_clustering#Fuzzy_C-means_clustering
+package fuzzy
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"rfm_cluster/pkg/clusters"
+	"time"
+)
+
+// CMeans configuration/option struct
+type CMeans struct {
+	// fuzzifier (m > 1) controls how fuzzy the clusters are, values close
+	// to 1 approach k-means, 2 is the common default
+	fuzzifier float64
+	// iterationThreshold aborts processing when the specified amount of
+	// iterations was reached
+	iterationThreshold int
+	// tolerance stops processing once no membership degree changed by more
+	// than this in the last iteration
+	tolerance float64
+	// seed makes the random initial memberships reproducible, 0 seeds from
+	// the current time
+	seed int64
+}
+
+// NewWithOptions returns a CMeans configuration struct with custom settings
+func NewWithOptions(fuzzifier float64, iterationThreshold int, tolerance float64) (CMeans, error) {
+	if fuzzifier <= 1 {
+		return CMeans{}, fmt.Errorf("fuzzifier must be greater than 1")
+	}
+	if iterationThreshold < 1 {
+		return CMeans{}, fmt.Errorf("iteration threshold must be at least 1")
+	}
+	if tolerance <= 0 {
+		return CMeans{}, fmt.Errorf("tolerance must be greater than 0")
+	}
+
+	return CMeans{
+		fuzzifier:          fuzzifier,
+		iterationThreshold: iterationThreshold,
+		tolerance:          tolerance,
+	}, nil
+}
+
+// New returns a CMeans configuration struct with the given fuzzifier and
+// default settings
+func New(fuzzifier float64) (CMeans, error) {
+	return NewWithOptions(fuzzifier, 300, 1e-5)
+}
+
+// WithSeed returns a copy of the configuration whose random choices are
+// derived from seed. A seed of 0 restores the time based default.
+func (m CMeans) WithSeed(seed int64) CMeans {
+	m.seed = seed
+	return m
+}
+
+func (m CMeans) random(c int) *rand.Rand {
+	if m.seed == 0 {
+		return rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return rand.New(rand.NewSource(m.seed + int64(c)))
+}
+
+// Result is a fitted fuzzy partition
+type Result struct {
+	Centers []clusters.Coordinates
+	// Memberships holds for every observation the membership degree of
+	// each cluster
+	Memberships [][]float64
+	// Objective is the weighted within-cluster sum of squared distances
+	// which fuzzy c-means minimizes
+	Objective  float64
+	Iterations int
+	Converged  bool
+}
+
+// Partition runs fuzzy c-means and assigns every observation to the cluster
+// of its highest membership degree. It implements silhouette.Partitioner.
+func (m CMeans) Partition(dataset clusters.Observations, c int) (clusters.Clusters, error) {
+	result, err := m.Fit(dataset, c)
+	if err != nil {
+		return clusters.Clusters{}, err
+	}
+	return result.Clusters(dataset), nil
+}
+
+// Fit runs fuzzy c-means on the given dataset with c clusters, starting from
+// random memberships. Distances are squared euclidean distances between the
+// coordinates, whatever metric the observations measure with, as the center
+// update is only optimal for those.
+func (m CMeans) Fit(dataset clusters.Observations, c int) (*Result, error) {
+	if c < 1 {
+		return nil, fmt.Errorf("c must be greater than 0")
+	}
+	if c > len(dataset) {
+		return nil, fmt.Errorf("the size of the data set must at least equal c")
+	}
+
+	n, d := len(dataset), len(dataset[0].Coordinates())
+	r := m.random(c)
+
+	u := make([][]float64, n)
+	for i := range u {
+		u[i] = make([]float64, c)
+		var sum float64
+		for j := range u[i] {
+			u[i][j] = r.Float64()
+			sum += u[i][j]
+		}
+		for j := range u[i] {
+			u[i][j] /= sum
+		}
+	}
+
+	result := &Result{Centers: make([]clusters.Coordinates, c), Memberships: u}
+	for j := range result.Centers {
+		result.Centers[j] = make(clusters.Coordinates, d)
+	}
+
+	dist := make([]float64, c)
+	for result.Iterations = 1; result.Iterations <= m.iterationThreshold; result.Iterations++ {
+		m.updateCenters(result, dataset)
+
+		var shift float64
+		result.Objective = 0
+		for i, o := range dataset {
+			for j, center := range result.Centers {
+				dist[j] = squared.Distance(o.Coordinates(), center)
+			}
+			for j, v := range m.memberships(dist) {
+				shift = math.Max(shift, math.Abs(v-u[i][j]))
+				u[i][j] = v
+				result.Objective += math.Pow(v, m.fuzzifier) * dist[j]
+			}
+		}
+
+		if shift < m.tolerance {
+			result.Converged = true
+			break
+		}
+	}
+	result.Iterations = min(result.Iterations, m.iterationThreshold)
+
+	return result, nil
+}
+
+// squared measures the distances fuzzy c-means minimizes
+var squared clusters.SquaredEuclidean
+
+// updateCenters moves every center to the mean of all observations weighted
+// by their membership degree raised to the fuzzifier
+func (m CMeans) updateCenters(result *Result, dataset clusters.Observations) {
+	for j, center := range result.Centers {
+		var total float64
+		for k := range center {
+			center[k] = 0
+		}
+		for i, o := range dataset {
+			w := math.Pow(result.Memberships[i][j], m.fuzzifier)
+			total += w
+			for k, v := range o.Coordinates() {
+				center[k] += w * v
+			}
+		}
+		if total == 0 {
+			continue
+		}
+		for k := range center {
+			center[k] /= total
+		}
+	}
+}
+
+// memberships returns the membership degrees of an observation with the
+// given squared distances to all centers. An observation on a center belongs
+// to it (or to all centers it coincides with) completely.
+func (m CMeans) memberships(dist []float64) []float64 {
+	u := make([]float64, len(dist))
+
+	var zero int
+	for _, d := range dist {
+		if d == 0 {
+			zero++
+		}
+	}
+	if zero > 0 {
+		for j, d := range dist {
+			if d == 0 {
+				u[j] = 1 / float64(zero)
+			}
+		}
+		return u
+	}
+
+	// u_j = 1 / sum_k (d_j/d_k)^(1/(m-1)) with squared distances
+	exponent := 1 / (m.fuzzifier - 1)
+	for j := range dist {
+		var sum float64
+		for k := range dist {
+			sum += math.Pow(dist[j]/dist[k], exponent)
+		}
+		u[j] = 1 / sum
+	}
+	return u
+}
+
+// Clusters defuzzifies the partition: every observation is assigned to the
+// cluster of its highest membership degree. The center of every cluster is
+// the fuzzy center, clusters without observations stay empty so indices
+// match the membership matrix.
+func (r *Result) Clusters(dataset clusters.Observations) clusters.Clusters {
+	cc := make(clusters.Clusters, len(r.Centers))
+	for j := range cc {
+		cc[j].Center = r.Centers[j]
+	}
+	for i, o := range dataset {
+		best := 0
+		for j, v := range r.Memberships[i] {
+			if v > r.Memberships[i][best] {
+				best = j
+			}
+		}
+		cc[best].Append(o)
+	}
+	return cc
+}
+
+// PartitionCoefficient returns Bezdek's partition coefficient, the mean of
+// the squared membership degrees. It ranges from 1/c (completely fuzzy) to 1
+// (crisp), higher is better.
+func (r *Result) PartitionCoefficient() float64 {
+	var sum float64
+	for _, u := range r.Memberships {
+		for _, v := range u {
+			sum += v * v
+		}
+	}
+	return sum / float64(len(r.Memberships))
+}
+
+// PartitionEntropy returns the partition entropy of the membership degrees.
+// It ranges from 0 (crisp) to log(c) (completely fuzzy), lower is better.
+func (r *Result) PartitionEntropy() float64 {
+	var sum float64
+	for _, u := range r.Memberships {
+		for _, v := range u {
+			if v > 0 {
+				sum -= v * math.Log(v)
+			}
+		}
+	}
+	return sum / float64(len(r.Memberships))
+}
@@ -0,0 +1,65 @@
+package fuzzy
+
+import (
+	"math"
+	"math/rand"
+	"rfm_cluster/pkg/clusters"
+	"testing"
+)
+
+// TestFitIgnoresObservationMetric fits the same coordinates once measured
+// with the euclidean metric and once with the squared euclidean one. Fuzzy
+// c-means has to measure squared distances in both cases.
+func TestFitIgnoresObservationMetric(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	euclidean := clusters.NewMatrix(300, 3, clusters.Euclidean{})
+	squared := clusters.NewMatrix(300, 3, clusters.SquaredEuclidean{})
+	for i := 0; i < euclidean.Rows; i++ {
+		for j := range euclidean.Row(i) {
+			v := r.Float64()*5 + float64(i%3)*3
+			euclidean.Row(i)[j] = v
+			squared.Row(i)[j] = v
+		}
+	}
+
+	m, err := New(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m = m.WithSeed(42)
+	expected, err := m.Fit(squared.Observations(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.Fit(euclidean.Observations(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Objective != expected.Objective {
+		t.Fatalf("got objective %v, expected %v", got.Objective, expected.Objective)
+	}
+	for i := range expected.Memberships {
+		for j := range expected.Memberships[i] {
+			if got.Memberships[i][j] != expected.Memberships[i][j] {
+				t.Fatalf("observation %d: got memberships %v, expected %v", i, got.Memberships[i], expected.Memberships[i])
+			}
+		}
+	}
+}
+
+// TestMemberships checks the membership degrees against the closed form for
+// two centers, u_1 = 1 / (1 + (d_1/d_2)^(1/(m-1)))
+func TestMemberships(t *testing.T) {
+	m, err := New(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := m.memberships([]float64{1, 4})
+	if math.Abs(u[0]-0.8) > 1e-12 || math.Abs(u[1]-0.2) > 1e-12 {
+		t.Fatalf("got %v, expected [0.8 0.2]", u)
+	}
+	if u := m.memberships([]float64{0, 4}); u[0] != 1 || u[1] != 0 {
+		t.Fatalf("got %v, expected [1 0] for an observation on a center", u)
+	}
+}
@@ -0,0 +1,220 @@
+// Package gmm implements gaussian mixture models fitted with the expectation
+// maximization (EM) algorithm. Unlike k-means every observation gets a
+// probability of belonging to each component, so observations on the border
+// of two clusters can be told apart from clear members.
+// See: https://en.wikipedia.org/wiki/Mixture_model#Gaussian_mixture_model
+package gmm
+
+import (
+	"fmt"
+	"math"
+	"rfm_cluster/pkg/clusters"
+	"rfm_cluster/pkg/kmeans"
+)
+
+// Covariance is the shape of the component covariance matrices
+type Covariance string
+
+// Supported covariance types
+const (
+	// Full lets every component have its own general covariance matrix
+	Full Covariance = "full"
+	// Diagonal restricts every component to axis aligned ellipsoids
+	Diagonal Covariance = "diag"
+)
+
+// Covariances returns all supported covariance types
+func Covariances() []Covariance {
+	return []Covariance{Full, Diagonal}
+}
+
+// GMM configuration/option struct
+type GMM struct {
+	covariance Covariance
+	// iterationThreshold aborts EM when the specified amount of iterations
+	// was reached
+	iterationThreshold int
+	// tolerance stops EM once the mean log-likelihood per observation
+	// improves by less than this
+	tolerance float64
+	// regularization is added to the diagonal of every covariance matrix,
+	// which keeps components on duplicate observations from collapsing
+	regularization float64
+	// seed makes the k-means initialization reproducible, 0 seeds from the
+	// current time
+	seed int64
+}
+
+// NewWithOptions returns a GMM configuration struct with custom settings
+func NewWithOptions(covariance Covariance, iterationThreshold int, tolerance, regularization float64) (GMM, error) {
+	if covariance != Full && covariance != Diagonal {
+		return GMM{}, fmt.Errorf("unknown covariance type %q", covariance)
+	}
+	if iterationThreshold < 1 {
+		return GMM{}, fmt.Errorf("iteration threshold must be at least 1")
+	}
+	if tolerance <= 0 {
+		return GMM{}, fmt.Errorf("tolerance must be greater than 0")
+	}
+	if regularization < 0 {
+		return GMM{}, fmt.Errorf("regularization must not be negative")
+	}
+
+	return GMM{
+		covariance:         covariance,
+		iterationThreshold: iterationThreshold,
+		tolerance:          tolerance,
+		regularization:     regularization,
+	}, nil
+}
+
+// New returns a GMM configuration struct with default settings
+func New(covariance Covariance) (GMM, error) {
+	return NewWithOptions(covariance, 200, 1e-4, 1e-3)
+}
+
+// WithSeed returns a copy of the configuration whose initialization is
+// derived from seed. A seed of 0 restores the time based default.
+func (m GMM) WithSeed(seed int64) GMM {
+	m.seed = seed
+	return m
+}
+
+// Partition fits a mixture of k components and assigns every observation to
+// its most probable component. It implements silhouette.Partitioner.
+func (m GMM) Partition(dataset clusters.Observations, k int) (clusters.Clusters, error) {
+	model, err := m.Fit(dataset, k)
+	if err != nil {
+		return clusters.Clusters{}, err
+	}
+	return model.Clusters(dataset), nil
+}
+
+// Fit runs EM on the given dataset with k components. The components are
+// initialized from a k-means partition.
+func (m GMM) Fit(dataset clusters.Observations, k int) (*Model, error) {
+	if k < 1 {
+		return nil, fmt.Errorf("k must be greater than 0")
+	}
+	if k > len(dataset) {
+		return nil, fmt.Errorf("the size of the data set must at least equal k")
+	}
+
+	km, err := kmeans.NewWithOptions(0.01, nil)
+	if err != nil {
+		return nil, err
+	}
+	cc, err := km.WithSeed(m.seed).Partition(dataset, k)
+	if err != nil {
+		return nil, err
+	}
+
+	n, d := len(dataset), len(dataset[0].Coordinates())
+	x := make([]clusters.Coordinates, n)
+	for i, o := range dataset {
+		x[i] = o.Coordinates()
+	}
+
+	// hard responsibilities of the k-means partition
+	resp := make([][]float64, n)
+	for i := range resp {
+		resp[i] = make([]float64, k)
+	}
+	for i, o := range dataset {
+		resp[i][cc.Nearest(o)] = 1
+	}
+
+	model := &Model{
+		Covariance:  m.covariance,
+		Weights:     make([]float64, k),
+		Means:       make([]clusters.Coordinates, k),
+		Covariances: make([][][]float64, k),
+	}
+	for c := 0; c < k; c++ {
+		model.Means[c] = make(clusters.Coordinates, d)
+		model.Covariances[c] = identity(d)
+	}
+
+	previous := math.Inf(-1)
+	for model.Iterations = 1; model.Iterations <= m.iterationThreshold; model.Iterations++ {
+		m.maximize(model, x, resp)
+		if err := model.factorize(); err != nil {
+			return nil, err
+		}
+
+		model.LogLikelihood = model.expect(x, resp)
+		if model.LogLikelihood-previous < m.tolerance*float64(n) {
+			model.Converged = true
+			break
+		}
+		previous = model.LogLikelihood
+	}
+	model.Iterations = min(model.Iterations, m.iterationThreshold)
+
+	model.Probabilities = resp
+	model.Samples = n
+	return model, nil
+}
+
+// maximize updates weights, means and covariances from the responsibilities
+func (m GMM) maximize(model *Model, x []clusters.Coordinates, resp [][]float64) {
+	n, d, k := len(x), len(x[0]), len(model.Weights)
+	for c := 0; c < k; c++ {
+		var nk float64
+		mean := make(clusters.Coordinates, d)
+		for i := 0; i < n; i++ {
+			nk += resp[i][c]
+			for j := 0; j < d; j++ {
+				mean[j] += resp[i][c] * x[i][j]
+			}
+		}
+
+		// an empty component keeps its previous mean and covariance
+		model.Weights[c] = nk / float64(n)
+		if nk < 1e-10 {
+			continue
+		}
+		for j := range mean {
+			mean[j] /= nk
+		}
+
+		cov := make([][]float64, d)
+		for a := range cov {
+			cov[a] = make([]float64, d)
+		}
+		for i := 0; i < n; i++ {
+			if resp[i][c] == 0 {
+				continue
+			}
+			for a := 0; a < d; a++ {
+				da := x[i][a] - mean[a]
+				if m.covariance == Diagonal {
+					cov[a][a] += resp[i][c] * da * da
+					continue
+				}
+				for b := 0; b <= a; b++ {
+					cov[a][b] += resp[i][c] * da * (x[i][b] - mean[b])
+				}
+			}
+		}
+		for a := 0; a < d; a++ {
+			for b := 0; b <= a; b++ {
+				cov[a][b] /= nk
+				cov[b][a] = cov[a][b]
+			}
+			cov[a][a] += m.regularization
+		}
+
+		model.Means[c] = mean
+		model.Covariances[c] = cov
+	}
+}
+
+func identity(d int) [][]float64 {
+	m := make([][]float64, d)
+	for i := range m {
+		m[i] = make([]float64, d)
+		m[i][i] = 1
+	}
+	return m
+}
@@ -0,0 +1,184 @@
+package gmm
+
+import (
+	"fmt"
+	"math"
+	"rfm_cluster/pkg/clusters"
+)
+
+// Model is a fitted gaussian mixture
+type Model struct {
+	Covariance  Covariance
+	Weights     []float64
+	Means       []clusters.Coordinates
+	Covariances [][][]float64
+	// Probabilities holds for every observation of the fitted data set the
+	// probability of belonging to each component
+	Probabilities [][]float64
+	// LogLikelihood of the fitted data set
+	LogLikelihood float64
+	// Samples is the size of the fitted data set
+	Samples    int
+	Iterations int
+	Converged  bool
+
+	// cholesky factors and log determinants of the covariances
+	factors [][][]float64
+	logDets []float64
+}
+
+// Parameters returns the number of free parameters of the model
+func (m *Model) Parameters() int {
+	k := len(m.Weights)
+	if k == 0 {
+		return 0
+	}
+	d := len(m.Means[0])
+
+	covariance := d * (d + 1) / 2
+	if m.Covariance == Diagonal {
+		covariance = d
+	}
+	return k - 1 + k*d + k*covariance
+}
+
+// BIC returns the bayesian information criterion, lower is better
+func (m *Model) BIC() float64 {
+	return -2*m.LogLikelihood + float64(m.Parameters())*math.Log(float64(m.Samples))
+}
+
+// AIC returns the akaike information criterion, lower is better
+func (m *Model) AIC() float64 {
+	return -2*m.LogLikelihood + 2*float64(m.Parameters())
+}
+
+// Clusters assigns every observation of the fitted data set to its most
+// probable component. The center of every cluster is the component mean,
+// components without observations stay empty so indices match the model.
+func (m *Model) Clusters(dataset clusters.Observations) clusters.Clusters {
+	cc := make(clusters.Clusters, len(m.Weights))
+	for c := range cc {
+		cc[c].Center = m.Means[c]
+	}
+	for i, o := range dataset {
+		best := 0
+		for c, p := range m.Probabilities[i] {
+			if p > m.Probabilities[i][best] {
+				best = c
+			}
+		}
+		cc[best].Append(o)
+	}
+	return cc
+}
+
+// Predict returns the probabilities of o belonging to each component
+func (m *Model) Predict(o clusters.Observation) ([]float64, error) {
+	if m.factors == nil {
+		if err := m.factorize(); err != nil {
+			return nil, err
+		}
+	}
+
+	p := make([]float64, len(m.Weights))
+	m.posterior(o.Coordinates(), p)
+	return p, nil
+}
+
+// factorize computes the cholesky factors of all covariance matrices
+func (m *Model) factorize() error {
+	m.factors = make([][][]float64, len(m.Covariances))
+	m.logDets = make([]float64, len(m.Covariances))
+	for c, cov := range m.Covariances {
+		l, err := cholesky(cov)
+		if err != nil {
+			return fmt.Errorf("component %d: %w", c, err)
+		}
+		m.factors[c] = l
+		for i := range l {
+			m.logDets[c] += 2 * math.Log(l[i][i])
+		}
+	}
+	return nil
+}
+
+// expect fills resp with the posterior probabilities of every observation
+// and returns the log-likelihood of the data set
+func (m *Model) expect(x []clusters.Coordinates, resp [][]float64) float64 {
+	var ll float64
+	for i := range x {
+		ll += m.posterior(x[i], resp[i])
+	}
+	return ll
+}
+
+// posterior fills p with the component probabilities of x and returns the
+// log density of x
+func (m *Model) posterior(x clusters.Coordinates, p []float64) float64 {
+	d := float64(len(x))
+	maxLog := math.Inf(-1)
+	for c := range p {
+		if m.Weights[c] == 0 {
+			p[c] = math.Inf(-1)
+			continue
+		}
+		maha := mahalanobis(m.factors[c], x, m.Means[c])
+		p[c] = math.Log(m.Weights[c]) - 0.5*(d*math.Log(2*math.Pi)+m.logDets[c]+maha)
+		maxLog = math.Max(maxLog, p[c])
+	}
+
+	// log-sum-exp
+	var sum float64
+	for c := range p {
+		p[c] = math.Exp(p[c] - maxLog)
+		sum += p[c]
+	}
+	for c := range p {
+		p[c] /= sum
+	}
+	return maxLog + math.Log(sum)
+}
+
+// cholesky returns the lower triangular l with l*l^T = a
+func cholesky(a [][]float64) ([][]float64, error) {
+	n := len(a)
+	l := make([][]float64, n)
+	for i := range l {
+		l[i] = make([]float64, n)
+	}
+
+	for i := 0; i < n; i++ {
+		for j := 0; j <= i; j++ {
+			sum := a[i][j]
+			for k := 0; k < j; k++ {
+				sum -= l[i][k] * l[j][k]
+			}
+			if i == j {
+				if sum <= 0 {
+					return nil, fmt.Errorf("covariance matrix is not positive definite")
+				}
+				l[i][i] = math.Sqrt(sum)
+			} else {
+				l[i][j] = sum / l[j][j]
+			}
+		}
+	}
+	return l, nil
+}
+
+// mahalanobis returns the squared mahalanobis distance of x to mean, given
+// the cholesky factor l of the covariance matrix
+func mahalanobis(l [][]float64, x, mean clusters.Coordinates) float64 {
+	// forward substitution of l*y = x-mean
+	y := make([]float64, len(x))
+	var r float64
+	for i := range x {
+		sum := x[i] - mean[i]
+		for k := 0; k < i; k++ {
+			sum -= l[i][k] * y[k]
+		}
+		y[i] = sum / l[i][i]
+		r += y[i] * y[i]
+	}
+	return r
+}
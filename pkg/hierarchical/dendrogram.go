@@ -0,0 +1,140 @@
+package hierarchical
+
+import (
+	"fmt"
+	"rfm_cluster/pkg/clusters"
+	"slices"
+)
+
+// Merge joins the nodes Left and Right at Height. Nodes below the number of
+// leaves are leaves, node len(Leaves)+i is the result of merge i.
+type Merge struct {
+	Left   int
+	Right  int
+	Height float64
+	// Size is the number of observations in the merged node
+	Size int
+}
+
+// Dendrogram is a complete cluster tree. Merges are ordered by height, the
+// last merge is the root.
+type Dendrogram struct {
+	// Leaves are single observations, or k-means micro-clusters for large
+	// data sets
+	Leaves []clusters.Observations
+	Merges []Merge
+}
+
+// Node is a node of a (truncated) cluster tree
+type Node struct {
+	ID           int
+	Height       float64
+	Observations clusters.Observations
+	Children     []*Node
+}
+
+// Cut returns the k clusters which are left after undoing the last k-1
+// merges, centered on their means
+func (d *Dendrogram) Cut(k int) (clusters.Clusters, error) {
+	roots, err := d.Roots(k)
+	if err != nil {
+		return clusters.Clusters{}, err
+	}
+
+	cc := make(clusters.Clusters, len(roots))
+	for i, root := range roots {
+		cc[i].Observations = d.observations(root)
+	}
+	cc.Recenter()
+	return cc, nil
+}
+
+// Top returns the root of the tree truncated to k leaves, the nodes which
+// are left when cutting the tree at k. The children of every node are the
+// two nodes it was merged from.
+func (d *Dendrogram) Top(k int) (*Node, error) {
+	roots, err := d.Roots(k)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := map[int]*Node{}
+	for _, id := range roots {
+		nodes[id] = &Node{ID: id, Observations: d.observations(id)}
+	}
+
+	n := len(d.Leaves)
+	for i := n - k; i < len(d.Merges); i++ {
+		merge := d.Merges[i]
+		left, right := nodes[merge.Left], nodes[merge.Right]
+		nodes[n+i] = &Node{
+			ID:           n + i,
+			Height:       merge.Height,
+			Observations: append(append(clusters.Observations{}, left.Observations...), right.Observations...),
+			Children:     []*Node{left, right},
+		}
+	}
+
+	if len(d.Merges) == 0 {
+		return nodes[0], nil
+	}
+	return nodes[n+len(d.Merges)-1], nil
+}
+
+// Roots returns the ids of the k nodes left after undoing the last k-1
+// merges, in the order of their first leaf. The i-th root holds the
+// observations of the i-th cluster returned by Cut.
+func (d *Dendrogram) Roots(k int) ([]int, error) {
+	n := len(d.Leaves)
+	if k < 1 {
+		return nil, fmt.Errorf("k must be greater than 0")
+	}
+	if k > n {
+		return nil, fmt.Errorf("the tree has %d leaves, it can not be cut into %d clusters", n, k)
+	}
+
+	root := make([]int, n)
+	for i := range root {
+		root[i] = i
+	}
+	for i := 0; i < n-k; i++ {
+		// every merged node gets the smallest leaf index of its children
+		merge := d.Merges[i]
+		root = append(root, min(root[merge.Left], root[merge.Right]))
+	}
+
+	// a node is a root if it is not merged any further
+	merged := make([]bool, n+n-k)
+	for i := 0; i < n-k; i++ {
+		merged[d.Merges[i].Left] = true
+		merged[d.Merges[i].Right] = true
+	}
+
+	var ids []int
+	for id := 0; id < n+n-k; id++ {
+		if !merged[id] {
+			ids = append(ids, id)
+		}
+	}
+
+	// order by the first leaf for a stable cluster order
+	slices.SortFunc(ids, func(a, b int) int { return root[a] - root[b] })
+	return ids, nil
+}
+
+// observations collects the observations of all leaves below node id
+func (d *Dendrogram) observations(id int) clusters.Observations {
+	n := len(d.Leaves)
+	var result clusters.Observations
+	stack := []int{id}
+	for len(stack) > 0 {
+		x := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if x < n {
+			result = append(result, d.Leaves[x]...)
+			continue
+		}
+		stack = append(stack, d.Merges[x-n].Right, d.Merges[x-n].Left)
+	}
+	return result
+}
@@ -0,0 +1,299 @@
+// Package hierarchical implements agglomerative hierarchical clustering. Every
+// observation starts in a cluster of its own and the two closest clusters are
+// merged until a single one is left; cutting the resulting tree yields any
+// number of clusters. Large data sets are first reduced to k-means
+// micro-clusters, which are then merged instead of single observations.
+// See: https://en.wikipedia.org/wiki/Hierarchical_clustering
+package hierarchical
+
+import (
+	"fmt"
+	"math"
+	"rfm_cluster/pkg/clusters"
+	"rfm_cluster/pkg/kmeans"
+	"slices"
+	"sync"
+)
+
+// Linkage decides the distance between two clusters
+type Linkage string
+
+// Supported linkage criteria. Distances are measured with the metric of the
+// observations, except for Ward which always uses the squared euclidean
+// distance.
+const (
+	// Ward merges the clusters which increase the total within-cluster
+	// variance the least
+	Ward Linkage = "ward"
+	// Complete uses the distance of the farthest pair of observations
+	Complete Linkage = "complete"
+	// Average uses the mean distance of all pairs of observations
+	Average Linkage = "average"
+	// Single uses the distance of the closest pair of observations
+	Single Linkage = "single"
+)
+
+// Linkages returns all supported linkage criteria
+func Linkages() []Linkage {
+	return []Linkage{Ward, Complete, Average, Single}
+}
+
+// Hierarchical configuration/option struct
+type Hierarchical struct {
+	linkage Linkage
+	// microThreshold is the data set size above which the observations are
+	// reduced to microClusters k-means clusters before merging
+	microThreshold int
+	microClusters  int
+	// seed makes the k-means micro-clusters reproducible
+	seed int64
+	// cache keeps the last tree, so that cutting the same data set at
+	// several values of k builds it only once
+	cache *treeCache
+}
+
+type treeCache struct {
+	sync.Mutex
+	dataset clusters.Observations
+	tree    *Dendrogram
+}
+
+// NewWithOptions returns a Hierarchical configuration struct with custom
+// settings
+func NewWithOptions(linkage Linkage, microThreshold, microClusters int) (Hierarchical, error) {
+	if !slices.Contains(Linkages(), linkage) {
+		return Hierarchical{}, fmt.Errorf("unknown linkage %q", linkage)
+	}
+	if microThreshold < 1 {
+		return Hierarchical{}, fmt.Errorf("micro-cluster threshold must be at least 1")
+	}
+	if microClusters < 2 || microClusters > microThreshold {
+		return Hierarchical{}, fmt.Errorf("micro-clusters must be between 2 and the micro-cluster threshold")
+	}
+
+	return Hierarchical{
+		linkage:        linkage,
+		microThreshold: microThreshold,
+		microClusters:  microClusters,
+		cache:          &treeCache{},
+	}, nil
+}
+
+// New returns a Hierarchical configuration struct with default settings
+func New(linkage Linkage) (Hierarchical, error) {
+	return NewWithOptions(linkage, 2000, 250)
+}
+
+// WithSeed returns a copy of the configuration whose k-means micro-clusters
+// are derived from seed. A seed of 0 restores the time based default.
+func (m Hierarchical) WithSeed(seed int64) Hierarchical {
+	m.seed = seed
+	m.cache = &treeCache{}
+	return m
+}
+
+// Partition builds the cluster tree of the given dataset and cuts it into k
+// clusters, centered on their means
+func (m Hierarchical) Partition(dataset clusters.Observations, k int) (clusters.Clusters, error) {
+	tree, err := m.CachedTree(dataset)
+	if err != nil {
+		return clusters.Clusters{}, err
+	}
+	return tree.Cut(k)
+}
+
+// CachedTree returns the tree Partition cuts the given dataset from. It is
+// only built if Partition has not seen the same dataset before, so it is the
+// tree the clusters already returned were cut from.
+func (m Hierarchical) CachedTree(dataset clusters.Observations) (*Dendrogram, error) {
+	if m.cache == nil {
+		return m.Tree(dataset)
+	}
+
+	m.cache.Lock()
+	defer m.cache.Unlock()
+	if m.cache.tree != nil && len(m.cache.dataset) == len(dataset) && &m.cache.dataset[0] == &dataset[0] {
+		return m.cache.tree, nil
+	}
+
+	tree, err := m.Tree(dataset)
+	if err != nil {
+		return nil, err
+	}
+	m.cache.dataset, m.cache.tree = dataset, tree
+	return tree, nil
+}
+
+// Tree builds the complete cluster tree of the given dataset
+func (m Hierarchical) Tree(dataset clusters.Observations) (*Dendrogram, error) {
+	if len(dataset) == 0 {
+		return nil, fmt.Errorf("the data set must not be empty")
+	}
+
+	leaves := make([]clusters.Observations, len(dataset))
+	for i, o := range dataset {
+		leaves[i] = clusters.Observations{o}
+	}
+
+	if len(dataset) > m.microThreshold {
+		km, err := kmeans.NewWithOptions(0.01, nil)
+		if err != nil {
+			return nil, err
+		}
+		cc, err := km.WithSeed(m.seed).Partition(dataset, m.microClusters)
+		if err != nil {
+			return nil, err
+		}
+
+		leaves = leaves[:0]
+		for _, c := range cc {
+			if len(c.Observations) > 0 {
+				leaves = append(leaves, c.Observations)
+			}
+		}
+	}
+
+	return &Dendrogram{Leaves: leaves, Merges: m.merge(leaves)}, nil
+}
+
+// merge runs the nearest-neighbour chain algorithm on the leaves and returns
+// the merges ordered by height. Leaves are represented by their centers,
+// weighted by the total weight of their observations. Distances are only
+// measured between the centers, the observations are not touched again.
+func (m Hierarchical) merge(leaves []clusters.Observations) []Merge {
+	n := len(leaves)
+	if n < 2 {
+		return nil
+	}
+
+	centers := make([]clusters.Coordinates, n)
+	size := make([]float64, n)
+	for i, l := range leaves {
+		centers[i], _ = l.Center()
+		size[i] = l.Weight()
+	}
+
+	// condensed distance matrix
+	dist := make([]float64, n*(n-1)/2)
+	at := func(i, j int) int {
+		if i > j {
+			i, j = j, i
+		}
+		return n*i - i*(i+1)/2 + j - i - 1
+	}
+	// Ward is defined on squared euclidean distances, the other linkages
+	// measure with the metric of the observations
+	metric := clusters.MetricOf(leaves[0][0])
+	if m.linkage == Ward {
+		metric = clusters.SquaredEuclidean{}
+	}
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			d := metric.Distance(centers[i], centers[j])
+			if m.linkage == Ward {
+				// Ward distance of two clusters; 1*d for single observations
+				ni, nj := size[i], size[j]
+				d = 2 * ni * nj / (ni + nj) * d
+			}
+			dist[at(i, j)] = d
+		}
+	}
+
+	type step struct {
+		a, b   int
+		height float64
+	}
+	steps := make([]step, 0, n-1)
+
+	active := make([]bool, n)
+	for i := range active {
+		active[i] = true
+	}
+
+	chain := make([]int, 0, n)
+	for len(steps) < n-1 {
+		if len(chain) == 0 {
+			chain = append(chain, slices.Index(active, true))
+		}
+
+		a := chain[len(chain)-1]
+		b, bd := -1, math.Inf(1)
+		if len(chain) > 1 {
+			// prefer the previous element on ties, otherwise the chain
+			// could cycle
+			b = chain[len(chain)-2]
+			bd = dist[at(a, b)]
+		}
+		for j := 0; j < n; j++ {
+			if active[j] && j != a && dist[at(a, j)] < bd {
+				b, bd = j, dist[at(a, j)]
+			}
+		}
+
+		if len(chain) < 2 || b != chain[len(chain)-2] {
+			chain = append(chain, b)
+			continue
+		}
+
+		// a and b are reciprocal nearest neighbours, merge b into a
+		chain = chain[:len(chain)-2]
+		steps = append(steps, step{a: a, b: b, height: bd})
+
+		na, nb := size[a], size[b]
+		for k := 0; k < n; k++ {
+			if !active[k] || k == a || k == b {
+				continue
+			}
+			dak, dbk := dist[at(a, k)], dist[at(b, k)]
+			var d float64
+			switch m.linkage {
+			case Single:
+				d = math.Min(dak, dbk)
+			case Complete:
+				d = math.Max(dak, dbk)
+			case Average:
+				d = (na*dak + nb*dbk) / (na + nb)
+			case Ward:
+				nk := size[k]
+				d = ((na+nk)*dak + (nb+nk)*dbk - nk*bd) / (na + nb + nk)
+			}
+			dist[at(a, k)] = d
+		}
+		active[b] = false
+		size[a] += size[b]
+	}
+
+	slices.SortStableFunc(steps, func(x, y step) int {
+		if x.height < y.height {
+			return -1
+		} else if x.height > y.height {
+			return 1
+		}
+		return 0
+	})
+
+	// relabel: leaves keep their index, merge i becomes node n+i
+	parent := make([]int, n)
+	node := make([]int, n)
+	members := make([]int, n)
+	for i := range parent {
+		parent[i], node[i], members[i] = i, i, len(leaves[i])
+	}
+	find := func(x int) int {
+		for parent[x] != x {
+			parent[x] = parent[parent[x]]
+			x = parent[x]
+		}
+		return x
+	}
+
+	merges := make([]Merge, len(steps))
+	for i, s := range steps {
+		ra, rb := find(s.a), find(s.b)
+		merges[i] = Merge{Left: node[ra], Right: node[rb], Height: s.height, Size: members[ra] + members[rb]}
+		parent[rb] = ra
+		node[ra] = n + i
+		members[ra] += members[rb]
+	}
+	return merges
+}
@@ -0,0 +1,119 @@
+package kmeans
+
+import (
+	"fmt"
+	"math"
+	"rfm_cluster/pkg/clusters"
+	"rfm_cluster/pkg/parallel"
+)
+
+// Config holds the stopping criteria of Partition. Partition stops after the
+// first iteration that meets any of them.
+type Config struct {
+	// MaxIterations is the largest number of iterations
+	MaxIterations int
+	// MovedThreshold (between 0.0 and 1.0) stops when less than this share of
+	// the observations' weight changed clusters, 0 disables the criterion
+	MovedThreshold float64
+	// ShiftTolerance stops when no center moved farther than this euclidean
+	// distance, 0 disables the criterion
+	ShiftTolerance float64
+	// InertiaTolerance stops when the inertia improved by less than this
+	// share of the previous inertia, 0 disables the criterion
+	InertiaTolerance float64
+	// Plotter gets called after each iteration when set
+	Plotter Plotter
+}
+
+// DefaultConfig returns the criteria of New: at most 96 iterations, stopping
+// when less than 1% of the observations moved
+func DefaultConfig() Config {
+	return Config{
+		MaxIterations:  96,
+		MovedThreshold: 0.01,
+	}
+}
+
+// Validate reports whether the criteria are usable
+func (c Config) Validate() error {
+	if c.MaxIterations < 1 {
+		return fmt.Errorf("max iterations must be at least 1")
+	}
+	if c.MovedThreshold < 0.0 || c.MovedThreshold >= 1.0 {
+		return fmt.Errorf("moved threshold is out of bounds (must be >=0.0 and <1.0, in percent)")
+	}
+	if c.ShiftTolerance < 0 {
+		return fmt.Errorf("shift tolerance must not be negative")
+	}
+	if c.InertiaTolerance < 0 {
+		return fmt.Errorf("inertia tolerance must not be negative")
+	}
+	return nil
+}
+
+// Reason tells why Partition stopped iterating
+type Reason string
+
+const (
+	// StopConverged means no observation changed clusters in the last
+	// iteration
+	StopConverged Reason = "converged"
+	// StopMoved means less than Config.MovedThreshold of the observations
+	// changed clusters
+	StopMoved Reason = "moved"
+	// StopShift means no center moved farther than Config.ShiftTolerance
+	StopShift Reason = "shift"
+	// StopInertia means the inertia improved by less than
+	// Config.InertiaTolerance
+	StopInertia Reason = "inertia"
+	// StopMaxIterations means Config.MaxIterations was reached first
+	StopMaxIterations Reason = "max_iterations"
+)
+
+// Report describes how Partition converged
+type Report struct {
+	// Iterations is the number of iterations run
+	Iterations int `json:"iterations"`
+	// Reason is the first criterion met in the last iteration
+	Reason Reason `json:"reason"`
+	// Shift is the largest euclidean distance a center moved in the last
+	// iteration
+	Shift float64 `json:"shift"`
+	// Inertia is the weighted sum of the distances of the observations to
+	// their centers, measured with the metric of the observations. It is the
+	// sum of squared errors for the default metric.
+	Inertia float64 `json:"inertia"`
+}
+
+// inertia returns the weighted sum of the distances of the rows to their
+// centers. Chunks are summed on their own and added in order like in
+// recenter.
+func (m Kmeans) inertia(x *clusters.Matrix, centers []clusters.Coordinates, assigned []int, extra [][]int) float64 {
+	partial := make([]float64, chunks(x.Rows))
+	parallel.Default.Run(m.workers, len(partial), func(c int) {
+		for p := c * chunkSize; p < min((c+1)*chunkSize, x.Rows); p++ {
+			partial[c] += x.Weight(p) * x.Distance(p, centers[assigned[p]])
+		}
+	})
+
+	var result float64
+	for _, v := range partial {
+		result += v
+	}
+	for ci, ee := range extra {
+		for _, p := range ee {
+			result += x.Weight(p) * x.Distance(p, centers[ci])
+		}
+	}
+	return result
+}
+
+// shift returns the largest euclidean distance between the old and the new
+// position of a center
+func shift(old, centers []clusters.Coordinates) float64 {
+	var result float64
+	for ci := range centers {
+		result = math.Max(result, clusters.Euclidean{}.Distance(old[ci], centers[ci]))
+	}
+	return result
+}
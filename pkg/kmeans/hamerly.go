@@ -0,0 +1,149 @@
+package kmeans
+
+import (
+	"math"
+	"rfm_cluster/pkg/clusters"
+)
+
+// Algorithm selects how Partition finds the nearest center of every
+// observation in each iteration
+type Algorithm string
+
+const (
+	// Lloyd computes the distance of every observation to every center
+	Lloyd Algorithm = "lloyd"
+	// Hamerly keeps an upper bound on the distance to the assigned center and
+	// a lower bound on the distance to every other center, and skips the
+	// distance computations the triangle inequality proves unnecessary. The
+	// assignments are identical to Lloyd's.
+	// See: Hamerly, "Making k-means even faster" (2010)
+	Hamerly Algorithm = "hamerly"
+)
+
+// Algorithms returns all supported algorithms
+func Algorithms() []Algorithm {
+	return []Algorithm{Lloyd, Hamerly}
+}
+
+// slack widens the bounds by a relative margin, so that rounding errors of
+// the square roots never prune a point whose nearest center is tied or closer
+const slack = 1e-9
+
+// hamerly holds the bounds of every observation between iterations. The
+// bounds are the square roots of Matrix.Distance, which satisfy the
+// triangle inequality for every supported metric, squared or not.
+type hamerly struct {
+	// metric measures the distances between centers like the observations
+	// measure theirs
+	metric clusters.Metric
+	// upper bounds the distance to the assigned center, +Inf when unknown
+	upper []float64
+	// lower bounds the distance to the second closest center
+	lower []float64
+	// assigned is the center the bounds belong to
+	assigned []int
+	// centers are the centers of the previous iteration
+	centers []clusters.Coordinates
+	// half is half of the distance of every center to its closest other
+	// center
+	half []float64
+}
+
+func newHamerly(x *clusters.Matrix) *hamerly {
+	n := x.Rows
+	h := &hamerly{
+		metric:   x.Metric,
+		upper:    make([]float64, n),
+		lower:    make([]float64, n),
+		assigned: make([]int, n),
+	}
+	for p := range h.upper {
+		h.upper[p] = math.Inf(1)
+	}
+	return h
+}
+
+// update moves the bounds along with the centers, which may have moved since
+// the last call
+func (h *hamerly) update(centers []clusters.Coordinates) {
+	k := len(centers)
+	if h.centers != nil {
+		drift := make([]float64, k)
+		// the largest and second largest drift, the lower bound of a point
+		// moves by the largest drift of any center but its own
+		first, second := -1, -1
+		for j := range centers {
+			drift[j] = math.Sqrt(h.metric.Distance(centers[j], h.centers[j]))
+			if first < 0 || drift[j] > drift[first] {
+				first, second = j, first
+			} else if second < 0 || drift[j] > drift[second] {
+				second = j
+			}
+		}
+		for p := range h.upper {
+			a := h.assigned[p]
+			h.upper[p] += drift[a]
+			if a != first {
+				h.lower[p] -= drift[first]
+			} else if second >= 0 {
+				h.lower[p] -= drift[second]
+			}
+		}
+	}
+
+	h.centers = make([]clusters.Coordinates, k)
+	for j := range centers {
+		h.centers[j] = append(clusters.Coordinates(nil), centers[j]...)
+	}
+	h.half = make([]float64, k)
+	for j := range centers {
+		h.half[j] = math.Inf(1)
+		for i := range centers {
+			if i != j {
+				h.half[j] = math.Min(h.half[j], math.Sqrt(h.metric.Distance(centers[j], centers[i]))/2)
+			}
+		}
+	}
+}
+
+// nearest returns the index of the center nearest to the row p, exactly as
+// x.Nearest would
+func (h *hamerly) nearest(x *clusters.Matrix, centers []clusters.Coordinates, p int) int {
+	a := h.assigned[p]
+	bound := math.Max(h.half[a], h.lower[p]) * (1 - slack)
+	if h.upper[p] < bound {
+		return a
+	}
+	// tighten the upper bound and try again
+	h.upper[p] = math.Sqrt(x.Distance(p, centers[a]))
+	if h.upper[p] < bound {
+		return a
+	}
+
+	// compare the squared distances like x.Nearest, so ties resolve the same
+	ci := -1
+	first, second := 0.0, math.Inf(1)
+	for j := range centers {
+		d := x.Distance(p, centers[j])
+		if ci < 0 || d < first {
+			if ci >= 0 {
+				second = first
+			}
+			ci, first = j, d
+		} else if d < second {
+			second = d
+		}
+	}
+	h.assigned[p] = ci
+	h.upper[p] = math.Sqrt(first) * (1 + slack)
+	h.lower[p] = math.Sqrt(second) * (1 - slack)
+	return ci
+}
+
+// move records that the observation p was moved to the center ci outside of
+// nearest, its bounds are no longer known
+func (h *hamerly) move(p, ci int) {
+	h.assigned[p] = ci
+	h.upper[p] = math.Inf(1)
+	h.lower[p] = 0
+}
@@ -0,0 +1,164 @@
+package kmeans
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"rfm_cluster/pkg/clusters"
+)
+
+// Initialization selects how Partition places the initial centers
+type Initialization string
+
+const (
+	// Random draws the centers uniformly within the bounding box of the data
+	// set, see clusters.NewInBounds
+	Random Initialization = "random"
+	// KmeansPP picks observations as centers with a probability proportional
+	// to their distance to the centers picked so far. It is the default.
+	// See: Arthur and Vassilvitskii, "k-means++: the advantages of careful
+	// seeding" (2007)
+	KmeansPP Initialization = "kmeanspp"
+	// KmeansParallel oversamples candidates in a few passes over the data and
+	// reduces them to k centers with k-means++, which needs far fewer passes
+	// than k-means++ on large data sets.
+	// See: Bahmani et al., "Scalable k-means++" (2012)
+	KmeansParallel Initialization = "kmeansparallel"
+	// FarthestFirst picks a random observation, then always the observation
+	// farthest from the centers picked so far
+	FarthestFirst Initialization = "farthest"
+)
+
+// Initializations returns all supported initializations
+func Initializations() []Initialization {
+	return []Initialization{Random, KmeansPP, KmeansParallel, FarthestFirst}
+}
+
+// The parameters of k-means||: every round samples about oversampling*k
+// candidates
+const (
+	parallelRounds       = 5
+	parallelOversampling = 2
+)
+
+// WithInitialization returns a copy of the configuration that places the
+// initial centers with the given initialization
+func (m Kmeans) WithInitialization(initialization Initialization) Kmeans {
+	m.initialization = initialization
+	return m
+}
+
+// WithCenters returns a copy of the configuration that starts from the given
+// centers, for example business defined archetypes. Partitioning into fewer
+// clusters uses the first k centers, the centers missing for more clusters
+// are picked by k-means++. The initialization is ignored then.
+func (m Kmeans) WithCenters(centers []clusters.Coordinates) Kmeans {
+	m.centers = centers
+	return m
+}
+
+// initialize places the initial centers of k clusters
+func (m Kmeans) initialize(k int, x *clusters.Matrix, r *rand.Rand) (clusters.Clusters, error) {
+	if len(m.centers) > 0 {
+		for _, c := range m.centers {
+			if len(c) != x.Stride {
+				return nil, fmt.Errorf("the centers must have %d dimensions", x.Stride)
+			}
+		}
+		return initializeClustersKmeansPP(k, x, r, m.centers...)
+	}
+
+	switch m.initialization {
+	case Random:
+		if k > x.Rows {
+			return clusters.Clusters{}, fmt.Errorf("the size of the data set must at least equal k")
+		}
+		return clusters.NewInBounds(k, x.Observations(), r)
+	case KmeansParallel:
+		return initializeKmeansParallel(k, x, r)
+	case FarthestFirst:
+		return initializeFarthestFirst(k, x, r)
+	case KmeansPP, "":
+		return initializeClustersKmeansPP(k, x, r)
+	}
+	return nil, fmt.Errorf("unknown initialization %q", m.initialization)
+}
+
+// initializeKmeansParallel picks candidates with k-means||, weights every
+// candidate by the observations nearest to it and picks k centers among the
+// candidates with k-means++
+func initializeKmeansParallel(k int, x *clusters.Matrix, r *rand.Rand) (clusters.Clusters, error) {
+	if k > x.Rows {
+		return clusters.Clusters{}, fmt.Errorf("the size of the data set must at least equal k")
+	}
+
+	candidates := []int{firstCenter(x, r)}
+	minDist := make([]float64, x.Rows)
+	for j := range minDist {
+		minDist[j] = x.Distance(j, x.Row(candidates[0]))
+	}
+	cost := func() float64 {
+		var result float64
+		for j, d := range minDist {
+			result += x.Weight(j) * d
+		}
+		return result
+	}
+
+	for round, psi := 0, cost(); round < parallelRounds && psi > 0; round, psi = round+1, cost() {
+		var picked []int
+		for j, d := range minDist {
+			if r.Float64() < parallelOversampling*float64(k)*x.Weight(j)*d/psi {
+				picked = append(picked, j)
+			}
+		}
+		for _, c := range picked {
+			for j := range minDist {
+				minDist[j] = math.Min(minDist[j], x.Distance(j, x.Row(c)))
+			}
+		}
+		candidates = append(candidates, picked...)
+	}
+	if len(candidates) < k {
+		// too few distinct observations were sampled
+		return initializeClustersKmeansPP(k, x, r)
+	}
+
+	reduced := clusters.NewMatrix(len(candidates), x.Stride, x.Metric)
+	reduced.Weights = make([]float64, len(candidates))
+	centers := make([]clusters.Coordinates, len(candidates))
+	for i, c := range candidates {
+		copy(reduced.Row(i), x.Row(c))
+		centers[i] = reduced.Row(i)
+	}
+	for j := 0; j < x.Rows; j++ {
+		reduced.Weights[x.Nearest(j, centers)] += x.Weight(j)
+	}
+	return initializeClustersKmeansPP(k, reduced, r)
+}
+
+// initializeFarthestFirst picks a random first center and then the
+// observation with the largest distance to its nearest center, k-1 times
+func initializeFarthestFirst(k int, x *clusters.Matrix, r *rand.Rand) (clusters.Clusters, error) {
+	if k > x.Rows {
+		return clusters.Clusters{}, fmt.Errorf("the size of the data set must at least equal k")
+	}
+
+	cc := make(clusters.Clusters, k)
+	cc[0].Center = append(clusters.Coordinates(nil), x.Row(firstCenter(x, r))...)
+	minDist := make([]float64, x.Rows)
+	for j := range minDist {
+		minDist[j] = math.MaxFloat64
+	}
+	for i := 1; i < k; i++ {
+		farthest := 0
+		for j := range minDist {
+			minDist[j] = math.Min(minDist[j], x.Distance(j, cc[i-1].Center))
+			if minDist[j] > minDist[farthest] {
+				farthest = j
+			}
+		}
+		cc[i].Center = append(clusters.Coordinates(nil), x.Row(farthest)...)
+	}
+	return cc, nil
+}
@@ -7,19 +7,29 @@ import (
 	"math"
 	"math/rand"
 	"rfm_cluster/pkg/clusters"
+	"rfm_cluster/pkg/parallel"
 	"time"
 )
 
 // Kmeans configuration/option struct
 type Kmeans struct {
-	// when a plotter is set, Plot gets called after each iteration
-	plotter Plotter
-	// deltaThreshold (in percent between 0.0 and 0.1) aborts processing if
-	// less than n% of data points shifted clusters in the last iteration
-	deltaThreshold float64
-	// iterationThreshold aborts processing when the specified amount of
-	// algorithm iterations was reached
-	iterationThreshold int
+	// config holds the stopping criteria
+	config Config
+	// seed makes the random choices of Partition reproducible, 0 seeds from
+	// the current time
+	seed int64
+	// algorithm finds the nearest center of every observation, Lloyd unless
+	// set otherwise
+	algorithm Algorithm
+	// initialization places the initial centers, k-means++ unless set
+	// otherwise
+	initialization Initialization
+	// centers are user supplied initial centers, they take precedence over
+	// the initialization
+	centers []clusters.Coordinates
+	// workers is the largest number of goroutines an iteration uses, as far
+	// as the budget of parallel.Default allows
+	workers int
 }
 
 // The Plotter interface lets you implement your own plotters
@@ -27,17 +37,25 @@ type Plotter interface {
 	Plot(cc clusters.Clusters, iteration int) error
 }
 
+// NewWithConfig returns a Kmeans configuration struct with the given stopping
+// criteria
+func NewWithConfig(config Config) (Kmeans, error) {
+	if err := config.Validate(); err != nil {
+		return Kmeans{}, err
+	}
+	return Kmeans{config: config}, nil
+}
+
 // NewWithOptions returns a Kmeans configuration struct with custom settings
 func NewWithOptions(deltaThreshold float64, plotter Plotter) (Kmeans, error) {
 	if deltaThreshold <= 0.0 || deltaThreshold >= 1.0 {
 		return Kmeans{}, fmt.Errorf("threshold is out of bounds (must be >0.0 and <1.0, in percent)")
 	}
 
-	return Kmeans{
-		plotter:            plotter,
-		deltaThreshold:     deltaThreshold,
-		iterationThreshold: 96,
-	}, nil
+	config := DefaultConfig()
+	config.MovedThreshold = deltaThreshold
+	config.Plotter = plotter
+	return NewWithConfig(config)
 }
 
 // New returns a Kmeans configuration struct with default settings
@@ -46,41 +64,79 @@ func New() Kmeans {
 	return m
 }
 
-// initializeClustersKmeansPP 使用k-means++算法初始化聚类中心
-func initializeClustersKmeansPP(k int, dataset clusters.Observations) (clusters.Clusters, error) {
-	if k > len(dataset) {
+// WithSeed returns a copy of the configuration whose random choices are
+// derived from seed, so that partitioning the same data set twice yields the
+// same clusters. A seed of 0 restores the time based default.
+func (m Kmeans) WithSeed(seed int64) Kmeans {
+	m.seed = seed
+	return m
+}
+
+// WithAlgorithm returns a copy of the configuration that finds the nearest
+// centers with the given algorithm. All algorithms yield the same clusters
+// for the same seed, they only differ in speed.
+func (m Kmeans) WithAlgorithm(algorithm Algorithm) Kmeans {
+	m.algorithm = algorithm
+	return m
+}
+
+// WithWorkers returns a copy of the configuration that spreads the
+// assignment and the recentering of every iteration over up to workers
+// goroutines. The clusters do not depend on the number of workers.
+func (m Kmeans) WithWorkers(workers int) Kmeans {
+	m.workers = workers
+	return m
+}
+
+// random returns the random source used for partitioning into k clusters.
+// Every k gets its own source, so concurrent partitions stay reproducible.
+func (m Kmeans) random(k int) *rand.Rand {
+	if m.seed == 0 {
+		return rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return rand.New(rand.NewSource(m.seed + int64(k)))
+}
+
+// initializeClustersKmeansPP 使用k-means++算法初始化聚类中心，中心是数据的副本。
+// 给定了中心时以给定的中心开始，只选择其余的中心
+func initializeClustersKmeansPP(k int, x *clusters.Matrix, r *rand.Rand, given ...clusters.Coordinates) (clusters.Clusters, error) {
+	if k > x.Rows {
 		return clusters.Clusters{}, fmt.Errorf("the size of the data set must at least equal k")
 	}
 
 	// 创建k个空集群
 	cc := make(clusters.Clusters, k)
 
-	source := rand.NewSource(time.Now().UnixNano())
-	r := rand.New(source)
+	n := min(len(given), k)
+	for i := 0; i < n; i++ {
+		cc[i].Center = append(clusters.Coordinates(nil), given[i]...)
+	}
+	if n == 0 {
+		// 随机选择第一个聚类中心
+		cc[0].Center = append(clusters.Coordinates(nil), x.Row(firstCenter(x, r))...)
+		n = 1
+	}
 
-	// 随机选择第一个聚类中心
-	firstCenterIdx := r.Intn(len(dataset))
-	// 固定一个中心
-	// firstCenterIdx := len(dataset) / 2
-	cc[0].Center = dataset[firstCenterIdx].Coordinates()
+	// 每个点到最近聚类中心的距离的平方，每加入一个中心更新一次
+	minDist := make([]float64, x.Rows)
+	for j := range minDist {
+		minDist[j] = math.MaxFloat64
+		for c := 0; c < n-1; c++ {
+			minDist[j] = math.Min(minDist[j], x.Distance(j, cc[c].Center))
+		}
+	}
+	distSquared := make([]float64, x.Rows)
 
-	// 选择剩余的k-1个聚类中心
-	for i := 1; i < k; i++ {
-		// 计算每个点到最近聚类中心的距离的平方
-		distSquared := make([]float64, len(dataset))
+	// 选择剩余的聚类中心
+	for i := n; i < k; i++ {
 		sumDistSquared := 0.0
-
-		for j, point := range dataset {
-			// 找到最近的聚类中心
-			minDist := math.MaxFloat64
-			for c := 0; c < i; c++ {
-				dist := point.Distance(cc[c].Center)
-				if dist < minDist {
-					minDist = dist
-				}
+		for j := range distSquared {
+			// 只需与上一个加入的中心比较
+			if dist := x.Distance(j, cc[i-1].Center); dist < minDist[j] {
+				minDist[j] = dist
 			}
-			distSquared[j] = minDist
-			sumDistSquared += minDist
+			distSquared[j] = minDist[j] * x.Weight(j)
+			sumDistSquared += distSquared[j]
 		}
 
 		// 使用距离的平方作为权重选择下一个中心点
@@ -97,43 +153,96 @@ func initializeClustersKmeansPP(k int, dataset clusters.Observations) (clusters.
 			}
 		}
 
-		cc[i].Center = dataset[nextCenterIdx].Coordinates()
+		cc[i].Center = append(clusters.Coordinates(nil), x.Row(nextCenterIdx)...)
 	}
 
 	return cc, nil
 }
 
+// firstCenter 随机选择第一个聚类中心的下标，带权重的数据按权重选择
+func firstCenter(x *clusters.Matrix, r *rand.Rand) int {
+	if total := x.TotalWeight(); total != float64(x.Rows) {
+		return weightedChoice(x, r.Float64()*total)
+	}
+	return r.Intn(x.Rows)
+}
+
+// weightedChoice 返回累计权重首次达到target的数据下标
+func weightedChoice(x *clusters.Matrix, target float64) int {
+	sum := 0.0
+	for j := 0; j < x.Rows; j++ {
+		sum += x.Weight(j)
+		if sum >= target {
+			return j
+		}
+	}
+	return x.Rows - 1
+}
+
 // Partition executes the k-means algorithm on the given dataset and
 // partitions it into k clusters
 func (m Kmeans) Partition(dataset clusters.Observations, k int) (clusters.Clusters, error) {
+	cc, _, err := m.PartitionWithReport(dataset, k)
+	return cc, err
+}
+
+// PartitionWithReport partitions the dataset into k clusters like Partition
+// and reports how the iterations converged. The iterations run on a dense
+// copy of the coordinates, the observations are only touched again to build
+// the result.
+func (m Kmeans) PartitionWithReport(dataset clusters.Observations, k int) (clusters.Clusters, Report, error) {
 	if k > len(dataset) {
-		return clusters.Clusters{}, fmt.Errorf("the size of the data set must at least equal k")
+		return clusters.Clusters{}, Report{}, fmt.Errorf("the size of the data set must at least equal k")
 	}
 
-	// 使用k-means++算法初始化聚类中心
-	cc, err := initializeClustersKmeansPP(k, dataset)
+	r := m.random(k)
+	x := clusters.MatrixOf(dataset)
+
+	cc, err := m.initialize(k, x, r)
 	if err != nil {
-		return clusters.Clusters{}, err
+		return clusters.Clusters{}, Report{}, err
+	}
+	centers := make([]clusters.Coordinates, k)
+	for ci := range cc {
+		centers[ci] = cc[ci].Center
 	}
 
+	// points is the cluster of every observation, assigned the cluster it was
+	// assigned to in the current iteration, and extra the observations which
+	// were additionally appended to an empty cluster
 	points := make([]int, len(dataset))
-	changes := 1
+	assigned := make([]int, len(dataset))
+	extra := make([][]int, k)
+	sizes := make([]int, k)
+	total := x.TotalWeight()
 
-	for i := 0; changes > 0; i++ {
-		changes = 0
-		cc.Reset()
+	var bounds *hamerly
+	if m.algorithm == Hamerly {
+		bounds = newHamerly(x)
+	}
 
-		for p, point := range dataset {
-			ci := cc.Nearest(point)
-			cc[ci].Append(point)
-			if points[p] != ci {
-				points[p] = ci
-				changes++
-			}
+	var report Report
+	previous := math.Inf(1)
+	old := make([]clusters.Coordinates, k)
+	for report.Reason == "" {
+		report.Iterations++
+		for ci := range sizes {
+			sizes[ci], extra[ci] = 0, extra[ci][:0]
+		}
+		if bounds != nil {
+			bounds.update(centers)
 		}
 
-		for ci := 0; ci < len(cc); ci++ {
-			if len(cc[ci].Observations) == 0 {
+		changes, moved := m.assign(x, centers, bounds, points, assigned)
+		for _, ci := range assigned {
+			sizes[ci]++
+		}
+
+		// forced is set when an observation was moved to an empty cluster,
+		// the centers have not settled then
+		forced := false
+		for ci := 0; ci < k; ci++ {
+			if sizes[ci] == 0 {
 				// During the iterations, if any of the cluster centers has no
 				// data points associated with it, assign a random data point
 				// to it.
@@ -142,35 +251,166 @@ func (m Kmeans) Partition(dataset clusters.Observations, k int) (clusters.Cluste
 				for {
 					// find a cluster with at least two data points, otherwise
 					// we're just emptying one cluster to fill another
-					ri = rand.Intn(len(dataset)) //nolint:gosec // rand.Intn is good enough for this
-					if len(cc[points[ri]].Observations) > 1 {
+					ri = r.Intn(len(dataset)) //nolint:gosec // rand.Intn is good enough for this
+					if sizes[points[ri]] > 1 {
 						break
 					}
 				}
-				cc[ci].Append(dataset[ri])
+				extra[ci] = append(extra[ci], ri)
+				sizes[ci]++
 				points[ri] = ci
+				if bounds != nil {
+					bounds.move(ri, ci)
+				}
 
 				// Ensure that we always see at least one more iteration after
 				// randomly assigning a data point to a cluster
-				changes = len(dataset)
+				forced = true
 			}
 		}
 
-		if changes > 0 {
-			cc.Recenter()
+		report.Shift = 0
+		if changes > 0 || forced {
+			copy(old, centers)
+			m.recenter(x, centers, assigned, extra)
+			report.Shift = shift(old, centers)
 		}
-		if m.plotter != nil {
-			err := m.plotter.Plot(cc, i)
+		if m.config.Plotter != nil {
+			err := m.config.Plotter.Plot(collect(dataset, centers, assigned, extra), report.Iterations-1)
 			if err != nil {
-				return nil, fmt.Errorf("failed to plot chart: %s", err)
+				return nil, Report{}, fmt.Errorf("failed to plot chart: %s", err)
 			}
 		}
-		if i == m.iterationThreshold ||
-			changes < int(float64(len(dataset))*m.deltaThreshold) {
-			// fmt.Println("Aborting:", changes, int(float64(len(dataset))*m.TerminationThreshold))
-			break
+
+		var inertia float64
+		if m.config.InertiaTolerance > 0 {
+			inertia = m.inertia(x, centers, assigned, extra)
+		}
+		switch {
+		case forced:
+		case changes == 0:
+			report.Reason = StopConverged
+		case moved < math.Floor(total*m.config.MovedThreshold):
+			report.Reason = StopMoved
+		case m.config.ShiftTolerance > 0 && report.Shift <= m.config.ShiftTolerance:
+			report.Reason = StopShift
+		case m.config.InertiaTolerance > 0 && previous-inertia < m.config.InertiaTolerance*previous:
+			report.Reason = StopInertia
 		}
+		if report.Reason == "" && report.Iterations == m.config.MaxIterations {
+			report.Reason = StopMaxIterations
+		}
+		previous = inertia
 	}
 
-	return cc, nil
+	report.Inertia = m.inertia(x, centers, assigned, extra)
+	return collect(dataset, centers, assigned, extra), report, nil
+}
+
+// chunkSize is the number of observations a worker processes at once. The
+// partial results are combined in the order of the chunks, so the clusters
+// do not depend on the number of workers.
+const chunkSize = 1024
+
+// chunks returns the number of chunks of n observations
+func chunks(n int) int {
+	return (n + chunkSize - 1) / chunkSize
+}
+
+// assign assigns every observation to its nearest center, recording the
+// changed clusters in points. It returns the number and the weight of the
+// observations which changed clusters.
+func (m Kmeans) assign(x *clusters.Matrix, centers []clusters.Coordinates, bounds *hamerly, points, assigned []int) (int, float64) {
+	n := chunks(x.Rows)
+	changes, moved := make([]int, n), make([]float64, n)
+	parallel.Default.Run(m.workers, n, func(c int) {
+		for p := c * chunkSize; p < min((c+1)*chunkSize, x.Rows); p++ {
+			var ci int
+			if bounds != nil {
+				ci = bounds.nearest(x, centers, p)
+			} else {
+				ci = x.Nearest(p, centers)
+			}
+			assigned[p] = ci
+			if points[p] != ci {
+				points[p] = ci
+				changes[c]++
+				moved[c] += x.Weight(p)
+			}
+		}
+	})
+
+	var totalChanges int
+	var totalMoved float64
+	for c := range changes {
+		totalChanges += changes[c]
+		totalMoved += moved[c]
+	}
+	return totalChanges, totalMoved
+}
+
+// recenter moves every center to the weighted mean of its observations. Every
+// chunk is summed on its own, the sums of the chunks are added in order.
+// Clusters without observations keep their center.
+func (m Kmeans) recenter(x *clusters.Matrix, centers []clusters.Coordinates, assigned []int, extra [][]int) {
+	k := len(centers)
+	// partial holds the weighted sum of the coordinates followed by the
+	// weight, per chunk and cluster
+	partial := make([][]float64, chunks(x.Rows))
+	add := func(sums []float64, ci, p int) {
+		sum := sums[ci*(x.Stride+1) : (ci+1)*(x.Stride+1)]
+		w := x.Weight(p)
+		for j, v := range x.Row(p) {
+			sum[j] += w * v
+		}
+		sum[x.Stride] += w
+	}
+	parallel.Default.Run(m.workers, len(partial), func(c int) {
+		partial[c] = make([]float64, k*(x.Stride+1))
+		for p := c * chunkSize; p < min((c+1)*chunkSize, x.Rows); p++ {
+			add(partial[c], assigned[p], p)
+		}
+	})
+
+	sums := make([]float64, k*(x.Stride+1))
+	for _, chunk := range partial {
+		for i, v := range chunk {
+			sums[i] += v
+		}
+	}
+	for ci, ee := range extra {
+		for _, p := range ee {
+			add(sums, ci, p)
+		}
+	}
+
+	for ci := range centers {
+		sum := sums[ci*(x.Stride+1) : (ci+1)*(x.Stride+1)]
+		w := sum[x.Stride]
+		if w == 0 {
+			continue
+		}
+		center := make(clusters.Coordinates, x.Stride)
+		for j := range center {
+			center[j] = sum[j] / w
+		}
+		centers[ci] = center
+	}
+}
+
+// collect builds the clusters from the assignments
+func collect(dataset clusters.Observations, centers []clusters.Coordinates, assigned []int, extra [][]int) clusters.Clusters {
+	cc := make(clusters.Clusters, len(centers))
+	for ci := range cc {
+		cc[ci].Center = centers[ci]
+	}
+	for p, ci := range assigned {
+		cc[ci].Append(dataset[p])
+	}
+	for ci, ee := range extra {
+		for _, p := range ee {
+			cc[ci].Append(dataset[p])
+		}
+	}
+	return cc
 }
@@ -0,0 +1,136 @@
+package kmeans
+
+import (
+	"math"
+	"math/rand"
+	"rfm_cluster/pkg/clusters"
+	"testing"
+)
+
+// rfmMatrix returns n observations shaped like scored RFM data: three
+// dimensions between 1 and 5 around a few dense groups
+func rfmMatrix(n int, seed int64) *clusters.Matrix {
+	r := rand.New(rand.NewSource(seed))
+	groups := []clusters.Coordinates{{1.5, 1.5, 1.5}, {4.5, 4, 4.5}, {2, 4, 3}, {4, 1.5, 2}, {3, 3, 3}}
+	x := clusters.NewMatrix(n, 3, clusters.SquaredEuclidean{})
+	for i := 0; i < n; i++ {
+		g := groups[r.Intn(len(groups))]
+		for j := range g {
+			x.Row(i)[j] = min(5, max(1, g[j]+r.NormFloat64()*0.6))
+		}
+	}
+	return x
+}
+
+// labels returns the cluster of every row of the matrix the observations of
+// cc were taken from
+func labels(cc clusters.Clusters, n int) []int {
+	result := make([]int, n)
+	for ci, c := range cc {
+		for _, o := range c.Observations {
+			result[o.(clusters.MatrixRow).Index] = ci
+		}
+	}
+	return result
+}
+
+func TestHamerlyMatchesLloyd(t *testing.T) {
+	for _, n := range []int{500, 5000} {
+		dataset := rfmMatrix(n, int64(n)).Observations()
+		for _, seed := range []int64{1, 7, 42, 2024} {
+			for k := 2; k <= 10; k++ {
+				lloyd, err := New().WithSeed(seed).Partition(dataset, k)
+				if err != nil {
+					t.Fatal(err)
+				}
+				hamerly, err := New().WithSeed(seed).WithAlgorithm(Hamerly).Partition(dataset, k)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				expected, got := labels(lloyd, n), labels(hamerly, n)
+				for i := range expected {
+					if got[i] != expected[i] {
+						t.Fatalf("n=%d seed=%d k=%d: row %d assigned to %d, Lloyd assigned it to %d", n, seed, k, i, got[i], expected[i])
+					}
+				}
+			}
+		}
+	}
+}
+
+func benchmarkPartition(b *testing.B, algorithm Algorithm) {
+	dataset := rfmMatrix(100000, 1).Observations()
+	m := New().WithSeed(42).WithAlgorithm(algorithm).WithWorkers(1)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := m.Partition(dataset, 8); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLloyd(b *testing.B) {
+	benchmarkPartition(b, Lloyd)
+}
+
+func BenchmarkHamerly(b *testing.B) {
+	benchmarkPartition(b, Hamerly)
+}
+
+// uniformMatrix returns n observations spread evenly over a cube, on which
+// k-means needs many iterations with few observations changing clusters
+func uniformMatrix(n int, seed int64) *clusters.Matrix {
+	r := rand.New(rand.NewSource(seed))
+	x := clusters.NewMatrix(n, 3, clusters.SquaredEuclidean{})
+	for i := 0; i < n; i++ {
+		for j := range x.Row(i) {
+			x.Row(i)[j] = r.Float64() * 5
+		}
+	}
+	return x
+}
+
+func TestPartitionWithReportStopReasons(t *testing.T) {
+	dataset := uniformMatrix(2000, 1).Observations()
+	tests := []struct {
+		name   string
+		config Config
+		reason Reason
+	}{
+		{"converged", Config{MaxIterations: 1000}, StopConverged},
+		{"moved", Config{MaxIterations: 1000, MovedThreshold: 0.2}, StopMoved},
+		{"shift", Config{MaxIterations: 1000, ShiftTolerance: 0.05}, StopShift},
+		{"inertia", Config{MaxIterations: 1000, InertiaTolerance: 0.5}, StopInertia},
+		{"max iterations", Config{MaxIterations: 2}, StopMaxIterations},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := NewWithConfig(test.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc, report, err := m.WithSeed(42).PartitionWithReport(dataset, 5)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if report.Reason != test.reason {
+				t.Fatalf("stopped after %d iterations because of %q, expected %q", report.Iterations, report.Reason, test.reason)
+			}
+			if report.Iterations < 1 || report.Iterations > test.config.MaxIterations {
+				t.Fatalf("got %d iterations, expected between 1 and %d", report.Iterations, test.config.MaxIterations)
+			}
+			var inertia float64
+			for _, c := range cc {
+				for _, o := range c.Observations {
+					inertia += o.Distance(c.Center)
+				}
+			}
+			if math.Abs(report.Inertia-inertia) > 1e-9*inertia {
+				t.Fatalf("got inertia %v, the clusters have %v", report.Inertia, inertia)
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package kmeans
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"rfm_cluster/pkg/clusters"
+	"time"
+)
+
+// MiniBatch configuration/option struct for mini-batch k-means, which
+// updates the centers from small random batches instead of reassigning the
+// whole data set every iteration.
+// See: Sculley, "Web-Scale K-Means Clustering" (2010)
+type MiniBatch struct {
+	// batchSize is the number of observations drawn for every iteration
+	batchSize int
+	// decay is the exponent of the per center learning rate 1/n^decay, where
+	// n counts the observations the center has absorbed so far. 1 is the
+	// original per center average of Sculley, smaller values keep the centers
+	// moving for longer.
+	decay float64
+	// iterationThreshold aborts processing when the specified amount of
+	// batches was processed
+	iterationThreshold int
+	// tolerance is the relative improvement of the smoothed batch inertia
+	// below which a batch counts as no improvement
+	tolerance float64
+	// patience aborts processing after the specified amount of consecutive
+	// batches without improvement of the smoothed batch inertia
+	patience int
+	// seed makes the random choices of Partition reproducible, 0 seeds from
+	// the current time
+	seed int64
+}
+
+// NewMiniBatchWithOptions returns a MiniBatch configuration struct with
+// custom settings
+func NewMiniBatchWithOptions(batchSize int, decay float64, iterationThreshold int, tolerance float64, patience int) (MiniBatch, error) {
+	if batchSize < 1 {
+		return MiniBatch{}, fmt.Errorf("batch size must be at least 1")
+	}
+	if decay <= 0.5 || decay > 1 {
+		return MiniBatch{}, fmt.Errorf("decay is out of bounds (must be >0.5 and <=1.0)")
+	}
+	if iterationThreshold < 1 {
+		return MiniBatch{}, fmt.Errorf("iteration threshold must be at least 1")
+	}
+	if tolerance < 0 {
+		return MiniBatch{}, fmt.Errorf("tolerance must not be negative")
+	}
+	if patience < 1 {
+		return MiniBatch{}, fmt.Errorf("patience must be at least 1")
+	}
+
+	return MiniBatch{
+		batchSize:          batchSize,
+		decay:              decay,
+		iterationThreshold: iterationThreshold,
+		tolerance:          tolerance,
+		patience:           patience,
+	}, nil
+}
+
+// NewMiniBatch returns a MiniBatch configuration struct with the given batch
+// size and default settings for everything else
+func NewMiniBatch(batchSize int) (MiniBatch, error) {
+	return NewMiniBatchWithOptions(batchSize, 1, 500, 1e-4, 10)
+}
+
+// WithSeed returns a copy of the configuration whose random choices are
+// derived from seed. A seed of 0 restores the time based default.
+func (m MiniBatch) WithSeed(seed int64) MiniBatch {
+	m.seed = seed
+	return m
+}
+
+func (m MiniBatch) random(k int) *rand.Rand {
+	if m.seed == 0 {
+		return rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return rand.New(rand.NewSource(m.seed + int64(k)))
+}
+
+// Partition executes the mini-batch k-means algorithm on the given dataset
+// and partitions it into k clusters. The centers are learned from random
+// batches; every observation is assigned to its nearest center once at the
+// end.
+func (m MiniBatch) Partition(dataset clusters.Observations, k int) (clusters.Clusters, error) {
+	if k < 1 {
+		return clusters.Clusters{}, fmt.Errorf("k must be greater than 0")
+	}
+	if k > len(dataset) {
+		return clusters.Clusters{}, fmt.Errorf("the size of the data set must at least equal k")
+	}
+
+	r := m.random(k)
+
+	x := clusters.MatrixOf(dataset)
+
+	// k-means++ on a random sample is enough to seed the centers, the centers
+	// are copies and may be updated in place
+	cc, err := initializeClustersKmeansPP(k, clusters.MatrixOf(sample(dataset, max(3*m.batchSize, 3*k), r)), r)
+	if err != nil {
+		return clusters.Clusters{}, err
+	}
+	centers := make([]clusters.Coordinates, k)
+	for ci := range cc {
+		centers[ci] = cc[ci].Center
+	}
+
+	counts := make([]float64, k)
+	batch := make([]int, min(m.batchSize, len(dataset)))
+	nearest := make([]int, len(batch))
+	// exponentially weighted average of the batch inertia per observation
+	smoothed, best := -1.0, math.MaxFloat64
+	alpha := math.Min(2*float64(len(batch))/float64(len(dataset)+1), 1)
+	stale := 0
+
+	for i := 0; i < m.iterationThreshold; i++ {
+		inertia := 0.0
+		for b := range batch {
+			batch[b] = r.Intn(len(dataset)) //nolint:gosec // rand.Intn is good enough for this
+			nearest[b] = x.Nearest(batch[b], centers)
+			inertia += clusters.SquaredEuclidean{}.Distance(x.Row(batch[b]), centers[nearest[b]])
+		}
+		inertia /= float64(len(batch))
+
+		// gradient step with a learning rate decaying per center
+		for b, p := range batch {
+			ci := nearest[b]
+			counts[ci]++
+			rate := math.Pow(counts[ci], -m.decay)
+			center := centers[ci]
+			for d, v := range x.Row(p) {
+				center[d] += rate * (v - center[d])
+			}
+		}
+
+		if smoothed < 0 {
+			smoothed = inertia
+		} else {
+			smoothed = (1-alpha)*smoothed + alpha*inertia
+		}
+		if smoothed < best*(1-m.tolerance) {
+			best = smoothed
+			stale = 0
+		} else if stale++; stale >= m.patience {
+			break
+		}
+	}
+
+	assign(cc, x, dataset, r)
+	return cc, nil
+}
+
+// assign appends every observation to its nearest center and recenters the
+// clusters. Empty clusters get a random observation of a cluster with at
+// least two observations.
+func assign(cc clusters.Clusters, x *clusters.Matrix, dataset clusters.Observations, r *rand.Rand) {
+	centers := make([]clusters.Coordinates, len(cc))
+	for ci := range cc {
+		centers[ci] = cc[ci].Center
+	}
+	points := make([]int, len(dataset))
+	cc.Reset()
+	for p := range points {
+		points[p] = x.Nearest(p, centers)
+	}
+
+	sizes := make([]int, len(cc))
+	for _, ci := range points {
+		sizes[ci]++
+	}
+	for ci := range cc {
+		for sizes[ci] == 0 {
+			ri := r.Intn(len(dataset)) //nolint:gosec // rand.Intn is good enough for this
+			if sizes[points[ri]] > 1 {
+				sizes[points[ri]]--
+				points[ri] = ci
+				sizes[ci]++
+			}
+		}
+	}
+
+	for p, point := range dataset {
+		cc[points[p]].Append(point)
+	}
+	cc.Recenter()
+}
+
+// sample returns up to n observations drawn from dataset without
+// replacement, the data set itself when it is not larger than n
+func sample(dataset clusters.Observations, n int, r *rand.Rand) clusters.Observations {
+	if len(dataset) <= n {
+		return dataset
+	}
+	result := make(clusters.Observations, n)
+	for i, p := range r.Perm(len(dataset))[:n] {
+		result[i] = dataset[p]
+	}
+	return result
+}
+
+// Inertia returns the sum of the squared euclidean distances of all
+// observations to the center of their cluster, which is what k-means
+// minimizes whatever metric assigns the observations
+func Inertia(cc clusters.Clusters) float64 {
+	inertia := 0.0
+	for _, c := range cc {
+		for _, o := range c.Observations {
+			inertia += clusters.WeightOf(o) * clusters.SquaredEuclidean{}.Distance(o.Coordinates(), c.Center)
+		}
+	}
+	return inertia
+}
+
+// Comparison holds the inertia of mini-batch and full k-means on the same
+// random sample
+type Comparison struct {
+	K         int     `json:"k"`
+	Sample    int     `json:"sample"`
+	MiniBatch float64 `json:"mini_batch"`
+	Full      float64 `json:"full"`
+}
+
+// RelativeDifference returns by how much the mini-batch inertia exceeds the
+// full k-means inertia, relative to the latter
+func (c Comparison) RelativeDifference() float64 {
+	if c.Full == 0 {
+		return 0
+	}
+	return (c.MiniBatch - c.Full) / c.Full
+}
+
+// Compare partitions a random sample of at most sampleSize observations into
+// k clusters with both mini-batch and full k-means and reports their
+// inertia, so the quality lost by batching can be judged on data sets too
+// large to run full k-means on.
+func (m MiniBatch) Compare(full Kmeans, dataset clusters.Observations, k, sampleSize int) (Comparison, error) {
+	s := sample(dataset, sampleSize, m.random(k))
+
+	mini, err := m.Partition(s, k)
+	if err != nil {
+		return Comparison{}, err
+	}
+	cc, err := full.Partition(s, k)
+	if err != nil {
+		return Comparison{}, err
+	}
+
+	return Comparison{K: k, Sample: len(s), MiniBatch: Inertia(mini), Full: Inertia(cc)}, nil
+}
@@ -0,0 +1,143 @@
+package kmeans
+
+import (
+	"fmt"
+	"rfm_cluster/pkg/clusters"
+	"slices"
+)
+
+// Online updates a set of centers one observation at a time, the sequential
+// k-means of MacQueen. Every observation moves its nearest center towards it
+// by its share of the weight the center absorbed so far. A decay below 1
+// discounts the absorbed weight before every update, so recent observations
+// count more and the centers follow a drifting population.
+// See: MacQueen, "Some methods for classification and analysis of
+// multivariate observations" (1967)
+//
+// The fields are exported so the state can be checkpointed and restored with
+// encoding/json.
+type Online struct {
+	// Centers are the current centers
+	Centers []clusters.Coordinates `json:"centers"`
+	// Weights holds the decayed weight every center absorbed
+	Weights []float64 `json:"weights"`
+	// Reference holds the centers of the last full fit, the drift is measured
+	// against them
+	Reference []clusters.Coordinates `json:"reference"`
+	// Decay in (0, 1] multiplies the weights before every update, 1 keeps
+	// the centers at the running means
+	Decay float64 `json:"decay"`
+	// Updates counts the observations absorbed since the last full fit
+	Updates int `json:"updates"`
+}
+
+// NewOnline returns an online state starting from the given centers, which
+// already absorbed the given weights, for example the sizes of the clusters
+// of a full fit
+func NewOnline(centers []clusters.Coordinates, weights []float64, decay float64) (*Online, error) {
+	if decay <= 0 || decay > 1 {
+		return nil, fmt.Errorf("decay must be in (0, 1]")
+	}
+
+	o := &Online{Decay: decay}
+	if err := o.Rebase(centers, weights); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Rebase replaces the centers and their weights with the result of a full
+// fit, which also becomes the reference of the drift
+func (o *Online) Rebase(centers []clusters.Coordinates, weights []float64) error {
+	if len(centers) == 0 {
+		return fmt.Errorf("at least one center is required")
+	}
+	if len(weights) != len(centers) {
+		return fmt.Errorf("expected %d weights, got %d", len(centers), len(weights))
+	}
+	for _, c := range centers {
+		if len(c) != len(centers[0]) {
+			return fmt.Errorf("the centers must have %d dimensions", len(centers[0]))
+		}
+	}
+
+	o.Centers = make([]clusters.Coordinates, len(centers))
+	o.Reference = make([]clusters.Coordinates, len(centers))
+	for ci, c := range centers {
+		o.Centers[ci] = slices.Clone(c)
+		o.Reference[ci] = slices.Clone(c)
+	}
+	o.Weights = slices.Clone(weights)
+	o.Updates = 0
+	return nil
+}
+
+// Clone returns a deep copy of the state, which Add and Remove can change
+// without affecting o
+func (o *Online) Clone() *Online {
+	c := *o
+	c.Centers = make([]clusters.Coordinates, len(o.Centers))
+	for ci, center := range o.Centers {
+		c.Centers[ci] = slices.Clone(center)
+	}
+	c.Reference = make([]clusters.Coordinates, len(o.Reference))
+	for ci, center := range o.Reference {
+		c.Reference[ci] = slices.Clone(center)
+	}
+	c.Weights = slices.Clone(o.Weights)
+	return &c
+}
+
+// Nearest returns the index of the center nearest to the observation
+func (o *Online) Nearest(observation clusters.Observation) int {
+	var ci int
+	dist := -1.0
+	for j, center := range o.Centers {
+		if d := observation.Distance(center); dist < 0 || d < dist {
+			dist = d
+			ci = j
+		}
+	}
+	return ci
+}
+
+// Add moves the center nearest to the observation towards it and returns the
+// index of that center
+func (o *Online) Add(observation clusters.Observation) int {
+	for j := range o.Weights {
+		o.Weights[j] *= o.Decay
+	}
+
+	ci := o.Nearest(observation)
+	w := clusters.WeightOf(observation)
+	o.Weights[ci] += w
+	step := w / o.Weights[ci]
+	for d, v := range observation.Coordinates() {
+		o.Centers[ci][d] += step * (v - o.Centers[ci][d])
+	}
+	o.Updates++
+	return ci
+}
+
+// Remove takes an observation Add assigned to center ci out of the center
+// again, for example when a customer changed. With a decay below 1 the
+// observation already lost part of its weight, so the removal is
+// approximate. The center is left alone when it would lose all its weight.
+func (o *Online) Remove(observation clusters.Observation, ci int) {
+	w := clusters.WeightOf(observation)
+	if o.Weights[ci] <= w {
+		return
+	}
+
+	o.Weights[ci] -= w
+	step := w / o.Weights[ci]
+	for d, v := range observation.Coordinates() {
+		o.Centers[ci][d] -= step * (v - o.Centers[ci][d])
+	}
+}
+
+// Drift returns the largest euclidean distance a center moved away from its
+// reference since the last full fit
+func (o *Online) Drift() float64 {
+	return shift(o.Reference, o.Centers)
+}
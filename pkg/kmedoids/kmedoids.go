@@ -0,0 +1,307 @@
+// Package kmedoids implements the k-medoids clustering algorithm. Small data
+// sets are partitioned with PAM (Partitioning Around Medoids), large ones with
+// CLARA, which runs PAM on random samples and keeps the best set of medoids.
+// See: https://en.wikipedia.org/wiki/K-medoids
+package kmedoids
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"rfm_cluster/pkg/clusters"
+	"slices"
+	"time"
+)
+
+// Kmedoids configuration/option struct
+type Kmedoids struct {
+	// claraThreshold is the data set size above which CLARA is used instead
+	// of running PAM on the whole data set
+	claraThreshold int
+	// samples is the number of random samples CLARA draws
+	samples int
+	// sampleSize is the size of every CLARA sample, 0 uses 40+2k as
+	// suggested by Kaufman and Rousseeuw
+	sampleSize int
+	// iterationThreshold aborts the PAM swap phase when the specified amount
+	// of iterations was reached
+	iterationThreshold int
+	// seed makes the random choices of Partition reproducible, 0 seeds from
+	// the current time
+	seed int64
+}
+
+// NewWithOptions returns a Kmedoids configuration struct with custom settings
+func NewWithOptions(claraThreshold, samples, sampleSize int) (Kmedoids, error) {
+	if claraThreshold < 1 {
+		return Kmedoids{}, fmt.Errorf("clara threshold must be at least 1")
+	}
+	if samples < 1 {
+		return Kmedoids{}, fmt.Errorf("clara needs at least one sample")
+	}
+	if sampleSize < 0 {
+		return Kmedoids{}, fmt.Errorf("sample size must not be negative")
+	}
+
+	return Kmedoids{
+		claraThreshold:     claraThreshold,
+		samples:            samples,
+		sampleSize:         sampleSize,
+		iterationThreshold: 100,
+	}, nil
+}
+
+// New returns a Kmedoids configuration struct with default settings
+func New() Kmedoids {
+	m, _ := NewWithOptions(1000, 5, 0)
+	return m
+}
+
+// WithSeed returns a copy of the configuration whose random choices are
+// derived from seed. A seed of 0 restores the time based default.
+func (m Kmedoids) WithSeed(seed int64) Kmedoids {
+	m.seed = seed
+	return m
+}
+
+func (m Kmedoids) random(k int) *rand.Rand {
+	if m.seed == 0 {
+		return rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return rand.New(rand.NewSource(m.seed + int64(k)))
+}
+
+// Partition executes the k-medoids algorithm on the given dataset and
+// partitions it into k clusters. The center of every cluster is the
+// coordinates of its medoid, which is always one of the observations.
+func (m Kmedoids) Partition(dataset clusters.Observations, k int) (clusters.Clusters, error) {
+	if k < 1 {
+		return clusters.Clusters{}, fmt.Errorf("k must be greater than 0")
+	}
+	if k > len(dataset) {
+		return clusters.Clusters{}, fmt.Errorf("the size of the data set must at least equal k")
+	}
+
+	x := clusters.MatrixOf(dataset)
+	var medoids []int
+	if len(dataset) <= m.claraThreshold {
+		medoids = m.pam(dataset, k)
+	} else {
+		medoids = m.clara(dataset, x, k)
+	}
+
+	return assign(dataset, x, medoids), nil
+}
+
+// Medoids returns the index of the medoid of every cluster in cc, that is
+// the observation whose coordinates equal the cluster center. -1 marks
+// clusters without a matching observation.
+func Medoids(cc clusters.Clusters) []int {
+	result := make([]int, len(cc))
+	for ci, c := range cc {
+		result[ci] = -1
+		for i, o := range c.Observations {
+			if o.Distance(c.Center) == 0 {
+				result[ci] = i
+				break
+			}
+		}
+	}
+	return result
+}
+
+// clara runs PAM on several random samples and returns the medoids (as
+// indices into dataset) with the lowest total cost on the whole data set,
+// measured on x, the matrix of the data set
+func (m Kmedoids) clara(dataset clusters.Observations, x *clusters.Matrix, k int) []int {
+	r := m.random(k)
+
+	size := m.sampleSize
+	if size == 0 {
+		size = 40 + 2*k
+	}
+	size = min(max(size, k), len(dataset))
+
+	var best []int
+	bestCost := math.Inf(1)
+	for s := 0; s < m.samples; s++ {
+		indices := r.Perm(len(dataset))[:size]
+		sample := make(clusters.Observations, size)
+		for i, idx := range indices {
+			sample[i] = dataset[idx]
+		}
+
+		medoids := m.pam(sample, k)
+		for i, idx := range medoids {
+			medoids[i] = indices[idx]
+		}
+
+		if cost := totalCost(x, medoids); cost < bestCost {
+			best, bestCost = medoids, cost
+		}
+	}
+
+	return best
+}
+
+// pam runs the BUILD and SWAP phases of PAM and returns the indices of the
+// medoids. The swap phase evaluates all medoids for a candidate in a single
+// pass over the data (FastPAM1).
+func (m Kmedoids) pam(dataset clusters.Observations, k int) []int {
+	n := len(dataset)
+	dist := distanceMatrix(dataset)
+	weight := make([]float64, n)
+	for j, o := range dataset {
+		weight[j] = clusters.WeightOf(o)
+	}
+
+	// BUILD: greedily add the observation that decreases the cost the most
+	medoids := make([]int, 0, k)
+	isMedoid := make([]bool, n)
+	nearest := make([]float64, n)
+	for i := range nearest {
+		nearest[i] = math.Inf(1)
+	}
+	for len(medoids) < k {
+		best, bestGain := -1, math.Inf(-1)
+		for c := 0; c < n; c++ {
+			if isMedoid[c] {
+				continue
+			}
+			var gain float64
+			for j := 0; j < n; j++ {
+				if d := dist[c][j]; d < nearest[j] {
+					if math.IsInf(nearest[j], 1) {
+						gain -= weight[j] * d
+					} else {
+						gain += weight[j] * (nearest[j] - d)
+					}
+				}
+			}
+			if gain > bestGain {
+				best, bestGain = c, gain
+			}
+		}
+
+		medoids = append(medoids, best)
+		isMedoid[best] = true
+		for j := 0; j < n; j++ {
+			nearest[j] = math.Min(nearest[j], dist[best][j])
+		}
+	}
+
+	// SWAP: replace a medoid by a non-medoid as long as the cost decreases
+	nearestIdx := make([]int, n)
+	first := make([]float64, n)
+	second := make([]float64, n)
+	delta := make([]float64, k)
+	for it := 0; it < m.iterationThreshold; it++ {
+		for j := 0; j < n; j++ {
+			nearestIdx[j], first[j], second[j] = nearestTwo(dist[j], medoids)
+		}
+
+		bestDelta, bestM, bestO := 0.0, -1, -1
+		for o := 0; o < n; o++ {
+			if isMedoid[o] {
+				continue
+			}
+
+			for i := range delta {
+				delta[i] = 0
+			}
+			var shared float64
+			for j := 0; j < n; j++ {
+				doj := dist[o][j]
+				// o would become the nearest medoid of j, whichever medoid
+				// is removed
+				shared += weight[j] * math.Min(doj-first[j], 0)
+				// removing the nearest medoid of j moves j to o or to its
+				// second nearest medoid
+				delta[nearestIdx[j]] += weight[j] * (math.Min(doj, second[j]) - first[j] - math.Min(doj-first[j], 0))
+			}
+
+			for i := range delta {
+				if d := shared + delta[i]; d < bestDelta {
+					bestDelta, bestM, bestO = d, i, o
+				}
+			}
+		}
+
+		// stop when no swap improves the cost noticeably
+		if bestM < 0 || bestDelta > -1e-9 {
+			break
+		}
+		isMedoid[medoids[bestM]] = false
+		isMedoid[bestO] = true
+		medoids[bestM] = bestO
+	}
+
+	return medoids
+}
+
+// nearestTwo returns the position in medoids of the medoid nearest to a row
+// of the distance matrix, along with the nearest and second nearest distance
+func nearestTwo(row []float64, medoids []int) (int, float64, float64) {
+	idx := 0
+	first, second := math.Inf(1), math.Inf(1)
+	for i, m := range medoids {
+		d := row[m]
+		if d < first {
+			idx, first, second = i, d, first
+		} else if d < second {
+			second = d
+		}
+	}
+	return idx, first, second
+}
+
+// distanceMatrix precomputes the distances between all observations. The
+// coordinates are copied into a clusters.Matrix once, so measuring a
+// distance does not allocate.
+func distanceMatrix(dataset clusters.Observations) [][]float64 {
+	n := len(dataset)
+	x := clusters.MatrixOf(dataset)
+
+	dist := make([][]float64, n)
+	flat := make([]float64, n*n)
+	for i := range dist {
+		dist[i] = flat[i*n : (i+1)*n]
+	}
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			d := x.Distance(i, x.Row(j))
+			dist[i][j], dist[j][i] = d, d
+		}
+	}
+	return dist
+}
+
+// totalCost sums the distance of every row of x to its nearest medoid,
+// weighted by the weight of the row
+func totalCost(x *clusters.Matrix, medoids []int) float64 {
+	var cost float64
+	for i := 0; i < x.Rows; i++ {
+		d := math.Inf(1)
+		for _, m := range medoids {
+			d = math.Min(d, x.Distance(i, x.Row(m)))
+		}
+		cost += x.Weight(i) * d
+	}
+	return cost
+}
+
+// assign builds the clusters around the given medoids
+func assign(dataset clusters.Observations, x *clusters.Matrix, medoids []int) clusters.Clusters {
+	cc := make(clusters.Clusters, len(medoids))
+	centers := make([]clusters.Coordinates, len(medoids))
+	for i, m := range medoids {
+		cc[i].Center = slices.Clone(x.Row(m))
+		centers[i] = cc[i].Center
+	}
+
+	for i, o := range dataset {
+		ci := x.Nearest(i, centers)
+		cc[ci].Append(o)
+	}
+	return cc
+}
@@ -0,0 +1,79 @@
+// Package parallel shares a budget of goroutines between the concurrent
+// parts of an analysis, so that nested parallelism does not oversubscribe
+// the machine
+package parallel
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Budget limits how many goroutines do CPU bound work at the same time. A
+// goroutine holds one token while it works.
+type Budget struct {
+	tokens chan struct{}
+}
+
+// NewBudget returns a budget of n tokens, at least one
+func NewBudget(n int) *Budget {
+	return &Budget{tokens: make(chan struct{}, max(n, 1))}
+}
+
+// Default is the budget shared by the whole process, one token per CPU. It
+// may be replaced before any work starts.
+var Default = NewBudget(runtime.GOMAXPROCS(0))
+
+// Size returns the number of tokens of the budget
+func (b *Budget) Size() int {
+	return cap(b.tokens)
+}
+
+// Acquire waits for a token
+func (b *Budget) Acquire() {
+	b.tokens <- struct{}{}
+}
+
+// TryAcquire takes a token if one is free and reports whether it did
+func (b *Budget) TryAcquire() bool {
+	select {
+	case b.tokens <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Release returns a token taken by Acquire or TryAcquire
+func (b *Budget) Release() {
+	<-b.tokens
+}
+
+// Run calls fn once for every chunk in [0, chunks) and returns when all calls
+// returned. The calling goroutine works on the chunks itself, it is helped by
+// up to workers-1 goroutines for which a token is free right away. Run never
+// waits for a token, so it is safe to call while holding one.
+func (b *Budget) Run(workers, chunks int, fn func(chunk int)) {
+	var next atomic.Int64
+	work := func() {
+		for {
+			c := int(next.Add(1)) - 1
+			if c >= chunks {
+				return
+			}
+			fn(c)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for helpers := 0; helpers < min(workers, chunks)-1 && b.TryAcquire(); helpers++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer b.Release()
+			work()
+		}()
+	}
+	work()
+	wg.Wait()
+}
@@ -3,15 +3,18 @@ package plotter
 import (
 	"bytes"
 	"fmt"
-	"os"
+	"rfm_cluster/pkg/artifact"
 	"rfm_cluster/pkg/clusters"
 
 	"github.com/wcharczuk/go-chart/v2"
 	"github.com/wcharczuk/go-chart/v2/drawing"
 )
 
-// SimplePlotter is the default standard plotter for 2-dimensional data sets
+// SimplePlotter is the default standard plotter for 2-dimensional data sets.
+// Charts are saved as artifacts of the run RunID in Store.
 type SimplePlotter struct {
+	Store artifact.Store
+	RunID string
 }
 
 // A monokai-ish color palette
@@ -28,8 +31,12 @@ var colors = []drawing.Color{
 	drawing.ColorFromHex("4b7509"),
 }
 
-// Plot draw a 2-dimensional data set into a PNG file named {k_iteration}.png
+// Plot draw a 2-dimensional data set into a PNG artifact named {k_iteration}.png
 func (p SimplePlotter) Plot(cc clusters.Clusters, iteration int) error {
+	if p.Store == nil {
+		return fmt.Errorf("no artifact store configured")
+	}
+
 	var series []chart.Series
 
 	// draw data points
@@ -74,5 +81,13 @@ func (p SimplePlotter) Plot(cc clusters.Clusters, iteration int) error {
 		return err
 	}
 
-	return os.WriteFile(fmt.Sprintf("%d_%d.png", len(cc), iteration), buffer.Bytes(), 0777)
+	w, err := p.Store.Create(p.RunID, fmt.Sprintf("%d_%d.png", len(cc), iteration))
+	if err != nil {
+		return err
+	}
+	if _, err := buffer.WriteTo(w); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
 }
@@ -5,6 +5,7 @@ package silhouette
 import (
 	"math"
 	"rfm_cluster/pkg/clusters"
+	"rfm_cluster/pkg/parallel"
 	"sync"
 )
 
@@ -47,28 +48,40 @@ func Scores(data clusters.Observations, kmax int, m Partitioner) ([]KScore, erro
 
 	waitGroup := sync.WaitGroup{}
 	lock := sync.RWMutex{}
+	var firstErr error
 	for k := 2; k <= kmax; k++ {
 		index := k - 2
 		waitGroup.Add(1)
 		go func(index int) {
 			defer waitGroup.Done()
+			// every k holds a token of the shared budget while partitioning,
+			// the partitioner only adds workers for free tokens
+			parallel.Default.Acquire()
+			defer parallel.Default.Release()
 			cc, s, err := Score(data, k, m)
+
+			lock.Lock()
+			defer lock.Unlock()
 			if err != nil {
-				panic(err)
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
 			}
 
-			lock.Lock()
 			r[index] = KScore{
 				Clusters: cc,
 				K:        k,
 				Score:    s,
 			}
-			lock.Unlock()
 		}(index)
 	}
 
 	waitGroup.Wait()
 
+	if firstErr != nil {
+		return nil, firstErr
+	}
 	return r, nil
 }
 
@@ -80,17 +93,108 @@ func Score(data clusters.Observations, k int, m Partitioner) (clusters.Clusters,
 		return cc, -1.0, err
 	}
 
-	var si float64
-	var sc int64
+	return cc, Average(cc), nil
+}
+
+// Average calculates the mean silhouette of all observations in the given
+// clusters, weighted by their weights. Fewer than two clusters have no
+// silhouette, 0 is returned.
+func Average(cc clusters.Clusters) float64 {
+	if len(cc) < 2 {
+		return 0
+	}
+
+	var dataset clusters.Observations
+	var labels []int
 	for ci, c := range cc {
-		for _, p := range c.Observations {
-			ai := clusters.AverageDistance(p, c.Observations)
-			_, bi := cc.Neighbour(p, ci)
+		dataset = append(dataset, c.Observations...)
+		for range c.Observations {
+			labels = append(labels, ci)
+		}
+	}
+	return AverageMatrix(clusters.MatrixOf(dataset), labels, len(cc))
+}
+
+// AverageMatrix calculates the mean silhouette of the rows of x, weighted by
+// their weights, where row i belongs to the cluster labels[i] of k clusters.
+// It yields the same value as Average on the same clusters.
+func AverageMatrix(x *clusters.Matrix, labels []int, k int) float64 {
+	if k < 2 {
+		return 0
+	}
+
+	var si float64
+	var sc float64
+	for i, s := range PointsMatrix(x, labels, k) {
+		w := x.Weight(i)
+		si += w * s
+		sc += w
+	}
 
-			si += (bi - ai) / math.Max(ai, bi)
-			sc++
+	if sc == 0 {
+		return 0
+	}
+	return si / sc
+}
+
+// PointsMatrix calculates the silhouette of every row of x like Point, where
+// row i belongs to the cluster labels[i] of k clusters. The distances are
+// measured on the rows directly, nothing is allocated per distance.
+func PointsMatrix(x *clusters.Matrix, labels []int, k int) []float64 {
+	result := make([]float64, x.Rows)
+	// the weighted sum of the distances of a row to every cluster, and the
+	// weight of the rows counted in it
+	sums := make([]float64, k)
+	weights := make([]float64, k)
+	average := func(ci int) float64 {
+		if weights[ci] == 0 {
+			return 0
+		}
+		return sums[ci] / weights[ci]
+	}
+
+	for i := range result {
+		clear(sums)
+		clear(weights)
+		row := x.Row(i)
+		for j := 0; j < x.Rows; j++ {
+			// observations at the same coordinates are left out, like in
+			// clusters.AverageDistance
+			d := x.Metric.Distance(row, x.Row(j))
+			if d == 0 {
+				continue
+			}
+			w := x.Weight(j)
+			sums[labels[j]] += w * d
+			weights[labels[j]] += w
+		}
+
+		// the nearest other cluster, like clusters.Clusters.Neighbour
+		ai, bi, nc := average(labels[i]), 0.0, -1
+		for ci := 0; ci < k; ci++ {
+			if ci == labels[i] {
+				continue
+			}
+			if d := average(ci); nc < 0 || d < bi {
+				nc, bi = ci, d
+			}
+		}
+
+		if m := math.Max(ai, bi); m > 0 {
+			result[i] = (bi - ai) / m
 		}
 	}
+	return result
+}
 
-	return cc, si / float64(sc), nil
+// Point calculates the silhouette of a single observation p which belongs to
+// the cluster with index ci
+func Point(cc clusters.Clusters, ci int, p clusters.Observation) float64 {
+	ai := clusters.AverageDistance(p, cc[ci].Observations)
+	_, bi := cc.Neighbour(p, ci)
+
+	if m := math.Max(ai, bi); m > 0 {
+		return (bi - ai) / m
+	}
+	return 0
 }
@@ -0,0 +1,74 @@
+package silhouette
+
+import (
+	"math"
+	"math/rand"
+	"rfm_cluster/pkg/clusters"
+	"testing"
+)
+
+// scores is an observation of three scores whose Coordinates returns a new
+// slice on every call, as the RFM records of the models package do
+type scores [3]float64
+
+func (s scores) Coordinates() clusters.Coordinates {
+	return clusters.Coordinates{s[0], s[1], s[2]}
+}
+
+func (s scores) Distance(point clusters.Coordinates) float64 {
+	return clusters.SquaredEuclidean{}.Distance(s.Coordinates(), point)
+}
+
+// testClusters returns n observations split into k clusters by their first
+// score
+func testClusters(n, k int) clusters.Clusters {
+	r := rand.New(rand.NewSource(1))
+	cc := make(clusters.Clusters, k)
+	for i := 0; i < n; i++ {
+		o := scores{1 + 4*r.Float64(), 1 + 4*r.Float64(), 1 + 4*r.Float64()}
+		cc[min(int((o[0]-1)/4*float64(k)), k-1)].Append(o)
+	}
+	cc.Recenter()
+	return cc
+}
+
+// averagePoints is the mean silhouette computed one observation at a time
+// with Point, the path Average took before it measured on a matrix
+func averagePoints(cc clusters.Clusters) float64 {
+	var sum float64
+	var n int
+	for ci, c := range cc {
+		for _, o := range c.Observations {
+			sum += Point(cc, ci, o)
+			n++
+		}
+	}
+	return sum / float64(n)
+}
+
+func TestAverageMatchesPoint(t *testing.T) {
+	for k := 2; k <= 6; k++ {
+		cc := testClusters(500, k)
+		if got, expected := Average(cc), averagePoints(cc); math.Abs(got-expected) > 1e-9 {
+			t.Fatalf("k=%d: got %v, expected %v", k, got, expected)
+		}
+	}
+}
+
+func BenchmarkAveragePoints(b *testing.B) {
+	cc := testClusters(1000, 5)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		averagePoints(cc)
+	}
+}
+
+func BenchmarkAverageMatrix(b *testing.B) {
+	cc := testClusters(1000, 5)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Average(cc)
+	}
+}